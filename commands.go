@@ -0,0 +1,391 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"i2pgit.org/idk/jump-transparency/lib"
+)
+
+// command is a subcommand which works on hosts files without starting the
+// server.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"export", "convert a hosts file to another format", exportCommand},
+	{"import", "convert a file in another format to a hosts file", importCommand},
+	{"convert", "convert a file from one format to another", convertCommand},
+	{"diff", "show the hosts added, removed and changed between two hosts files", diffCommand},
+	{"merge", "join hosts files, keeping the first entry for each hostname", mergeCommand},
+	{"lint", "report the lines of hosts files which would be rejected", lintCommand},
+	{"verify", "check the signatures of the signed entries of hosts files", verifyCommand},
+	{"trust", "compare a hosts file against peers' hosts files", trustCommand},
+	{"report", "write the trust chart of saved peers' hosts files as a static site", reportCommand},
+}
+
+// lookupCommand returns the subcommand called name, or nil.
+func lookupCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// usage prints the flags of the server and the subcommands.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags]\n       %s <command> [flags] [files]\n\nCommands:\n", os.Args[0], os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-10s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(out, "\nFlags:")
+	flag.PrintDefaults()
+}
+
+// openInput opens the file named by args, or standard input if there is
+// none.
+func openInput(args []string) (io.ReadCloser, error) {
+	switch len(args) {
+	case 0:
+		return ioutil.NopCloser(os.Stdin), nil
+	case 1:
+		return os.Open(args[0])
+	}
+	return nil, fmt.Errorf("expected one input file, got %d", len(args))
+}
+
+// writeOutput writes data to the file output, or to standard output if it is
+// empty.
+func writeOutput(output string, data []byte) error {
+	if output == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(output, data, 0644)
+}
+
+// readHosts parses the hosts file named file, which must exist.
+func readHosts(file string) (*jump.HostsTxt, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ht, err := jump.ReadHostsTxt(f, jump.DefaultFeedLimits())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	return ht, nil
+}
+
+func formatUsage() string {
+	return "Format: " + strings.Join(jump.Formats, ", ")
+}
+
+func exportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", jump.FormatAddresses, formatUsage())
+	output := fs.String("o", "", "File to write to instead of standard output")
+	fs.Parse(args)
+	in, err := openInput(fs.Args())
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	ht, err := jump.ReadHostsTxt(in, jump.DefaultFeedLimits())
+	if err != nil {
+		return err
+	}
+	data, err := ht.Export(*format)
+	if err != nil {
+		return err
+	}
+	return writeOutput(*output, data)
+}
+
+func importCommand(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", jump.FormatAddresses, formatUsage())
+	known := fs.String("known", "hosts.txt", "Hosts file to resolve the base32 addresses of an addresses.csv against")
+	output := fs.String("o", "", "File to write to instead of standard output")
+	fs.Parse(args)
+	in, err := openInput(fs.Args())
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	var destinations *jump.HostsTxt
+	if *format == jump.FormatAddresses {
+		if destinations, err = readHosts(*known); err != nil {
+			return err
+		}
+	}
+	ht, err := jump.Import(in, *format, destinations, jump.DefaultFeedLimits())
+	if err != nil {
+		return err
+	}
+	if n := ht.RejectedLines(); n > 0 {
+		fmt.Fprintf(os.Stderr, "Rejected %d lines: %v\n", n, ht.Rejected)
+	}
+	return writeOutput(*output, ht.HostsFile())
+}
+
+func convertCommand(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	from := fs.String("from", jump.FormatHosts, "Format to read. "+formatUsage())
+	to := fs.String("to", jump.FormatHosts, "Format to write. "+formatUsage())
+	known := fs.String("known", "hosts.txt", "Hosts file to resolve the base32 addresses of an addresses.csv against")
+	output := fs.String("o", "", "File to write to instead of standard output")
+	fs.Parse(args)
+	in, err := openInput(fs.Args())
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	var destinations *jump.HostsTxt
+	if *from == jump.FormatAddresses {
+		if destinations, err = readHosts(*known); err != nil {
+			return err
+		}
+	}
+	ht, err := jump.Import(in, *from, destinations, jump.DefaultFeedLimits())
+	if err != nil {
+		return err
+	}
+	if n := ht.RejectedLines(); n > 0 {
+		fmt.Fprintf(os.Stderr, "Rejected %d lines: %v\n", n, ht.Rejected)
+	}
+	data, err := ht.Export(*to)
+	if err != nil {
+		return err
+	}
+	return writeOutput(*output, data)
+}
+
+func diffCommand(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return fmt.Errorf("diff takes two hosts files, got %d", fs.NArg())
+	}
+	a, err := readHosts(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	b, err := readHosts(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	d := jump.DiffHosts(a, b)
+	for _, h := range d.Removed {
+		fmt.Print("- " + h.String())
+	}
+	for _, c := range d.Changed {
+		fmt.Print("- " + c.Old.String())
+		fmt.Print("+ " + c.New.String())
+	}
+	for _, h := range d.Added {
+		fmt.Print("+ " + h.String())
+	}
+	if !d.Empty() {
+		return fmt.Errorf("%d added, %d removed, %d changed", len(d.Added), len(d.Removed), len(d.Changed))
+	}
+	return nil
+}
+
+func mergeCommand(args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	output := fs.String("o", "", "File to write to instead of standard output")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("merge takes at least one hosts file")
+	}
+	var files []*jump.HostsTxt
+	for _, file := range fs.Args() {
+		ht, err := readHosts(file)
+		if err != nil {
+			return err
+		}
+		files = append(files, ht)
+	}
+	merged, conflicts := jump.MergeHosts(files...)
+	for _, c := range conflicts {
+		fmt.Fprintf(os.Stderr, "%s: dropped %s, which has another destination than the one kept\n", fs.Arg(c.File), c.Dropped.Host)
+	}
+	return writeOutput(*output, merged.HostsFile())
+}
+
+func lintCommand(args []string) error {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("lint takes at least one hosts file")
+	}
+	n := 0
+	for _, file := range fs.Args() {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		problems, err := jump.LintHostsTxt(f, jump.DefaultFeedLimits())
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %s", file, err)
+		}
+		for _, p := range problems {
+			fmt.Printf("%s: %s\n", file, p)
+		}
+		n += len(problems)
+	}
+	if n > 0 {
+		return fmt.Errorf("%d lines would be rejected", n)
+	}
+	return nil
+}
+
+func verifyCommand(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	quiet := fs.Bool("q", false, "Only print the entries which fail to verify")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		return fmt.Errorf("verify takes at least one hosts file")
+	}
+	failed := 0
+	for _, file := range fs.Args() {
+		ht, err := readHosts(file)
+		if err != nil {
+			return err
+		}
+		for _, c := range ht.VerifySignatures() {
+			if c.Err != nil {
+				fmt.Printf("%s: %s: FAIL: %s\n", file, c.Host.Host, c.Err)
+				failed++
+			} else if !*quiet {
+				fmt.Printf("%s: %s: OK\n", file, c.Host.Host)
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d signed entries failed to verify", failed)
+	}
+	return nil
+}
+
+func trustCommand(args []string) error {
+	fs := flag.NewFlagSet("trust", flag.ExitOnError)
+	all := fs.Bool("all", false, "Report every hostname, not only the ones the files disagree about")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s trust [-all] ours.txt peer.txt...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return fmt.Errorf("trust takes our hosts file and at least one peer's")
+	}
+	tc, err := jump.LoadTrustChecker(fs.Arg(0), fs.Args()[1:])
+	if err != nil {
+		return err
+	}
+	hostnames := tc.Hostnames()
+	for host := range tc.Me.ToMap() {
+		if i := sort.SearchStrings(hostnames, host); i == len(hostnames) || hostnames[i] != host {
+			hostnames = append(hostnames, host)
+		}
+	}
+	sort.Strings(hostnames)
+	disagreements := 0
+	for _, host := range hostnames {
+		record, ok := tc.Record(host)
+		if !ok {
+			continue
+		}
+		disagree := false
+		for _, vote := range record.Votes {
+			disagree = disagree || vote.Verdict == jump.TrustDisagrees
+		}
+		if disagree {
+			disagreements++
+		}
+		if !disagree && !*all {
+			continue
+		}
+		fmt.Println(host)
+		for _, vote := range record.Votes {
+			fmt.Printf("  %-24s %-10s %s\n", vote.Peer, vote.Verdict, vote.Base32)
+			for _, note := range []string{vote.Explanation, vote.Warning, vote.Notice} {
+				if note != "" {
+					fmt.Printf("  %-24s %s\n", "", note)
+				}
+			}
+		}
+	}
+	if disagreements > 0 {
+		return fmt.Errorf("the peers disagree about %d of %d hostnames", disagreements, len(hostnames))
+	}
+	return nil
+}
+
+func reportCommand(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	hosts := fs.String("hosts", "hosts.txt", "Our hosts file")
+	name := fs.String("name", "", "Our name in the report, by default that of the server whose state is saved next to the peer files, or else of the hosts file")
+	history := fs.String("history", "", "History of our hosts file, to explain disagreements about changed destinations, by default NAME-history.txt next to the peer files")
+	output := fs.String("o", "trust-report", "Directory to write the report to")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s report [flags] [peer-NAME-hosts.txt...]\n\nWithout peer files, the peer-*-hosts.txt files next to the hosts file are used.\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	peers := fs.Args()
+	if len(peers) == 0 {
+		var err error
+		if peers, err = jump.SavedPeerFiles(filepath.Dir(*hosts)); err != nil {
+			return err
+		}
+	}
+	if len(peers) == 0 {
+		return fmt.Errorf("no peer hosts files next to %s", *hosts)
+	}
+	tc, err := jump.LoadTrustChecker(*hosts, peers)
+	if err != nil {
+		return err
+	}
+	state := filepath.Dir(peers[0])
+	if *name == "" {
+		*name = jump.SavedServerName(state)
+	}
+	if *name != "" {
+		tc.Me.Name = *name
+	} else {
+		fmt.Fprintf(os.Stderr, "WARNING: no server state in %s, naming us %s after the hosts file\n", state, tc.Me.Name)
+	}
+	if *history != "" {
+		if _, err := os.Stat(*history); err != nil {
+			return err
+		}
+		if tc.History, err = jump.LoadHistory(*history); err != nil {
+			return err
+		}
+	}
+	missing, err := tc.LoadSavedState(state)
+	if err != nil {
+		return err
+	}
+	for _, file := range missing {
+		fmt.Fprintf(os.Stderr, "WARNING: %s is missing, the report leaves out what it holds and differs from the server's\n", file)
+	}
+	return tc.WriteSite(*output)
+}
@@ -7,5 +7,8 @@ require (
 	github.com/eyedeekay/sam3 v0.32.32
 	github.com/justinas/nosurf v1.1.1
 	github.com/patrickmn/go-cache v2.1.0+incompatible // indirect
+	golang.org/x/net v0.17.0
+	golang.org/x/text v0.13.0
 	golang.org/x/time v0.0.0-20201208040808-7e3f01d25324 // indirect
+	gopkg.in/yaml.v2 v2.4.0
 )
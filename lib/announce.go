@@ -0,0 +1,143 @@
+package jump
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/eyedeekay/sam3/i2pkeys"
+)
+
+// announceInterval is how often we announce ourselves to the configured jump
+// services, which drop announces after a day.
+const announceInterval = time.Hour * 12
+
+// announceTimeout is how long a single announce may take.
+const announceTimeout = time.Minute * 2
+
+// announceTargets returns the jump services we announce ourselves to.
+func (ws *WebServer) announceTargets() []string {
+	ws.mutex.RLock()
+	defer ws.mutex.RUnlock()
+	return append([]string(nil), ws.announce...)
+}
+
+// announceURL returns the announce endpoint of a jump service given by its
+// hostname or by any URL on it.
+func announceURL(target string) (*url.URL, error) {
+	if !strings.Contains(target, "://") {
+		target = "http://" + target
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%s names no host to announce to", target)
+	}
+	return &url.URL{Scheme: "http", Host: u.Host, Path: "/announce"}, nil
+}
+
+// announceService returns the address a signed announce to the jump service
+// at host names it by: its Base32 address when host resolves to an I2P
+// destination, host itself otherwise.
+func (ws *WebServer) announceService(host string) (string, error) {
+	addr, err := ws.Transport.Lookup(host)
+	if err != nil {
+		return "", err
+	}
+	if dest, ok := addr.(i2pkeys.I2PAddr); ok {
+		return dest.Base32(), nil
+	}
+	return host, nil
+}
+
+// AnnounceTo announces our name and address to the jump service target,
+// which lists us on its home page if it can reach us. When the transport
+// holds our keys the announce is signed, so that the service marks it as
+// verified; if signing fails it is sent unsigned.
+func (ws *WebServer) AnnounceTo(ctx context.Context, target string) error {
+	u, err := announceURL(target)
+	if err != nil {
+		return err
+	}
+	form := url.Values{"host_name": {ws.Me.Name}, "host_host": {ws.Base32()}}
+	if signer, ok := ws.Transport.(Signer); ok {
+		if err := ws.signAnnounce(signer, u.Host, form); err != nil {
+			log.Printf("sending an unsigned announce to %s: %s", target, err)
+		}
+	}
+	conn, err := ws.Transport.Dial(ctx, u.Host)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	rq, err := http.NewRequest("POST", u.String(), strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	rq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := rq.Write(conn); err != nil {
+		return err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), rq)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("announce to %s: %s", target, resp.Status)
+	}
+	return nil
+}
+
+// signAnnounce adds the current time and our signature over the announce in
+// form, for the jump service at host, to form.
+func (ws *WebServer) signAnnounce(signer Signer, host string, form url.Values) error {
+	service, err := ws.announceService(host)
+	if err != nil {
+		return err
+	}
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	sig, err := signer.Sign(AnnounceMessage(service, form.Get("host_name"), now))
+	if err != nil {
+		return err
+	}
+	form.Set("host_time", now)
+	form.Set("host_sig", sig)
+	return nil
+}
+
+// runAnnounce announces us to every configured jump service each announce
+// interval, until ctx is done. While there are none it only checks whether
+// some have been configured.
+func (ws *WebServer) runAnnounce(ctx context.Context) {
+	for {
+		targets := ws.announceTargets()
+		wait := time.Minute
+		for _, target := range targets {
+			actx, cancel := context.WithTimeout(ctx, announceTimeout)
+			if err := ws.AnnounceTo(actx, target); err != nil {
+				log.Printf("announcing to %s: %s", target, err)
+			}
+			cancel()
+		}
+		if len(targets) > 0 {
+			wait = announceInterval
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
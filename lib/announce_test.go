@@ -0,0 +1,48 @@
+package jump
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAnnounceTo(t *testing.T) {
+	listing, err := NewI2PServerFromTransport(uniqueName("listing"), writeHosts(t, "hosts.txt", nil), nil, NewTCPTransport("127.0.0.1:0", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start(t, listing)
+	announcer, err := NewI2PServerFromTransport(uniqueName("announcer"), writeHosts(t, "hosts.txt", nil), nil, NewTCPTransport("127.0.0.1:0", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	cfg.Announce = []string{"http://" + listing.Base32() + "/hosts.txt"}
+	announcer.Configure(cfg)
+	start(t, announcer)
+
+	deadline := time.Now().Add(time.Second * 10)
+	for listing.Pals()[announcer.Base32()] != announcer.Me.Name {
+		if time.Now().After(deadline) {
+			t.Fatalf("configured announce never arrived: %v", listing.Pals())
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+
+	if err := announcer.AnnounceTo(context.Background(), "http://"); err == nil {
+		t.Error("announce to a URL without a host was accepted")
+	}
+}
+
+func TestSignedAnnounce(t *testing.T) {
+	listing, b32 := newServer(t, nil, nil)
+	announcer, announced := newServer(t, nil, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	if err := announcer.AnnounceTo(ctx, "http://"+b32+"/hosts.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if listing.Pals()[announced] != announcer.Me.Name || !listing.Verified()[announced] {
+		t.Errorf("announce over SAM was not signed: %v %v", listing.Pals(), listing.Verified())
+	}
+}
@@ -0,0 +1,115 @@
+package jump
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// maxAPIRequest is the largest registration request the API reads. A signed
+// line with every prop of a subdomain registration fits easily.
+const maxAPIRequest = 16 * 1024
+
+// APIRegistration is a registration submitted as JSON. Destination is either
+// a bare destination or a signed registration line, in which case Hostname
+// may be left empty.
+type APIRegistration struct {
+	Hostname    string `json:"hostname"`
+	Destination string `json:"destination"`
+	Description string `json:"description"`
+}
+
+// APIResult is the answer to a registration made through the API.
+type APIResult struct {
+	// Status is "queued" for a registration waiting for approval and
+	// "applied" for one which took effect at once.
+	Status    string `json:"status"`
+	Hostname  string `json:"hostname"`
+	Message   string `json:"message"`
+	Ticket    string `json:"ticket,omitempty"`
+	StatusURL string `json:"status_url,omitempty"`
+}
+
+// APIError is the answer to a refused registration, with the HTTP status it
+// was sent with. Code is one of the Code constants when a field failed
+// validation, named by Field, and otherwise the HTTP status in snake case,
+// such as "conflict".
+type APIError struct {
+	Status int    `json:"status"`
+	Code   string `json:"code"`
+	Field  string `json:"field,omitempty"`
+	Error  string `json:"error"`
+}
+
+// apiError returns the answer refusing a request with status.
+func apiError(status int, message string) APIError {
+	code := strings.ToLower(strings.Replace(http.StatusText(status), " ", "_", -1))
+	return APIError{Status: status, Code: code, Error: message}
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	if err := json.NewEncoder(rw).Encode(v); err != nil {
+		log.Printf("Encoding API response: %s", err)
+	}
+}
+
+// serveRegisterAPI answers POST /api/v1/register. The body is either an
+// APIRegistration as JSON or, with any other content type, a signed
+// registration line.
+func (ws *WebServer) serveRegisterAPI(rw http.ResponseWriter, rq *http.Request) {
+	if rq.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		writeJSON(rw, http.StatusMethodNotAllowed, apiError(http.StatusMethodNotAllowed, "Registrations have to be POSTed"))
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(rw, rq.Body, maxAPIRequest))
+	if err != nil {
+		writeJSON(rw, http.StatusRequestEntityTooLarge, apiError(http.StatusRequestEntityTooLarge, "The request is too large"))
+		return
+	}
+	var submitted APIRegistration
+	if mediaType, _, _ := mime.ParseMediaType(rq.Header.Get("Content-Type")); mediaType == "application/json" {
+		if err := json.Unmarshal(body, &submitted); err != nil {
+			writeJSON(rw, http.StatusBadRequest, APIError{Status: http.StatusBadRequest, Code: "malformed_json", Error: "Malformed JSON: " + err.Error()})
+			return
+		}
+	} else {
+		submitted.Destination = strings.TrimSpace(string(body))
+		if !isEntry(submitted.Destination) {
+			writeJSON(rw, http.StatusBadRequest, APIError{Status: http.StatusBadRequest, Code: CodeInvalidLine, Field: "destination", Error: "The body has to be a signed registration line, or JSON sent as application/json"})
+			return
+		}
+		if entry, err := ParseEntry(submitted.Destination); err == nil && entry.Props[PropSig] == "" {
+			writeJSON(rw, http.StatusBadRequest, APIError{Status: http.StatusBadRequest, Code: CodeMissingSignature, Field: "sig", Error: "A registration line sent without JSON has to be signed"})
+			return
+		}
+	}
+	registration, err := ws.Register(submitted.Hostname, submitted.Destination, submitted.Description, rq.RemoteAddr)
+	if err != nil {
+		refused, ok := err.(*RegistrationError)
+		if !ok {
+			log.Printf("registration failed: %s", err)
+			writeJSON(rw, http.StatusInternalServerError, apiError(http.StatusInternalServerError, "The registration could not be saved"))
+			return
+		}
+		log.Printf("refused registration: %s", err)
+		result := apiError(refused.Status, refused.Message)
+		if refused.Code != "" {
+			result.Code, result.Field = refused.Code, refused.Field
+		}
+		writeJSON(rw, refused.Status, result)
+		return
+	}
+	result := APIResult{Status: "applied", Hostname: registration.Entry.Host, Message: registration.String()}
+	if !registration.Applied {
+		result.Status = "queued"
+		result.Ticket = registration.Ticket.ID
+		result.StatusURL = statusPath(registration.Ticket.ID) + ".json"
+	}
+	writeJSON(rw, http.StatusOK, result)
+}
@@ -0,0 +1,74 @@
+package jump
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eyedeekay/sam3/i2pkeys"
+)
+
+func TestRegisterAPI(t *testing.T) {
+	owned, dest := newDest(t), newDest(t)
+	is, err := NewI2PServerFromTransport(uniqueName("api"), writeHosts(t, "hosts.txt", map[string]i2pkeys.I2PAddr{"owned.i2p": owned}), nil, NewTCPTransport("127.0.0.1:0", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := is.Handler()
+	post := func(contentType, body, client string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		rq := httptest.NewRequest("POST", "/api/v1/register", strings.NewReader(body))
+		rq.Header.Set("Content-Type", contentType)
+		rq.RemoteAddr = client + ":1234"
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, rq)
+		var result map[string]interface{}
+		if err := json.Unmarshal(rw.Body.Bytes(), &result); err != nil {
+			t.Fatalf("%s: %s", err, rw.Body.String())
+		}
+		return rw, result
+	}
+
+	rw, result := post("application/json", `{"hostname":"Scripted.i2p","destination":"`+dest.Base64()+`","description":"from a script"}`, "10.0.0.1")
+	if rw.Code != http.StatusOK || result["status"] != "queued" || result["hostname"] != "scripted.i2p" || result["ticket"] == "" {
+		t.Errorf("JSON registration: %d %v", rw.Code, result)
+	}
+	if queued, ok := is.Queue.Hosts().Lookup("scripted.i2p"); !ok || queued.Description != "from a script" {
+		t.Errorf("JSON registration was not queued: %+v", queued)
+	}
+
+	rw, result = post("text/plain", signRenewal(t, "owned.i2p", owned, time.Now())+"\n", "10.0.0.2")
+	if rw.Code != http.StatusOK || result["status"] != "applied" || result["ticket"] != nil {
+		t.Errorf("signed line: %d %v", rw.Code, result)
+	}
+
+	for i, c := range []struct {
+		contentType, body string
+		status            int
+		code, field       string
+	}{
+		{"application/json", `{"hostname":"owned.i2p","destination":"` + dest.Base64() + `"}`, http.StatusConflict, "conflict", ""},
+		{"application/json", `{"hostname":`, http.StatusBadRequest, "malformed_json", ""},
+		{"text/plain", "not a registration", http.StatusBadRequest, CodeInvalidLine, "destination"},
+		{"text/plain", "unsigned.i2p=" + dest.Base64(), http.StatusBadRequest, CodeMissingSignature, "sig"},
+		{"application/json", `{"hostname":"bad_name.i2p","destination":"` + dest.Base64() + `"}`, http.StatusBadRequest, CodeInvalidHostname, "hostname"},
+		{"application/json", `{"hostname":"fine.i2p","destination":"AAAA"}`, http.StatusBadRequest, CodeInvalidDestination, "destination"},
+	} {
+		rw, result := post(c.contentType, c.body, fmt.Sprintf("10.0.1.%d", i))
+		if rw.Code != c.status || result["status"] != float64(c.status) || result["error"] == "" {
+			t.Errorf("%s %q: %d %v, want %d", c.contentType, c.body, rw.Code, result, c.status)
+		}
+		if field, _ := result["field"].(string); result["code"] != c.code || field != c.field {
+			t.Errorf("%s %q: code %v and field %v, want %q and %q", c.contentType, c.body, result["code"], result["field"], c.code, c.field)
+		}
+	}
+
+	rw = httptest.NewRecorder()
+	handler.ServeHTTP(rw, httptest.NewRequest("GET", "/api/v1/register", nil))
+	if rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET: %d", rw.Code)
+	}
+}
@@ -0,0 +1,214 @@
+package jump
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// The block file is the paged database format of the Java router's address
+// book. The file is made of pages of blockPageSize bytes, numbered from 1,
+// and holds skip lists of sorted keys and values: a metaindex skip list at
+// page 2 maps the name of every other skip list to its header page. The
+// values of a span of a skip list are laid out after its header and continue
+// on a chain of overflow pages when they do not fit.
+const (
+	blockPageSize      = 1024
+	blockMagic         = 0x3141de4932500102 // "1A\xdeI2P", version 1.2
+	blockMetaIndexPage = 2
+	skipListMagic      = 0x536b69704c697374 // "SkipList"
+	skipSpanMagic      = 0x5370616e         // "Span"
+	skipLevelsMagic    = 0x42534c6576656c73 // "BSLevels"
+	overflowMagic      = 0x434f4e54         // "CONT"
+	skipSpanHeader     = 20
+	skipLevelsHeader   = 16
+	overflowHeader     = 8
+	// blockSpanSize is how many keys the spans we write hold.
+	blockSpanSize = 16
+	// blockHeadLevels is the height of the first levels page of the skip
+	// lists we write, which the Java router grows the list from.
+	blockHeadLevels = 4
+)
+
+// blockReader reads the skip lists of a block file held in memory.
+type blockReader struct {
+	data []byte
+}
+
+func (r *blockReader) page(n uint32) ([]byte, error) {
+	start := (int64(n) - 1) * blockPageSize
+	if n == 0 || start+blockPageSize > int64(len(r.data)) {
+		return nil, fmt.Errorf("block file has no page %d", n)
+	}
+	return r.data[start : start+blockPageSize], nil
+}
+
+// skipList returns the keys and values of the skip list whose header is at
+// page, in order.
+func (r *blockReader) skipList(page uint32) (keys, values [][]byte, err error) {
+	header, err := r.page(page)
+	if err != nil {
+		return nil, nil, err
+	}
+	if binary.BigEndian.Uint64(header) != skipListMagic {
+		return nil, nil, fmt.Errorf("page %d is not a skip list", page)
+	}
+	pages := len(r.data) / blockPageSize
+	span := binary.BigEndian.Uint32(header[8:])
+	for spans := 0; span != 0; spans++ {
+		if spans > pages {
+			return nil, nil, fmt.Errorf("skip list at page %d has a loop", page)
+		}
+		p, err := r.page(span)
+		if err != nil {
+			return nil, nil, err
+		}
+		if binary.BigEndian.Uint32(p) != skipSpanMagic {
+			return nil, nil, fmt.Errorf("page %d is not a span", span)
+		}
+		data, err := r.spanData(p)
+		if err != nil {
+			return nil, nil, fmt.Errorf("span at page %d: %s", span, err)
+		}
+		n := int(binary.BigEndian.Uint16(p[18:]))
+		for i := 0; i < n; i++ {
+			if len(data) < 4 {
+				return nil, nil, fmt.Errorf("span at page %d is truncated", span)
+			}
+			keyLen, valLen := int(binary.BigEndian.Uint16(data)), int(binary.BigEndian.Uint16(data[2:]))
+			data = data[4:]
+			if len(data) < keyLen+valLen {
+				return nil, nil, fmt.Errorf("span at page %d is truncated", span)
+			}
+			keys = append(keys, data[:keyLen])
+			values = append(values, data[keyLen:keyLen+valLen])
+			data = data[keyLen+valLen:]
+		}
+		span = binary.BigEndian.Uint32(p[12:])
+	}
+	return keys, values, nil
+}
+
+// spanData returns the data of the span p, joined with its overflow pages.
+func (r *blockReader) spanData(p []byte) ([]byte, error) {
+	data := append([]byte(nil), p[skipSpanHeader:]...)
+	pages := len(r.data) / blockPageSize
+	next := binary.BigEndian.Uint32(p[4:])
+	for overflows := 0; next != 0; overflows++ {
+		if overflows > pages {
+			return nil, fmt.Errorf("overflow pages loop")
+		}
+		o, err := r.page(next)
+		if err != nil {
+			return nil, err
+		}
+		if binary.BigEndian.Uint32(o) != overflowMagic {
+			return nil, fmt.Errorf("page %d is not an overflow page", next)
+		}
+		data = append(data, o[overflowHeader:]...)
+		next = binary.BigEndian.Uint32(o[4:])
+	}
+	return data, nil
+}
+
+// blockWriter lays out a block file in memory.
+type blockWriter struct {
+	pages [][]byte
+}
+
+// newBlockWriter returns a writer holding the superblock and the page of the
+// metaindex header.
+func newBlockWriter() *blockWriter {
+	w := &blockWriter{}
+	w.alloc()
+	w.alloc()
+	return w
+}
+
+// alloc adds an empty page and returns its number.
+func (w *blockWriter) alloc() uint32 {
+	w.pages = append(w.pages, make([]byte, blockPageSize))
+	return uint32(len(w.pages))
+}
+
+func (w *blockWriter) page(n uint32) []byte {
+	return w.pages[n-1]
+}
+
+// skipList writes a skip list of keys, which are in order, and their values
+// with its header at page.
+func (w *blockWriter) skipList(page uint32, keys, values [][]byte) {
+	var spans, levels []uint32
+	for start := 0; start == 0 || start < len(keys); start += blockSpanSize {
+		end := start + blockSpanSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		span := w.alloc()
+		spans = append(spans, span)
+		levels = append(levels, w.alloc())
+		var data []byte
+		for i := start; i < end; i++ {
+			var lengths [4]byte
+			binary.BigEndian.PutUint16(lengths[:], uint16(len(keys[i])))
+			binary.BigEndian.PutUint16(lengths[2:], uint16(len(values[i])))
+			data = append(append(append(data, lengths[:]...), keys[i]...), values[i]...)
+		}
+		p := w.page(span)
+		binary.BigEndian.PutUint32(p, skipSpanMagic)
+		binary.BigEndian.PutUint16(p[16:], blockSpanSize)
+		binary.BigEndian.PutUint16(p[18:], uint16(end-start))
+		data = data[copy(p[skipSpanHeader:], data):]
+		link := p[4:8]
+		for len(data) > 0 {
+			overflow := w.alloc()
+			binary.BigEndian.PutUint32(link, overflow)
+			o := w.page(overflow)
+			binary.BigEndian.PutUint32(o, overflowMagic)
+			link = o[4:8]
+			data = data[copy(o[overflowHeader:], data):]
+		}
+	}
+	for i, span := range spans {
+		p := w.page(span)
+		if i > 0 {
+			binary.BigEndian.PutUint32(p[8:], spans[i-1])
+		}
+		if i+1 < len(spans) {
+			binary.BigEndian.PutUint32(p[12:], spans[i+1])
+		}
+		height := 1
+		if i == 0 {
+			height = blockHeadLevels
+		}
+		l := w.page(levels[i])
+		binary.BigEndian.PutUint64(l, skipLevelsMagic)
+		binary.BigEndian.PutUint16(l[8:], uint16(height))
+		binary.BigEndian.PutUint32(l[12:], span)
+		if i+1 < len(spans) {
+			binary.BigEndian.PutUint16(l[10:], 1)
+			binary.BigEndian.PutUint32(l[skipLevelsHeader:], levels[i+1])
+		}
+	}
+	h := w.page(page)
+	binary.BigEndian.PutUint64(h, skipListMagic)
+	binary.BigEndian.PutUint32(h[8:], spans[0])
+	binary.BigEndian.PutUint32(h[12:], levels[0])
+	binary.BigEndian.PutUint32(h[16:], uint32(len(keys)))
+	binary.BigEndian.PutUint32(h[20:], uint32(len(spans)))
+	binary.BigEndian.PutUint32(h[24:], uint32(len(levels)))
+}
+
+// Bytes writes the superblock and returns the whole file.
+func (w *blockWriter) Bytes() []byte {
+	size := len(w.pages) * blockPageSize
+	s := w.page(1)
+	binary.BigEndian.PutUint64(s, blockMagic)
+	binary.BigEndian.PutUint64(s[8:], uint64(size))
+	binary.BigEndian.PutUint16(s[22:], blockSpanSize)
+	binary.BigEndian.PutUint32(s[24:], blockPageSize)
+	data := make([]byte, 0, size)
+	for _, p := range w.pages {
+		data = append(data, p...)
+	}
+	return data
+}
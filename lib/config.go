@@ -0,0 +1,228 @@
+package jump
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the structured configuration of a jump service, as loaded from a
+// YAML file. Everything but the peers, rate limits and admin credentials is
+// only read at startup.
+type Config struct {
+	Name       string          `yaml:"name"`
+	SAMAddr    string          `yaml:"samaddr"`
+	KeysPath   string          `yaml:"keyspath"`
+	HostsFile  string          `yaml:"hostsfile"`
+	Local      string          `yaml:"local"`
+	LocalOnly  bool            `yaml:"localonly"`
+	Peers      []PeerConfig    `yaml:"peers"`
+	Fetch      FetchConfig     `yaml:"fetch"`
+	Announce   []string        `yaml:"announce"`
+	RateLimits RateLimitConfig `yaml:"ratelimits"`
+	Admin      AdminConfig     `yaml:"admin"`
+	Expiry     ExpiryConfig    `yaml:"expiry"`
+	SU3        SU3Config       `yaml:"su3"`
+	// Hosts maps I2P names and Base32 addresses to the host:port pairs they
+	// are reached at over plain TCP, when the service is local only.
+	Hosts map[string]string `yaml:"hosts"`
+}
+
+// PeerConfig describes one jump service whose hosts file is mirrored. Zero
+// schedule settings are taken from the fetch defaults.
+type PeerConfig struct {
+	Name     string `yaml:"name"`
+	URL      string `yaml:"url"`
+	Disabled bool   `yaml:"disabled"`
+	// SU3Signers are the certificates of the signers the peer's feed has to
+	// be signed by, when it publishes an SU3 feed.
+	SU3Signers []string `yaml:"su3_signers"`
+	// Destination pins the peer to a full destination or Base32 address,
+	// so that a poisoned local address book cannot redirect its fetches.
+	Destination string `yaml:"destination"`
+	Schedule    `yaml:",inline"`
+}
+
+// Validate checks the peer's schedule and pinned destination.
+func (p PeerConfig) Validate() error {
+	if p.Destination != "" {
+		if _, err := pinnedBase32(p.Destination); err != nil {
+			return fmt.Errorf("destination: %s", err)
+		}
+	}
+	return p.Schedule.Validate()
+}
+
+// FetchConfig sets how many peers are fetched at once, the schedule used by
+// peers which do not set their own, and the limits on what peers may send.
+type FetchConfig struct {
+	Workers  int `yaml:"workers"`
+	Schedule `yaml:",inline"`
+	Limits   FeedLimits `yaml:"limits"`
+}
+
+// RateLimitConfig controls how often clients may use the web interface.
+type RateLimitConfig struct {
+	// RequestsPerSecond is the number of requests a client may make to a
+	// single path each second.
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	// RegistrationInterval is how long a client has to wait between
+	// hostname registrations.
+	RegistrationInterval time.Duration `yaml:"registration_interval"`
+}
+
+// SU3Config sets the operator key the hosts feeds are published with as SU3
+// files. When no signer is set, they are not.
+type SU3Config struct {
+	// Signer is the signer ID, conventionally an email address.
+	Signer string `yaml:"signer"`
+	// Key and Certificate are created when the key does not exist. They
+	// default to <name>-su3.key and <name>-su3.crt.
+	Key         string `yaml:"key"`
+	Certificate string `yaml:"certificate"`
+}
+
+// AdminConfig holds the credentials for the administrative endpoints. When
+// no username is set, those endpoints are disabled.
+type AdminConfig struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// DefaultConfig returns the configuration used when no file or flag says
+// otherwise.
+func DefaultConfig() *Config {
+	return &Config{
+		Name:      "jumphelp",
+		SAMAddr:   "127.0.0.1:7656",
+		KeysPath:  "keys",
+		HostsFile: "hosts.txt",
+		Fetch: FetchConfig{
+			Workers:  2,
+			Schedule: DefaultSchedule(),
+			Limits:   DefaultFeedLimits(),
+		},
+		RateLimits: RateLimitConfig{
+			RequestsPerSecond:    1,
+			RegistrationInterval: time.Hour * 12,
+		},
+		Expiry: DefaultExpiryConfig(),
+	}
+}
+
+// LoadConfig reads a YAML configuration file, filling anything it leaves out
+// from DefaultConfig.
+func LoadConfig(file string) (*Config, error) {
+	bytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cfg := DefaultConfig()
+	if err := yaml.UnmarshalStrict(bytes, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	return cfg, nil
+}
+
+// Validate checks the configuration for settings which cannot work.
+func (cfg *Config) Validate() error {
+	names := make(map[string]bool)
+	for i, p := range cfg.Peers {
+		if p.Name == "" || p.URL == "" {
+			return fmt.Errorf("peer %d needs both a name and a url", i)
+		}
+		if names[p.Name] {
+			return fmt.Errorf("peer %s is configured more than once", p.Name)
+		}
+		names[p.Name] = true
+	}
+	if cfg.Fetch.Workers < 1 {
+		return fmt.Errorf("fetch workers must be at least 1")
+	}
+	if err := cfg.Fetch.Validate(); err != nil {
+		return fmt.Errorf("fetch: %s", err)
+	}
+	if err := cfg.Fetch.Limits.Validate(); err != nil {
+		return fmt.Errorf("fetch: %s", err)
+	}
+	for _, p := range cfg.Peers {
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("peer %s: %s", p.Name, err)
+		}
+	}
+	if cfg.LocalOnly && cfg.Local == "" {
+		return fmt.Errorf("localonly requires a local address")
+	}
+	if cfg.LocalOnly {
+		for _, p := range cfg.Peers {
+			if err := cfg.reachableLocally(p); err != nil {
+				return fmt.Errorf("peer %s: %s", p.Name, err)
+			}
+		}
+	}
+	if cfg.Admin.Username != "" && cfg.Admin.Password == "" {
+		return fmt.Errorf("admin needs a password along with its username")
+	}
+	if cfg.RateLimits.RequestsPerSecond <= 0 {
+		return fmt.Errorf("requests_per_second must be positive")
+	}
+	if err := cfg.Expiry.Validate(); err != nil {
+		return fmt.Errorf("expiry: %s", err)
+	}
+	return nil
+}
+
+// reachableLocally checks that a local only service can reach the enabled
+// peer p over plain TCP: that the I2P name it is fetched from, or the address
+// it is pinned to, is mapped under hosts.
+func (cfg *Config) reachableLocally(p PeerConfig) error {
+	if p.Disabled {
+		return nil
+	}
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return err
+	}
+	host, hostport := u.Hostname(), u.Host
+	if p.Destination != "" {
+		if host, err = pinnedBase32(p.Destination); err != nil {
+			return err
+		}
+		hostport = host
+	}
+	if !strings.HasSuffix(strings.ToLower(host), ".i2p") || cfg.Hosts[host] != "" || cfg.Hosts[hostport] != "" {
+		return nil
+	}
+	return fmt.Errorf("%s cannot be reached over plain TCP without a mapping under hosts", host)
+}
+
+// ResolvedPeers returns the configured peers with any schedule settings they
+// leave out filled in from the fetch defaults.
+func (cfg *Config) ResolvedPeers() []PeerConfig {
+	var peers []PeerConfig
+	for _, p := range cfg.Peers {
+		p.Schedule = p.Schedule.WithDefaults(cfg.Fetch.Schedule)
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+// ParsePeers converts a list of peers in the form "name=http://name.i2p/hosts.txt"
+// into peer configurations. Malformed entries are skipped.
+func ParsePeers(peerslist []string) []PeerConfig {
+	var peers []PeerConfig
+	for _, v := range peerslist {
+		V := strings.SplitN(v, "=", 2)
+		if len(V) == 2 {
+			peers = append(peers, PeerConfig{Name: V[0], URL: V[1]})
+		}
+	}
+	return peers
+}
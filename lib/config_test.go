@@ -0,0 +1,192 @@
+package jump
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, body string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, `
+name: configured
+peers:
+  - name: one
+    url: http://one.i2p/hosts.txt
+    interval: 5m
+  - name: two
+    url: http://two.i2p/hosts.txt
+    disabled: true
+fetch:
+  workers: 4
+  timeout: 30s
+  limits:
+    max_entries: 50
+ratelimits:
+  registration_interval: 24h
+admin:
+  username: admin
+  password: secret
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Name != "configured" || cfg.SAMAddr != DefaultConfig().SAMAddr {
+		t.Errorf("settings not loaded over the defaults: %+v", cfg)
+	}
+	if len(cfg.Peers) != 2 || cfg.Peers[0].URL != "http://one.i2p/hosts.txt" || !cfg.Peers[1].Disabled {
+		t.Errorf("peers not loaded: %+v", cfg.Peers)
+	}
+	if cfg.Fetch.Workers != 4 || cfg.Fetch.Timeout != time.Second*30 || cfg.Fetch.Interval != DefaultSchedule().Interval {
+		t.Errorf("fetch settings not loaded: %+v", cfg.Fetch)
+	}
+	if cfg.Fetch.Limits.MaxEntries != 50 || cfg.Fetch.Limits.MaxBodySize != DefaultFeedLimits().MaxBodySize {
+		t.Errorf("feed limits not loaded over the defaults: %+v", cfg.Fetch.Limits)
+	}
+	peers := cfg.ResolvedPeers()
+	if peers[0].Interval != time.Minute*5 || peers[0].Timeout != time.Second*30 || peers[1].Interval != time.Hour {
+		t.Errorf("peer schedules not resolved against the fetch defaults: %+v", peers)
+	}
+	if cfg.RateLimits.RegistrationInterval != time.Hour*24 || cfg.RateLimits.RequestsPerSecond != 1 {
+		t.Errorf("rate limits not loaded: %+v", cfg.RateLimits)
+	}
+	if cfg.Admin.Username != "admin" || cfg.Admin.Password != "secret" {
+		t.Errorf("admin credentials not loaded: %+v", cfg.Admin)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for _, body := range []string{
+		"nmae: typo\n",
+		"peers:\n  - name: one\n",
+		"peers:\n  - {name: one, url: http://one.i2p/}\n  - {name: one, url: http://two.i2p/}\n",
+		"localonly: true\n",
+		"ratelimits:\n  requests_per_second: 0\n",
+		"fetch:\n  workers: 0\n",
+		"fetch:\n  limits:\n    max_line_length: 0\n",
+		"peers:\n  - {name: one, url: http://one.i2p/, timeout: -1s}\n",
+		"localonly: true\nlocal: 127.0.0.1:0\npeers:\n  - {name: one, url: http://one.i2p/}\n",
+		"localonly: true\nlocal: 127.0.0.1:0\nhosts: {one.i2p: 127.0.0.1:8080}\npeers:\n  - {name: one, url: http://one.i2p/, destination: " + strings.Repeat("a", 52) + ".b32.i2p}\n",
+	} {
+		if _, err := LoadConfig(writeConfig(t, body)); err == nil {
+			t.Errorf("expected an error loading %q", body)
+		}
+	}
+}
+
+func TestLoadConfigLocalOnly(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, `localonly: true
+local: 127.0.0.1:0
+hosts:
+  one.i2p: 127.0.0.1:8080
+peers:
+  - {name: one, url: http://one.i2p/hosts.txt}
+  - {name: plain, url: http://127.0.0.1:8081/hosts.txt}
+  - {name: off, url: http://off.i2p/hosts.txt, disabled: true}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Hosts["one.i2p"] != "127.0.0.1:8080" {
+		t.Errorf("hosts mapping not loaded: %v", cfg.Hosts)
+	}
+}
+
+func peerNames(is *I2PServer) string {
+	var names []string
+	for _, peer := range is.Peers() {
+		names = append(names, peer.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestReload(t *testing.T) {
+	dest := newDest(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rq *http.Request) {
+		rw.Write([]byte("reloaded.i2p=" + dest.Base64() + "\n"))
+	}))
+	defer srv.Close()
+	cfg := DefaultConfig()
+	cfg.Name = uniqueName("reload")
+	cfg.HostsFile = filepath.Join(t.TempDir(), "hosts.txt")
+	cfg.Local = "127.0.0.1:0"
+	cfg.LocalOnly = true
+	cfg.RateLimits.RequestsPerSecond = 100
+	cfg.Peers = []PeerConfig{{Name: "kept", URL: srv.URL + "/hosts.txt"}}
+	is, err := NewI2PServerFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start(t, is)
+	waitFetched(t, is.Scheduler, "kept")
+	kept := is.Peers()[0]
+	recheck := "http://" + is.Base32() + "/recheck"
+	resp, err := http.Get(recheck)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("recheck without configured credentials: %s", resp.Status)
+	}
+
+	cfg.Peers = []PeerConfig{
+		{Name: "kept", URL: srv.URL + "/hosts.txt"},
+		{Name: "added", URL: srv.URL + "/hosts.txt"},
+		{Name: "off", URL: srv.URL + "/hosts.txt", Disabled: true},
+	}
+	cfg.Admin = AdminConfig{Username: "admin", Password: "secret"}
+	if err := is.Reload(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if names := peerNames(is); names != "kept,added" {
+		t.Fatalf("unexpected peers after reload: %s", names)
+	}
+	if is.Peers()[0] != kept {
+		t.Error("unchanged peer was replaced on reload")
+	}
+	resp, err = http.Get(recheck)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("recheck without credentials after reload: %s", resp.Status)
+	}
+
+	cfg.Peers = []PeerConfig{
+		{Name: "replacement", URL: srv.URL + "/hosts.txt"},
+		{Name: "broken", URL: srv.URL + "/hosts.txt", SU3Signers: []string{filepath.Join(t.TempDir(), "missing.crt")}},
+	}
+	if err := is.Reload(cfg); err == nil {
+		t.Error("reload with a peer which cannot be set up succeeded")
+	}
+	if names := peerNames(is); names != "kept,added" {
+		t.Errorf("failed reload changed the peers: %s", names)
+	}
+	if _, scheduled := is.Scheduler.Status("replacement"); scheduled {
+		t.Error("failed reload scheduled a new peer")
+	}
+	if _, scheduled := is.Scheduler.Status("kept"); !scheduled {
+		t.Error("failed reload unscheduled a kept peer")
+	}
+
+	cfg.Peers = nil
+	if err := is.Reload(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if names := peerNames(is); names != "" {
+		t.Errorf("peers not removed on reload: %s", names)
+	}
+}
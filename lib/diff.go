@@ -0,0 +1,69 @@
+package jump
+
+// HostChange is a hostname which two hosts files map to different
+// destinations.
+type HostChange struct {
+	Old Host
+	New Host
+}
+
+// HostsDiff is what changed between two hosts files.
+type HostsDiff struct {
+	Added   []Host
+	Removed []Host
+	Changed []HostChange
+}
+
+// Empty reports whether the two hosts files have the same hostnames and
+// destinations.
+func (d HostsDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// DiffHosts compares the hosts file b against a. A hostname whose
+// destination is the same in both is unchanged, whatever its properties.
+func DiffHosts(a, b *HostsTxt) HostsDiff {
+	var d HostsDiff
+	for _, h := range a.HostList {
+		theirs, ok := b.Lookup(h.Host)
+		if !ok {
+			d.Removed = append(d.Removed, h)
+		} else if !sameDestination(h.Destination, theirs.Destination) {
+			d.Changed = append(d.Changed, HostChange{h, theirs})
+		}
+	}
+	for _, h := range b.HostList {
+		if _, ok := a.Lookup(h.Host); !ok {
+			d.Added = append(d.Added, h)
+		}
+	}
+	return d
+}
+
+// MergeConflict is an entry left out of a merge because an earlier file has
+// another destination for its hostname.
+type MergeConflict struct {
+	// File is the position of the file the entry was dropped from.
+	File    int
+	Kept    Host
+	Dropped Host
+}
+
+// MergeHosts joins hosts files into one. Where a hostname is in more than one
+// of them, the entry of the first is kept, and the later entries with other
+// destinations are returned as conflicts.
+func MergeHosts(files ...*HostsTxt) (*HostsTxt, []MergeConflict) {
+	merged := newHostsTxt()
+	var conflicts []MergeConflict
+	for i, ht := range files {
+		for _, h := range ht.HostList {
+			if merged.AppendHost(h) {
+				continue
+			}
+			if kept, _ := merged.Lookup(h.Host); !sameDestination(kept.Destination, h.Destination) {
+				conflicts = append(conflicts, MergeConflict{i, kept, h})
+			}
+		}
+	}
+	return merged, conflicts
+}
@@ -0,0 +1,31 @@
+package jump
+
+import "testing"
+
+func TestDiffMerge(t *testing.T) {
+	one, two, three, moved := newDest(t), newDest(t), newDest(t), newDest(t)
+	a := ParseHostsTxt([]string{"one.i2p=" + one.Base64(), "two.i2p=" + two.Base64()})
+	b := ParseHostsTxt([]string{"one.i2p=" + moved.Base64(), "three.i2p=" + three.Base64()})
+
+	d := DiffHosts(a, b)
+	if len(d.Added) != 1 || d.Added[0].Host != "three.i2p" {
+		t.Errorf("unexpected added hosts: %v", d.Added)
+	}
+	if len(d.Removed) != 1 || d.Removed[0].Host != "two.i2p" {
+		t.Errorf("unexpected removed hosts: %v", d.Removed)
+	}
+	if len(d.Changed) != 1 || d.Changed[0].Old.Destination != one.Base64() || d.Changed[0].New.Destination != moved.Base64() {
+		t.Errorf("unexpected changed hosts: %v", d.Changed)
+	}
+	if !DiffHosts(a, a.Copy()).Empty() {
+		t.Error("a hosts file differs from its copy")
+	}
+
+	merged, conflicts := MergeHosts(a, b, a)
+	if got := merged.ToMap(); len(got) != 3 || got["one.i2p"] != one.Base64() || got["three.i2p"] != three.Base64() {
+		t.Errorf("unexpected merged hosts: %v", got)
+	}
+	if len(conflicts) != 1 || conflicts[0].File != 1 || conflicts[0].Dropped.Destination != moved.Base64() {
+		t.Errorf("unexpected conflicts: %v", conflicts)
+	}
+}
@@ -0,0 +1,226 @@
+package jump
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/eyedeekay/sam3/i2pkeys"
+	"i2pgit.org/idk/jump-transparency/lib/naming"
+)
+
+// Properties of the extended hosts file format, which follow the destination
+// after "#!" as key=value pairs separated by "#".
+const (
+	PropAction  = "action"
+	PropDate    = "date"
+	PropSig     = "sig"
+	PropOldName = "oldname"
+	PropOldDest = "olddest"
+	PropOldSig  = "oldsig"
+	// PropName and PropDest name the host of a command line, which has
+	// only properties, such as a removal.
+	PropName = "name"
+	PropDest = "dest"
+	// ActionAddSubdomain marks the registration of a subdomain, authorized
+	// by the key of its parent domain in oldsig.
+	ActionAddSubdomain = "addsubdomain"
+)
+
+// parseProps parses the properties after the "#!" of an extended line. Pairs
+// without an "=" are skipped.
+func parseProps(s string) map[string]string {
+	props := make(map[string]string)
+	for _, pair := range strings.Split(s, "#") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) == 2 && kv[0] != "" {
+			props[kv[0]] = kv[1]
+		}
+	}
+	return props
+}
+
+// ParseEntry parses one line of a hosts file, in the plain or the extended
+// format. The hostname is normalized but not validated.
+func ParseEntry(line string) (Host, error) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "#!") {
+		return parseCommand(line)
+	}
+	spl := strings.SplitN(line, "=", 2)
+	if len(spl) != 2 {
+		return Host{}, fmt.Errorf("%q is not a hostname=destination line", line)
+	}
+	h := Host{Host: naming.Normalize(spl[0])}
+	rest := strings.SplitN(spl[1], "#", 2)
+	h.Destination = rest[0]
+	if len(rest) == 2 && strings.HasPrefix(rest[1], "!") {
+		if props := parseProps(rest[1][1:]); len(props) > 0 {
+			h.Props = props
+		}
+	}
+	return h, nil
+}
+
+// parseCommand parses a command line of the extended format, which names
+// its host in the name and dest properties.
+func parseCommand(line string) (Host, error) {
+	props := parseProps(line[2:])
+	if props[PropName] == "" || props[PropDest] == "" {
+		return Host{}, fmt.Errorf("%q is a command line without a name and dest", line)
+	}
+	return Host{Host: naming.Normalize(props[PropName]), Destination: props[PropDest], Props: props}, nil
+}
+
+// isCommand reports whether the host was parsed from, and is written as, a
+// command line.
+func (h *Host) isCommand() bool {
+	_, ok := h.Props[PropName]
+	return ok
+}
+
+// isEntry reports whether s is a hosts file line rather than a bare
+// destination, as registrants paste signed lines where a destination goes.
+func isEntry(s string) bool {
+	if strings.HasPrefix(s, "#!") {
+		return true
+	}
+	i := strings.Index(s, "=")
+	return i > 0 && strings.Contains(strings.ToLower(s[:i]), naming.Suffix)
+}
+
+// line writes the host in the extended format, with its properties sorted
+// and the signature last, leaving out the properties in exclude. A command
+// line is written as its properties alone.
+func (h *Host) line(exclude ...string) string {
+	var keys []string
+	for k := range h.Props {
+		if k != PropSig && !excluded(k, exclude) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	if _, ok := h.Props[PropSig]; ok && !excluded(PropSig, exclude) {
+		keys = append(keys, PropSig)
+	}
+	s := h.Host + "=" + h.Destination
+	if h.isCommand() {
+		s = ""
+	}
+	for i, k := range keys {
+		if i == 0 {
+			s += "#!"
+		} else {
+			s += "#"
+		}
+		s += k + "=" + h.Props[k]
+	}
+	return s
+}
+
+func excluded(key string, exclude []string) bool {
+	for _, e := range exclude {
+		if key == e {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifySignature checks the host's own signature, made with the key of its
+// destination over the line without the signature.
+func (h *Host) VerifySignature() error {
+	sig, ok := h.Props[PropSig]
+	if !ok {
+		return fmt.Errorf("%s is not signed", h.Host)
+	}
+	return VerifyDestinationSignature(i2pkeys.I2PAddr(h.Destination), []byte(h.line(PropSig)), sig)
+}
+
+// UnsignedSubdomainError is returned by VerifyParent for a subdomain which
+// carries no signatures at all, as the ones added before the extended hosts.txt
+// format do. Nothing says whether its parent authorized it or not.
+type UnsignedSubdomainError struct {
+	Host   string
+	Parent string
+}
+
+func (e *UnsignedSubdomainError) Error() string {
+	return fmt.Sprintf("%s has no authorization from %s", e.Host, e.Parent)
+}
+
+// VerifyParent checks that the host, a subdomain, was authorized by the key
+// of its parent domain: that it is an addsubdomain line naming parent and
+// parentDest as its old name and destination, that oldsig is the parent's
+// signature over the line without either signature, and that the host's own
+// signature is valid. An unsigned line gets an *UnsignedSubdomainError.
+func (h *Host) VerifyParent(parent, parentDest string) error {
+	if _, ok := h.Props[PropOldSig]; !ok {
+		if _, signed := h.Props[PropSig]; !signed {
+			return &UnsignedSubdomainError{h.Host, parent}
+		}
+		return fmt.Errorf("%s has no authorization from %s", h.Host, parent)
+	}
+	if action := h.Props[PropAction]; !strings.EqualFold(action, ActionAddSubdomain) {
+		return fmt.Errorf("%s is signed as %q instead of an %s line", h.Host, action, ActionAddSubdomain)
+	}
+	if name := h.Props[PropOldName]; name != parent {
+		return fmt.Errorf("%s was authorized by %q instead of its parent domain %s", h.Host, name, parent)
+	}
+	if !sameDestination(h.Props[PropOldDest], parentDest) {
+		return fmt.Errorf("%s was authorized by a different destination than the one %s has", h.Host, parent)
+	}
+	if err := h.verifyOldSig(); err != nil {
+		return fmt.Errorf("the authorization of %s by %s is invalid: %s", h.Host, parent, err)
+	}
+	return nil
+}
+
+// VerifyRollover checks that the host, a changedest line, moves the hostname
+// away from oldDest with the authorization of oldDest's key, and that the new
+// destination signed it as well.
+func (h *Host) VerifyRollover(oldDest string) error {
+	if !strings.EqualFold(h.Props[PropAction], ActionChangeDest) {
+		return fmt.Errorf("%s is not a changedest line", h.Host)
+	}
+	if _, ok := h.Props[PropOldSig]; !ok {
+		return fmt.Errorf("the change of destination of %s is not signed by the old key", h.Host)
+	}
+	if !sameDestination(h.Props[PropOldDest], oldDest) {
+		return fmt.Errorf("the change of destination of %s starts from a destination it does not have", h.Host)
+	}
+	if err := h.verifyOldSig(); err != nil {
+		return fmt.Errorf("the change of destination of %s is invalid: %s", h.Host, err)
+	}
+	return nil
+}
+
+// verifyOldSig checks oldsig, the signature of olddest's key over the line
+// without either signature, and then the host's own signature.
+func (h *Host) verifyOldSig() error {
+	err := VerifyDestinationSignature(i2pkeys.I2PAddr(h.Props[PropOldDest]), []byte(h.line(PropSig, PropOldSig)), h.Props[PropOldSig])
+	if err != nil {
+		return err
+	}
+	if err := h.VerifySignature(); err != nil {
+		return fmt.Errorf("the signature of %s is invalid: %s", h.Host, err)
+	}
+	return nil
+}
+
+// sameDestination reports whether two Base64 destinations are the same,
+// comparing their hashes so that differences in encoding do not matter.
+func sameDestination(a, b string) bool {
+	if a == b {
+		return true
+	}
+	aa, err := ParseDestination(a)
+	if err != nil {
+		return false
+	}
+	ba, err := ParseDestination(b)
+	if err != nil {
+		return false
+	}
+	return aa.Base32() == ba.Base32()
+}
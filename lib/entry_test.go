@@ -0,0 +1,171 @@
+package jump
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eyedeekay/sam3/i2pkeys"
+)
+
+// signWithOld signs h with oldDest's key as oldsig and then with the key of
+// its own destination.
+func signWithOld(t *testing.T, h Host, oldDest i2pkeys.I2PAddr) Host {
+	oldsig, err := bridge.Sign(oldDest, []byte(h.line(PropSig, PropOldSig)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Props[PropOldSig] = oldsig
+	sig, err := bridge.Sign(i2pkeys.I2PAddr(h.Destination), []byte(h.line(PropSig)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Props[PropSig] = sig
+	return h
+}
+
+// signSubdomain returns the line registering name at dest as a subdomain of
+// parent, authorized by parentDest's key and signed by dest's.
+func signSubdomain(t *testing.T, name string, dest i2pkeys.I2PAddr, parent string, parentDest i2pkeys.I2PAddr) Host {
+	return signWithOld(t, Host{Host: name, Destination: dest.Base64(), Props: map[string]string{
+		PropAction:  ActionAddSubdomain,
+		PropDate:    "1700000000",
+		PropOldName: parent,
+		PropOldDest: parentDest.Base64(),
+	}}, parentDest)
+}
+
+// signChangeDest returns the line moving name from oldDest to newDest,
+// authorized by signer's key.
+func signChangeDest(t *testing.T, name string, oldDest, newDest, signer i2pkeys.I2PAddr) Host {
+	return signWithOld(t, Host{Host: name, Destination: newDest.Base64(), Props: map[string]string{
+		PropAction:  ActionChangeDest,
+		PropDate:    "1700000000",
+		PropOldDest: oldDest.Base64(),
+	}}, signer)
+}
+
+func TestParseEntry(t *testing.T) {
+	dest := newDest(t)
+	line := "Example.i2p=" + dest.Base64() + "#!sig=s#date=1#action=adddest"
+	h, err := ParseEntry(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Host != "example.i2p" || h.Destination != dest.Base64() || len(h.Props) != 3 || h.Props[PropDate] != "1" {
+		t.Errorf("unexpected entry: %+v", h)
+	}
+	if got, want := h.String(), "example.i2p="+dest.Base64()+"#!action=adddest#date=1#sig=s\n"; got != want {
+		t.Errorf("extended line written as %q, want %q", got, want)
+	}
+	if h, _ := ParseEntry("plain.i2p=" + dest.Base64() + "#comment"); h.Props != nil || h.String() != "plain.i2p="+dest.Base64()+"\n" {
+		t.Errorf("plain line with a comment parsed as %+v", h)
+	}
+	if _, err := ParseEntry("nothing"); err == nil {
+		t.Error("line without a destination was parsed")
+	}
+	command := "#!name=Example.i2p#dest=" + dest.Base64() + "#sig=s#action=remove#date=1"
+	if h, err := ParseEntry(command); err != nil || h.Host != "example.i2p" || h.Destination != dest.Base64() {
+		t.Errorf("command line parsed as %+v: %v", h, err)
+	} else if got, want := h.String(), "#!action=remove#date=1#dest="+dest.Base64()+"#name=Example.i2p#sig=s\n"; got != want {
+		t.Errorf("command line written as %q, want %q", got, want)
+	}
+	if _, err := ParseEntry("#!action=remove#date=1"); err == nil {
+		t.Error("command line without a name was parsed")
+	}
+}
+
+func TestSubdomainRegistration(t *testing.T) {
+	parentDest, subDest, other := newDest(t), newDest(t), newDest(t)
+	hosts := writeHosts(t, "hosts.txt", map[string]i2pkeys.I2PAddr{"example.i2p": parentDest, "other.i2p": other})
+	is, err := NewI2PServerFromTransport(uniqueName("subdomains"), hosts, nil, NewTCPTransport("127.0.0.1:0", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := signSubdomain(t, "sub.example.i2p", subDest, "example.i2p", parentDest)
+	forged := signSubdomain(t, "sub.other.i2p", subDest, "other.i2p", parentDest)
+	tampered := signSubdomain(t, "sub.example.i2p", subDest, "example.i2p", parentDest)
+	tampered.Props[PropDate] = "1800000000"
+	for i, c := range []struct {
+		name, destination string
+		status            int
+		message           string
+	}{
+		{"sub.example.i2p", subDest.Base64(), http.StatusForbidden, "no authorization from example.i2p"},
+		{"sub.missing.i2p", subDest.Base64(), http.StatusForbidden, "not registered here"},
+		{"", forged.line(), http.StatusForbidden, "different destination"},
+		{"", tampered.line(), http.StatusBadRequest, "invalid signature"},
+		{"wrong.example.i2p", signed.line(), http.StatusBadRequest, "is for sub.example.i2p"},
+	} {
+		_, err := is.Register(c.name, c.destination, "", strings.Repeat("x", i))
+		refused, ok := err.(*RegistrationError)
+		if !ok || refused.Status != c.status || !strings.Contains(refused.Message, c.message) {
+			t.Errorf("registering %q: %v, want %d mentioning %q", c.name, err, c.status, c.message)
+		}
+	}
+	if _, err := is.Register("", signed.line(), "", "client"); err != nil {
+		t.Fatal(err)
+	}
+	if err := is.Approve("sub.example.i2p"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(is.Me.HostsFile()), signed.line()+"\n") {
+		t.Errorf("approved subdomain was not published with its authorization: %s", is.Me.HostsFile())
+	}
+}
+
+func TestTrustChartSubdomains(t *testing.T) {
+	parentDest, subDest := newDest(t), newDest(t)
+	signed := signSubdomain(t, "good.example.i2p", subDest, "example.i2p", parentDest)
+	forged := signSubdomain(t, "forged.example.i2p", subDest, "example.i2p", subDest)
+	moved := signWithOld(t, Host{Host: "moved.example.i2p", Destination: subDest.Base64(), Props: map[string]string{
+		PropAction:  ActionChangeDest,
+		PropDate:    "1700000000",
+		PropOldName: "example.i2p",
+		PropOldDest: parentDest.Base64(),
+	}}, parentDest)
+	body := strings.Join([]string{
+		"example.i2p=" + parentDest.Base64(),
+		signed.line(),
+		strings.TrimSuffix(forged.String(), "\n"),
+		moved.line(),
+		"bare.example.i2p=" + subDest.Base64(),
+	}, "\n")
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rq *http.Request) {
+		rw.Write([]byte(body))
+	}))
+	defer srv.Close()
+	is, err := NewI2PServerFromTransport(uniqueName("subchart"), writeHosts(t, "hosts.txt", nil), nil, NewTCPTransport("127.0.0.1:0", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := is.UpdatePeers([]PeerConfig{{Name: uniqueName("subfeed"), URL: srv.URL + "/hosts.txt"}}); err != nil {
+		t.Fatal(err)
+	}
+	peer := is.Peers()[0]
+	if err := peer.Fetch(); err != nil {
+		t.Fatal(err)
+	}
+	if err := is.ParentAuthorization(peer, "good.example.i2p"); err != nil {
+		t.Errorf("authorized subdomain: %s", err)
+	}
+	for name, message := range map[string]string{
+		"forged.example.i2p": "different destination",
+		"moved.example.i2p":  "instead of an addsubdomain line",
+	} {
+		chart := is.TrustChartSinglePage(name)
+		if !strings.Contains(chart, "Warning: ") || !strings.Contains(chart, message) {
+			t.Errorf("trust chart for %s does not warn about its authorization: %s", name, chart)
+		}
+	}
+	if chart := is.TrustChartSinglePage("bare.example.i2p"); strings.Contains(chart, "Warning: ") || !strings.Contains(chart, "unsigned legacy entry") {
+		t.Errorf("trust chart does not tell an unsigned legacy subdomain from an invalid one: %s", chart)
+	}
+	if record, _ := is.Trust().Record("bare.example.i2p"); len(record.Votes) != 1 || record.Votes[0].Warning != "" || record.Votes[0].Notice == "" {
+		t.Errorf("trust report of an unsigned legacy subdomain: %+v", record)
+	}
+	if chart := is.TrustChartSinglePage("good.example.i2p"); strings.Contains(chart, "Warning: ") {
+		t.Errorf("trust chart warns about an authorized subdomain: %s", chart)
+	}
+}
@@ -0,0 +1,362 @@
+package jump
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"i2pgit.org/idk/jump-transparency/lib/naming"
+)
+
+// Actions of the expiry policy.
+const (
+	ActionRenew   = "renew"
+	ActionRelease = "release"
+	// ByExpiry marks changes made by the expiry policy in the transparency
+	// log.
+	ByExpiry = "expiry"
+)
+
+// probeWorkers is how many sites are probed at once.
+const probeWorkers = 8
+
+// renewalWindow is how far the date of a renewal may be from the time it is
+// submitted, so that an old renewal cannot be replayed to keep a name alive.
+const renewalWindow = time.Hour * 24
+
+// ExpiryConfig is the policy under which registrations expire. A name which
+// has been neither renewed by its owner nor seen alive by the prober for
+// Lifetime is taken down and held for its owner for Grace, after which it is
+// released for anyone to register.
+type ExpiryConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Lifetime time.Duration `yaml:"lifetime"`
+	Grace    time.Duration `yaml:"grace"`
+	// ProbeInterval is how often each registered site is probed.
+	ProbeInterval time.Duration `yaml:"probe_interval"`
+	// ProbeTimeout is how long a probe waits for a site to answer.
+	ProbeTimeout time.Duration `yaml:"probe_timeout"`
+}
+
+// DefaultExpiryConfig returns the policy used when none is configured. It is
+// disabled, so that names live forever unless the administrator decides
+// otherwise.
+func DefaultExpiryConfig() ExpiryConfig {
+	return ExpiryConfig{
+		Lifetime:      time.Hour * 24 * 365,
+		Grace:         time.Hour * 24 * 30,
+		ProbeInterval: time.Hour * 24,
+		ProbeTimeout:  time.Minute * 2,
+	}
+}
+
+// Validate checks that an enabled policy has positive durations.
+func (c ExpiryConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Lifetime <= 0 || c.Grace <= 0 || c.ProbeInterval <= 0 || c.ProbeTimeout <= 0 {
+		return fmt.Errorf("lifetime, grace, probe_interval and probe_timeout must be positive")
+	}
+	return nil
+}
+
+// GraceEntry is an expired name held for its owner.
+type GraceEntry struct {
+	Entry   Host
+	Expired time.Time
+}
+
+// Expiry keeps track of when each registered name was last renewed or seen
+// alive, and of the expired names in their grace period.
+type Expiry struct {
+	mutex sync.RWMutex
+	path  string
+	seen  map[string]time.Time
+	grace map[string]GraceEntry
+}
+
+// LoadExpiry reads the expiry state saved at path. A missing file is an empty
+// state.
+func LoadExpiry(path string) (*Expiry, error) {
+	e := &Expiry{path: path, seen: make(map[string]time.Time), grace: make(map[string]GraceEntry)}
+	lines, err := ReadHostsFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		unix, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "seen":
+			e.seen[fields[2]] = time.Unix(unix, 0)
+		case "grace":
+			if entry, err := ParseEntry(fields[2]); err == nil {
+				e.grace[entry.Host] = GraceEntry{entry, time.Unix(unix, 0)}
+			}
+		}
+	}
+	return e, nil
+}
+
+// Save writes the state to disk.
+func (e *Expiry) Save() error {
+	e.mutex.RLock()
+	var lines []string
+	for host, at := range e.seen {
+		lines = append(lines, fmt.Sprintf("seen %d %s", at.Unix(), host))
+	}
+	for _, g := range e.grace {
+		lines = append(lines, fmt.Sprintf("grace %d %s", g.Expired.Unix(), g.Entry.line()))
+	}
+	e.mutex.RUnlock()
+	if e.path == "" {
+		return nil
+	}
+	sort.Strings(lines)
+	return ioutil.WriteFile(e.path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+// Seen notes that host was renewed or seen alive at.
+func (e *Expiry) Seen(host string, at time.Time) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if at.After(e.seen[host]) {
+		e.seen[host] = at
+	}
+}
+
+// LastSeen returns when host was last renewed or seen alive.
+func (e *Expiry) LastSeen(host string) (time.Time, bool) {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	at, ok := e.seen[host]
+	return at, ok
+}
+
+// Forget drops what is known about host.
+func (e *Expiry) Forget(host string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	delete(e.seen, host)
+}
+
+// Hold puts an expired entry on the grace list.
+func (e *Expiry) Hold(entry Host, at time.Time) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.grace[entry.Host] = GraceEntry{entry, at}
+}
+
+// Held returns the grace list entry for host.
+func (e *Expiry) Held(host string) (GraceEntry, bool) {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	g, ok := e.grace[host]
+	return g, ok
+}
+
+// Release takes host off the grace list, reporting whether it was there.
+func (e *Expiry) Release(host string) bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	_, ok := e.grace[host]
+	delete(e.grace, host)
+	return ok
+}
+
+// Grace returns the grace list, ordered by hostname.
+func (e *Expiry) Grace() []GraceEntry {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	var grace []GraceEntry
+	for _, g := range e.grace {
+		grace = append(grace, g)
+	}
+	sort.Slice(grace, func(i, j int) bool {
+		return grace[i].Entry.Host < grace[j].Entry.Host
+	})
+	return grace
+}
+
+func (ws *WebServer) expiryPolicy() ExpiryConfig {
+	ws.mutex.RLock()
+	defer ws.mutex.RUnlock()
+	return ws.expiry
+}
+
+// GraceFile returns the names in their grace period as a hosts file, each
+// with the time it expired and the time it will be released.
+func (ws *WebServer) GraceFile() []byte {
+	policy := ws.expiryPolicy()
+	var grace []byte
+	for _, g := range ws.Expiry.Grace() {
+		line := fmt.Sprintf("%s=%s#!expired=%d#release=%d\n", g.Entry.Host, g.Entry.Destination, g.Expired.Unix(), g.Expired.Add(policy.Grace).Unix())
+		grace = append(grace, line...)
+	}
+	return grace
+}
+
+// Probe dials every registered site which has not been seen alive within the
+// probe interval, and notes the ones which answer.
+func (ws *WebServer) Probe(ctx context.Context) {
+	policy := ws.expiryPolicy()
+	hosts := make(chan Host)
+	var wg sync.WaitGroup
+	for i := 0; i < probeWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for h := range hosts {
+				if ws.probe(ctx, h, policy.ProbeTimeout) {
+					ws.Expiry.Seen(h.Host, time.Now())
+				}
+			}
+		}()
+	}
+	for _, h := range ws.Me.Hosts().HostList {
+		if last, ok := ws.Expiry.LastSeen(h.Host); ok && time.Since(last) < policy.ProbeInterval {
+			continue
+		}
+		select {
+		case hosts <- h:
+		case <-ctx.Done():
+		}
+	}
+	close(hosts)
+	wg.Wait()
+}
+
+// probe reports whether the site at h's destination accepts a connection.
+func (ws *WebServer) probe(ctx context.Context, h Host, timeout time.Duration) bool {
+	addr, err := ParseDestination(h.Destination)
+	if err != nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	conn, err := ws.Transport.Dial(ctx, addr.Base32())
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// Expire takes down the names which have been neither renewed nor seen alive
+// for the lifetime, putting them on the grace list, and releases the names
+// whose grace period is over. Names the policy has no record of start their
+// lifetime now.
+func (ws *WebServer) Expire(now time.Time) error {
+	policy := ws.expiryPolicy()
+	if !policy.Enabled {
+		return nil
+	}
+	for _, h := range ws.Me.Hosts().HostList {
+		last, ok := ws.Expiry.LastSeen(h.Host)
+		if !ok {
+			ws.Expiry.Seen(h.Host, now)
+			continue
+		}
+		if now.Sub(last) <= policy.Lifetime {
+			continue
+		}
+		removal := Host{Host: h.Host, Destination: h.Destination, Props: map[string]string{
+			PropAction: ActionRemove,
+			PropDate:   strconv.FormatInt(now.Unix(), 10),
+		}}
+		if err := ws.remove(removal, ByExpiry, fmt.Sprintf("not renewed or seen alive since %s", last.UTC().Format(time.RFC3339))); err != nil {
+			return err
+		}
+		ws.Expiry.Hold(Host{Host: h.Host, Destination: h.Destination, Description: h.Description}, now)
+	}
+	for _, g := range ws.Expiry.Grace() {
+		if now.Sub(g.Expired) <= policy.Grace {
+			continue
+		}
+		ws.Expiry.Release(g.Entry.Host)
+		log.Printf("released expired name: %s", g.Entry.Host)
+		if err := ws.Transparency.Append(ActionRelease, g.Entry.Host, ByExpiry, "grace period over"); err != nil {
+			return err
+		}
+	}
+	return ws.Expiry.Save()
+}
+
+// runExpiry probes the registered sites and applies the expiry policy every
+// probe interval, until ctx is done. While the policy is disabled it only
+// checks whether it has been enabled.
+func (ws *WebServer) runExpiry(ctx context.Context) {
+	for {
+		policy := ws.expiryPolicy()
+		wait := time.Minute
+		if policy.Enabled {
+			ws.Probe(ctx)
+			// A probe cut short by shutdown saw no site alive, which is
+			// no reason to expire them.
+			if ctx.Err() != nil {
+				return
+			}
+			if err := ws.Expire(time.Now()); err != nil {
+				log.Printf("applying the expiry policy: %s", err)
+			}
+			wait = policy.ProbeInterval
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// renew handles a renewal of a hostname signed by the key it is registered
+// with. A name in its grace period is restored.
+func (ws *WebServer) renew(entry Host) error {
+	if _, signed := entry.Props[PropSig]; !signed {
+		return refuse(http.StatusForbidden, "A renewal of %s has to be signed by the key it is registered with", naming.Display(entry.Host))
+	}
+	unix, err := strconv.ParseInt(entry.Props[PropDate], 10, 64)
+	if err != nil {
+		return refuse(http.StatusBadRequest, "A renewal needs the date it was signed at")
+	}
+	if d := time.Since(time.Unix(unix, 0)); d > renewalWindow || d < -renewalWindow {
+		return refuse(http.StatusBadRequest, "A renewal has to be dated within %s of now", renewalWindow)
+	}
+	if current, ok := ws.Me.Hosts().Lookup(entry.Host); ok {
+		if !sameDestination(current.Destination, entry.Destination) {
+			return refuse(http.StatusForbidden, "A renewal of %s has to be signed by the key it is registered with", naming.Display(entry.Host))
+		}
+		ws.Expiry.Seen(entry.Host, time.Now())
+		if err := ws.Transparency.Append(ActionRenew, entry.Host, ByOwner, ""); err != nil {
+			return err
+		}
+		return ws.Expiry.Save()
+	}
+	held, ok := ws.Expiry.Held(entry.Host)
+	if !ok {
+		return refuse(http.StatusNotFound, "%s is not registered here", naming.Display(entry.Host))
+	}
+	if !sameDestination(held.Entry.Destination, entry.Destination) {
+		return refuse(http.StatusForbidden, "A renewal of %s has to be signed by the key it was registered with", naming.Display(entry.Host))
+	}
+	if !ws.Me.AppendHost(held.Entry) {
+		return refuse(http.StatusConflict, "%s is already registered", naming.Display(entry.Host))
+	}
+	ws.Expiry.Release(entry.Host)
+	log.Printf("renewed during the grace period: %s", entry.Host)
+	return ws.record(held.Entry, ByOwner, "renewed during the grace period")
+}
@@ -0,0 +1,150 @@
+package jump
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eyedeekay/sam3/i2pkeys"
+)
+
+// signRenewal returns the line renewing name at dest, dated at.
+func signRenewal(t *testing.T, name string, dest i2pkeys.I2PAddr, at time.Time) string {
+	h := Host{Host: name, Destination: dest.Base64(), Props: map[string]string{
+		PropAction: ActionRenew,
+		PropDate:   strconv.FormatInt(at.Unix(), 10),
+	}}
+	sig, err := bridge.Sign(dest, []byte(h.line(PropSig)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Props[PropSig] = sig
+	return h.line()
+}
+
+func TestExpiry(t *testing.T) {
+	stale, renewed, other := newDest(t), newDest(t), newDest(t)
+	hosts := writeHosts(t, "hosts.txt", map[string]i2pkeys.I2PAddr{"stale.i2p": stale, "renewed.i2p": renewed})
+	is, err := NewI2PServerFromTransport(uniqueName("expiring"), hosts, nil, NewTCPTransport("127.0.0.1:0", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	cfg.Expiry.Enabled = true
+	is.Configure(cfg)
+	now := time.Now()
+	if err := is.Expire(now.Add(-cfg.Expiry.Lifetime * 2)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := is.Register("", signRenewal(t, "renewed.i2p", renewed, now.Add(-renewalWindow*2)), "", "owner"); err == nil {
+		t.Error("stale renewal was accepted")
+	}
+	if _, err := is.Register("", signRenewal(t, "renewed.i2p", renewed, now), "", "owner"); err != nil {
+		t.Fatal(err)
+	}
+	if err := is.Expire(now); err != nil {
+		t.Fatal(err)
+	}
+	if got := is.Me.ToMap(); len(got) != 1 || got["renewed.i2p"] == "" {
+		t.Errorf("unexpected hosts after expiring: %v", got)
+	}
+
+	rw := httptest.NewRecorder()
+	is.WebServer.ServeHTTP(rw, httptest.NewRequest("GET", "/grace.txt", nil))
+	if grace := rw.Body.String(); !strings.HasPrefix(grace, "stale.i2p="+stale.Base64()+"#!expired=") {
+		t.Errorf("expired name is not on the grace list: %q", grace)
+	}
+	rw = httptest.NewRecorder()
+	is.WebServer.ServeHTTP(rw, httptest.NewRequest("GET", "/hosts.txt", nil))
+	if strings.Contains(rw.Body.String(), "stale.i2p") {
+		t.Errorf("expired name is still published: %s", rw.Body.String())
+	}
+	_, err = is.Register("stale.i2p", other.Base64(), "", "squatter")
+	if refused, ok := err.(*RegistrationError); !ok || refused.Status != http.StatusConflict {
+		t.Errorf("registering a name in its grace period: %v", err)
+	}
+
+	if _, err := is.Register("", signRenewal(t, "stale.i2p", stale, now), "", "owner"); err != nil {
+		t.Fatal(err)
+	}
+	if got := is.Me.ToMap(); got["stale.i2p"] != stale.Base64() {
+		t.Errorf("renewal during the grace period did not restore the name: %v", got)
+	}
+
+	is.Expiry.Forget("renewed.i2p")
+	is.Expiry.Seen("renewed.i2p", now.Add(-cfg.Expiry.Lifetime*2))
+	if err := is.Expire(now); err != nil {
+		t.Fatal(err)
+	}
+	if err := is.Expire(now.Add(cfg.Expiry.Grace * 2)); err != nil {
+		t.Fatal(err)
+	}
+	if _, held := is.Expiry.Held("renewed.i2p"); held {
+		t.Error("name was not released after its grace period")
+	}
+	if _, err := is.Register("renewed.i2p", other.Base64(), "", "newcomer"); err != nil {
+		t.Errorf("registering a released name: %v", err)
+	}
+	rw = httptest.NewRecorder()
+	is.WebServer.ServeHTTP(rw, httptest.NewRequest("GET", "/transparency", nil))
+	log := rw.Body.String()
+	for _, want := range []string{" remove stale.i2p expiry ", " renew renewed.i2p owner -\n", " add stale.i2p owner ", " release renewed.i2p expiry "} {
+		if !strings.Contains(log, want) {
+			t.Errorf("transparency log does not contain %q: %s", want, log)
+		}
+	}
+}
+
+// hangingDial holds up dials until their context is done.
+type hangingDial struct {
+	*TCPTransport
+	started chan struct{}
+}
+
+func (t *hangingDial) Dial(ctx context.Context, host string) (net.Conn, error) {
+	select {
+	case t.started <- struct{}{}:
+	default:
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestExpiryStoppedDuringProbe(t *testing.T) {
+	dest := newDest(t)
+	hosts := writeHosts(t, "hosts.txt", map[string]i2pkeys.I2PAddr{"quiet.i2p": dest})
+	transport := &hangingDial{NewTCPTransport("127.0.0.1:0", nil), make(chan struct{}, 1)}
+	is, err := NewI2PServerFromTransport(uniqueName("probing"), hosts, nil, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	cfg.Expiry.Enabled = true
+	is.Configure(cfg)
+	is.Expiry.Seen("quiet.i2p", time.Now().Add(-cfg.Expiry.Lifetime*2))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		is.runExpiry(ctx)
+		close(done)
+	}()
+	<-transport.started
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("expiry did not stop with its context")
+	}
+	if is.Me.ToMap()["quiet.i2p"] != dest.Base64() {
+		t.Error("a name was expired by a probe cut short by shutdown")
+	}
+	if _, held := is.Expiry.Held("quiet.i2p"); held {
+		t.Error("a name was put on the grace list by a probe cut short by shutdown")
+	}
+}
@@ -0,0 +1,156 @@
+package jump
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"i2pgit.org/idk/jump-transparency/lib/naming"
+)
+
+// Formats a hosts file can be exported to and imported from.
+const (
+	// FormatHosts is hosts.txt with the properties of extended lines, as
+	// Java I2P publishes its address book and subscribes to others.
+	FormatHosts = "hosts"
+	// FormatPrivate is plain hostname=destination lines, as in the
+	// privatehosts.txt and userhosts.txt of the Java I2P address book.
+	FormatPrivate = "private"
+	// FormatAddresses is the addresses.csv of i2pd, mapping each hostname
+	// to the base32 hash of its destination.
+	FormatAddresses = "addresses"
+	// FormatBlockfile is the hostsdb.blockfile of the Java I2P address
+	// book.
+	FormatBlockfile = "blockfile"
+)
+
+// Formats lists the formats Export and Import understand.
+var Formats = []string{FormatHosts, FormatPrivate, FormatAddresses, FormatBlockfile}
+
+// RejectUnresolved counts the addresses.csv lines whose base32 address is
+// not the hash of any known destination.
+const RejectUnresolved = "unknown destination"
+
+// PrivateHostsFile returns the hosts as plain lines, without properties or
+// remove lines, for the Java I2P address book's private hosts files.
+func (ht *HostsTxt) PrivateHostsFile() []byte {
+	var private []byte
+	for _, h := range ht.HostList {
+		private = append(private, h.Host+"="+h.Destination+"\n"...)
+	}
+	return private
+}
+
+// AddressesCSV returns the hosts in i2pd's addresses.csv format.
+func (ht *HostsTxt) AddressesCSV() []byte {
+	var csv []byte
+	for _, h := range ht.HostList {
+		addr, err := ParseDestination(h.Destination)
+		if err != nil {
+			continue
+		}
+		csv = append(csv, h.Host+","+strings.TrimSuffix(addr.Base32(), ".b32.i2p")+"\n"...)
+	}
+	return csv
+}
+
+// Export returns the hosts in format.
+func (ht *HostsTxt) Export(format string) ([]byte, error) {
+	switch format {
+	case FormatHosts:
+		return ht.HostsFile(), nil
+	case FormatPrivate:
+		return ht.PrivateHostsFile(), nil
+	case FormatAddresses:
+		return ht.AddressesCSV(), nil
+	case FormatBlockfile:
+		return NewHostsDB(ht, "hosts.txt").Bytes()
+	}
+	return nil, fmt.Errorf("unknown format %q, expected one of %s", format, strings.Join(Formats, ", "))
+}
+
+// Import reads hosts in format from r within limits. An addresses.csv only
+// holds the hash of each destination, so its hostnames are resolved against
+// the destinations in known and the ones no known destination matches are
+// rejected.
+func Import(r io.Reader, format string, known *HostsTxt, limits FeedLimits) (*HostsTxt, error) {
+	switch format {
+	case FormatHosts, FormatPrivate:
+		return ReadHostsTxt(r, limits)
+	case FormatAddresses:
+		return ReadAddressesCSV(r, known, limits)
+	case FormatBlockfile:
+		lr := &io.LimitedReader{R: r, N: limits.MaxBodySize + 1}
+		data, err := ioutil.ReadAll(lr)
+		if err != nil {
+			return nil, err
+		}
+		if lr.N <= 0 {
+			return nil, fmt.Errorf("hostsdb.blockfile is larger than %d bytes", limits.MaxBodySize)
+		}
+		db, err := ReadHostsDB(data)
+		if err != nil {
+			return nil, err
+		}
+		return db.HostsTxt(), nil
+	}
+	return nil, fmt.Errorf("unknown format %q, expected one of %s", format, strings.Join(Formats, ", "))
+}
+
+// ReadAddressesCSV parses an i2pd addresses.csv from r within limits,
+// resolving each base32 address to a destination in known.
+func ReadAddressesCSV(r io.Reader, known *HostsTxt, limits FeedLimits) (*HostsTxt, error) {
+	destinations := make(map[string]string)
+	if known != nil {
+		for _, h := range known.HostList {
+			if addr, err := ParseDestination(h.Destination); err == nil {
+				destinations[addr.Base32()] = h.Destination
+			}
+		}
+	}
+	ht := newHostsTxt()
+	lr := &io.LimitedReader{R: r, N: limits.MaxBodySize + 1}
+	sc := bufio.NewScanner(lr)
+	sc.Buffer(make([]byte, 0, limits.MaxLineLength+1), limits.MaxLineLength+1)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) != 2 {
+			ht.reject(RejectMalformed)
+			continue
+		}
+		hostname := naming.Normalize(fields[0])
+		if err := naming.Validate(hostname); err != nil {
+			ht.reject(RejectInvalidHostname)
+			continue
+		}
+		base32 := strings.ToLower(strings.TrimSpace(fields[1]))
+		dest, ok := destinations[strings.TrimSuffix(base32, ".b32.i2p")+".b32.i2p"]
+		if !ok {
+			ht.reject(RejectUnresolved)
+			continue
+		}
+		if limits.MaxEntries > 0 && len(ht.HostList) >= limits.MaxEntries {
+			ht.reject(RejectTooManyEntries)
+			continue
+		}
+		if !ht.Append(hostname, dest, "") {
+			ht.reject(RejectDuplicate)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		if err == bufio.ErrTooLong {
+			return nil, fmt.Errorf("addresses.csv has a line longer than %d bytes", limits.MaxLineLength)
+		}
+		return nil, err
+	}
+	if lr.N <= 0 {
+		return nil, fmt.Errorf("addresses.csv is larger than %d bytes", limits.MaxBodySize)
+	}
+	return ht, nil
+}
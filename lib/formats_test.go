@@ -0,0 +1,61 @@
+package jump
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eyedeekay/sam3/i2pkeys"
+)
+
+func TestFormats(t *testing.T) {
+	one, two := newDest(t), newDest(t)
+	hosts := writeHosts(t, "hosts.txt", map[string]i2pkeys.I2PAddr{"one.i2p": one, "two.i2p": two})
+	ht, err := NewHostsTxt(hosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csv, err := ht.Export(FormatAddresses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(csv), "one.i2p,"+strings.TrimSuffix(one.Base32(), ".b32.i2p")+"\n") {
+		t.Errorf("unexpected addresses.csv: %s", csv)
+	}
+	csv = append(csv, "unknown.i2p,"+strings.TrimSuffix(newDest(t).Base32(), ".b32.i2p")+"\nmalformed.i2p\n"...)
+	known := ParseHostsTxt([]string{"two.i2p=" + two.Base64(), "other.i2p=" + one.Base64()})
+	imported, err := Import(bytes.NewReader(csv), FormatAddresses, known, DefaultFeedLimits())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := imported.ToMap(); len(got) != 2 || got["one.i2p"] != one.Base64() || got["two.i2p"] != two.Base64() {
+		t.Errorf("unexpected hosts imported from addresses.csv: %v", got)
+	}
+	if imported.Rejected[RejectUnresolved] != 1 || imported.Rejected[RejectMalformed] != 1 {
+		t.Errorf("unexpected rejected lines: %v", imported.Rejected)
+	}
+
+	ht.Replace(Host{Host: "two.i2p", Destination: two.Base64(), Props: map[string]string{PropDate: "1700000000"}})
+	if private, _ := ht.Export(FormatPrivate); strings.Contains(string(private), "#!") || !strings.Contains(string(private), "two.i2p="+two.Base64()+"\n") {
+		t.Errorf("private hosts file has properties: %s", private)
+	}
+	if _, err := ht.Export("bogus"); err == nil {
+		t.Error("exported to an unknown format")
+	}
+
+	is, err := NewI2PServerFromTransport(uniqueName("formats"), hosts, nil, NewTCPTransport("127.0.0.1:0", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{
+		"/addresses.csv":    "two.i2p," + strings.TrimSuffix(two.Base32(), ".b32.i2p") + "\n",
+		"/privatehosts.txt": "two.i2p=" + two.Base64() + "\n",
+	} {
+		rw := httptest.NewRecorder()
+		is.WebServer.ServeHTTP(rw, httptest.NewRequest("GET", path, nil))
+		if !strings.Contains(rw.Body.String(), want) {
+			t.Errorf("%s does not contain %q: %s", path, want, rw.Body.String())
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package jump
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Actions recorded in the history besides those of the extended format.
+const (
+	ActionAdd        = "add"
+	ActionChangeDest = "changedest"
+	ActionRemove     = "remove"
+)
+
+// HistoryEvent is one change to a hostname in our hosts file: the line which
+// was published, with the action it carried.
+type HistoryEvent struct {
+	Time  time.Time
+	Entry Host
+}
+
+// Action returns what the event did to the hostname.
+func (e HistoryEvent) Action() string {
+	if action, ok := e.Entry.Props[PropAction]; ok {
+		return strings.ToLower(action)
+	}
+	return ActionAdd
+}
+
+func (e HistoryEvent) String() string {
+	return strconv.FormatInt(e.Time.Unix(), 10) + " " + e.Entry.line()
+}
+
+// History is the record of every change made to our hosts file, kept per
+// hostname and appended to a file as it happens.
+type History struct {
+	mutex  sync.RWMutex
+	path   string
+	events map[string][]HistoryEvent
+}
+
+// LoadHistory reads the history saved at path, which it appends new events
+// to. A missing file is an empty history.
+func LoadHistory(path string) (*History, error) {
+	h := &History{path: path, events: make(map[string][]HistoryEvent)}
+	lines, err := ReadHostsFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		unix, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		entry, err := ParseEntry(fields[1])
+		if err != nil {
+			continue
+		}
+		h.events[entry.Host] = append(h.events[entry.Host], HistoryEvent{time.Unix(unix, 0), entry})
+	}
+	return h, nil
+}
+
+// Record adds an event for entry and saves it.
+func (h *History) Record(entry Host) error {
+	event := HistoryEvent{time.Now(), entry}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.events[entry.Host] = append(h.events[entry.Host], event)
+	if h.path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, event); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Of returns the events for hostname, oldest first.
+func (h *History) Of(hostname string) []HistoryEvent {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return append([]HistoryEvent(nil), h.events[hostname]...)
+}
+
+// Removals returns the events which removed a hostname which has not been
+// registered again since, ordered by hostname.
+func (h *History) Removals() []HistoryEvent {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	var removals []HistoryEvent
+	for _, events := range h.events {
+		if last := events[len(events)-1]; last.Action() == ActionRemove {
+			removals = append(removals, last)
+		}
+	}
+	sort.Slice(removals, func(i, j int) bool {
+		return removals[i].Entry.Host < removals[j].Entry.Host
+	})
+	return removals
+}
+
+// RolledOver reports whether dest was a destination of hostname which an
+// authorized changedest replaced.
+func (h *History) RolledOver(hostname, dest string) bool {
+	for _, event := range h.Of(hostname) {
+		if event.Action() == ActionChangeDest && sameDestination(event.Entry.Props[PropOldDest], dest) {
+			return true
+		}
+	}
+	return false
+}
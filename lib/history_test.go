@@ -0,0 +1,113 @@
+package jump
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eyedeekay/sam3/i2pkeys"
+)
+
+func TestChangeDest(t *testing.T) {
+	oldDest, newDest, other := newDest(t), newDest(t), newDest(t)
+	name := uniqueName("rollover")
+	hosts := writeHosts(t, "hosts.txt", map[string]i2pkeys.I2PAddr{"site.i2p": oldDest})
+	is, err := NewI2PServerFromTransport(name, hosts, nil, NewTCPTransport("127.0.0.1:0", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := signChangeDest(t, "site.i2p", oldDest, newDest, other)
+	if _, err := is.Register("", forged.line(), "", "forger"); err == nil || err.(*RegistrationError).Status != http.StatusForbidden {
+		t.Errorf("change of destination signed by another key: %v", err)
+	}
+	unknown := signChangeDest(t, "unknown.i2p", oldDest, newDest, oldDest)
+	if _, err := is.Register("", unknown.line(), "", "stranger"); err == nil || err.(*RegistrationError).Status != http.StatusNotFound {
+		t.Errorf("change of destination of an unregistered name: %v", err)
+	}
+
+	rollover := signChangeDest(t, "site.i2p", oldDest, newDest, oldDest)
+	registration, err := is.Register("", rollover.line(), "", "owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !registration.Applied || !strings.Contains(registration.String(), "now points to the new destination") {
+		t.Errorf("change of destination was not applied at once: %s", registration)
+	}
+	if got := is.Me.ToMap()["site.i2p"]; got != newDest.Base64() {
+		t.Errorf("site.i2p still points to %q", got)
+	}
+	if !strings.Contains(string(is.Me.HostsFile()), "#!action=changedest#date=") {
+		t.Errorf("change of destination is not published in the extended format: %s", is.Me.HostsFile())
+	}
+
+	history, err := LoadHistory(name + "-history.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := history.Of("site.i2p")
+	if len(events) != 1 || events[0].Action() != ActionChangeDest || events[0].Entry.Destination != newDest.Base64() {
+		t.Fatalf("unexpected history after reloading: %v", events)
+	}
+	if !history.RolledOver("site.i2p", oldDest.Base64()) || history.RolledOver("site.i2p", other.Base64()) {
+		t.Error("history does not know which destination was rolled over")
+	}
+	rw := httptest.NewRecorder()
+	is.WebServer.ServeHTTP(rw, httptest.NewRequest("GET", "/history/site.i2p", nil))
+	if !strings.Contains(rw.Body.String(), " "+rollover.line()+"\n") {
+		t.Errorf("history page does not show the change: %q", rw.Body.String())
+	}
+}
+
+func TestTrustChartRollover(t *testing.T) {
+	oldDest, newDest, hijacker := newDest(t), newDest(t), newDest(t)
+	rollover := signChangeDest(t, "site.i2p", oldDest, newDest, oldDest)
+	serve := func(body string) string {
+		srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rq *http.Request) {
+			rw.Write([]byte(body))
+		}))
+		t.Cleanup(srv.Close)
+		return srv.URL + "/hosts.txt"
+	}
+	is, err := NewI2PServerFromTransport(uniqueName("rolled"), writeHosts(t, "hosts.txt", map[string]i2pkeys.I2PAddr{"site.i2p": oldDest}), nil, NewTCPTransport("127.0.0.1:0", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ahead, hijacked := uniqueName("ahead"), uniqueName("hijacked")
+	err = is.UpdatePeers([]PeerConfig{
+		{Name: ahead, URL: serve(rollover.line() + "\n")},
+		{Name: hijacked, URL: serve("site.i2p=" + hijacker.Base64() + "\n")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, peer := range is.Peers() {
+		if err := peer.Fetch(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, peer := range is.Peers() {
+		want := map[string]string{ahead: "authorized change from our destination", hijacked: "may have been hijacked"}[peer.Name]
+		if got := is.Disagreement(peer, "site.i2p"); !strings.Contains(got, want) {
+			t.Errorf("%s: %q, want %q", peer.Name, got, want)
+		}
+	}
+
+	if _, err := is.Register("", rollover.line(), "", "owner"); err != nil {
+		t.Fatal(err)
+	}
+	stale := uniqueName("stale")
+	if err := is.UpdatePeers([]PeerConfig{{Name: stale, URL: serve("site.i2p=" + oldDest.Base64() + "\n")}}); err != nil {
+		t.Fatal(err)
+	}
+	peer := is.Peers()[0]
+	if err := peer.Fetch(); err != nil {
+		t.Fatal(err)
+	}
+	if got := is.Disagreement(peer, "site.i2p"); !strings.Contains(got, "not caught up") {
+		t.Errorf("stale peer: %q", got)
+	}
+	if chart := is.TrustChartSinglePage("site.i2p"); !strings.Contains(chart, "not caught up") {
+		t.Errorf("trust chart does not explain the disagreement: %s", chart)
+	}
+}
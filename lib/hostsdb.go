@@ -0,0 +1,310 @@
+package jump
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The skip lists of the Java address book besides its lists of hosts, and
+// the properties it keeps.
+const (
+	hostsDBInfo    = "%%__INFO__%%"
+	hostsDBReverse = "%%__REVERSE__%%"
+	hostsDBVersion = "4"
+	// HostsDBSource is the property naming where an entry came from.
+	HostsDBSource = "s"
+	// HostsDBAdded is the property holding when an entry was added, in
+	// milliseconds since the epoch.
+	HostsDBAdded = "a"
+)
+
+// hostsDBLists are the lists of hosts of the Java address book, in the order
+// it looks names up in them.
+var hostsDBLists = []string{"privatehosts.txt", "userhosts.txt", "hosts.txt"}
+
+// HostsDBEntry is a hostname in a list of a hostsdb.blockfile, with the
+// properties the address book keeps for it.
+type HostsDBEntry struct {
+	Host  Host
+	Props map[string]string
+}
+
+// Source returns where the entry came from.
+func (e HostsDBEntry) Source() string {
+	return e.Props[HostsDBSource]
+}
+
+// Added returns when the entry was added, or the zero time if it is not
+// known.
+func (e HostsDBEntry) Added() time.Time {
+	ms, err := strconv.ParseInt(e.Props[HostsDBAdded], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// HostsDB is the content of the hostsdb.blockfile of a Java router's address
+// book: its lists of hosts, in lookup order.
+type HostsDB struct {
+	Lists   []string
+	Entries map[string][]HostsDBEntry
+}
+
+// ReadHostsDB parses a hostsdb.blockfile. Entries holding more than one
+// destination keep only the first.
+func ReadHostsDB(data []byte) (*HostsDB, error) {
+	// Files of any minor version of the format are read.
+	if len(data) < blockPageSize || binary.BigEndian.Uint64(data)>>8 != blockMagic>>8 {
+		return nil, fmt.Errorf("not a block file")
+	}
+	if size := binary.BigEndian.Uint64(data[8:]); size > uint64(len(data)) {
+		return nil, fmt.Errorf("block file is truncated to %d of %d bytes", len(data), size)
+	}
+	r := &blockReader{data}
+	names, pages, err := r.skipList(blockMetaIndexPage)
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string]uint32)
+	for i, name := range names {
+		if len(pages[i]) == 4 {
+			index[string(name)] = binary.BigEndian.Uint32(pages[i])
+		}
+	}
+	db := &HostsDB{Entries: make(map[string][]HostsDBEntry)}
+	if page, ok := index[hostsDBInfo]; ok {
+		keys, values, err := r.skipList(page)
+		if err != nil {
+			return nil, err
+		}
+		for i, key := range keys {
+			if string(key) != "info" {
+				continue
+			}
+			info, _, err := readProperties(values[i])
+			if err != nil {
+				return nil, fmt.Errorf("address book info: %s", err)
+			}
+			if lists := info["lists"]; lists != "" {
+				db.Lists = strings.Split(lists, ",")
+			}
+		}
+	}
+	if db.Lists == nil {
+		db.Lists = hostsDBLists
+	}
+	for _, list := range db.Lists {
+		page, ok := index[list]
+		if !ok {
+			continue
+		}
+		keys, values, err := r.skipList(page)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", list, err)
+		}
+		for i, key := range keys {
+			props, rest, err := readProperties(values[i])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %s", list, key, err)
+			}
+			dest, err := readDestination(rest)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %s", list, key, err)
+			}
+			db.Entries[list] = append(db.Entries[list], HostsDBEntry{Host{Host: string(key), Destination: dest}, props})
+		}
+	}
+	return db, nil
+}
+
+// HostsTxt returns the hosts of all lists as a hosts file. Where a hostname
+// is in more than one list, the entry of the list the address book looks in
+// first is kept; invalid names and destinations are rejected like those of a
+// hosts file.
+func (db *HostsDB) HostsTxt() *HostsTxt {
+	ht := newHostsTxt()
+	for _, list := range db.Lists {
+		for _, e := range db.Entries[list] {
+			ht.parseLine(e.Host.Host+"="+e.Host.Destination, 0)
+		}
+	}
+	return ht
+}
+
+// NewHostsDB returns an address book with the hosts of ht in its hosts.txt
+// list, marked as coming from source.
+func NewHostsDB(ht *HostsTxt, source string) *HostsDB {
+	db := &HostsDB{Lists: hostsDBLists, Entries: make(map[string][]HostsDBEntry)}
+	added := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+	for _, h := range ht.HostList {
+		props := map[string]string{HostsDBSource: source, HostsDBAdded: added}
+		db.Entries["hosts.txt"] = append(db.Entries["hosts.txt"], HostsDBEntry{Host{Host: h.Host, Destination: h.Destination}, props})
+	}
+	return db
+}
+
+// Bytes returns the address book as a hostsdb.blockfile, with the reverse
+// index the Java router looks up destinations in.
+func (db *HostsDB) Bytes() ([]byte, error) {
+	w := newBlockWriter()
+	var names, pages [][]byte
+	index := func(name string) uint32 {
+		page := w.alloc()
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], page)
+		names = append(names, []byte(name))
+		pages = append(pages, b[:])
+		return page
+	}
+	now := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+	info, err := writeProperties(map[string]string{
+		"version":  hostsDBVersion,
+		"created":  now,
+		"upgraded": now,
+		"lists":    strings.Join(db.Lists, ","),
+	})
+	if err != nil {
+		return nil, err
+	}
+	w.skipList(index(hostsDBInfo), [][]byte{[]byte("info")}, [][]byte{info})
+
+	reverse := make(map[int32]map[string]string)
+	lists := make(map[string]uint32)
+	for _, list := range db.Lists {
+		lists[list] = index(list)
+	}
+	for _, list := range db.Lists {
+		entries := append([]HostsDBEntry(nil), db.Entries[list]...)
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Host.Host < entries[j].Host.Host
+		})
+		var keys, values [][]byte
+		for _, e := range entries {
+			addr, err := ParseDestination(e.Host.Destination)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", e.Host.Host, err)
+			}
+			dest, err := addr.ToBytes()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", e.Host.Host, err)
+			}
+			props, err := writeProperties(e.Props)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", e.Host.Host, err)
+			}
+			keys = append(keys, []byte(e.Host.Host))
+			values = append(values, append(props, dest...))
+			hash := sha256.Sum256(dest)
+			key := int32(binary.BigEndian.Uint32(hash[:]))
+			if reverse[key] == nil {
+				reverse[key] = make(map[string]string)
+			}
+			reverse[key][e.Host.Host] = ""
+		}
+		w.skipList(lists[list], keys, values)
+	}
+
+	var hashes []int32
+	for key := range reverse {
+		hashes = append(hashes, key)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+	var keys, values [][]byte
+	for _, key := range hashes {
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(key))
+		props, err := writeProperties(reverse[key])
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, b[:])
+		values = append(values, props)
+	}
+	w.skipList(index(hostsDBReverse), keys, values)
+
+	order := make([]int, len(names))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return string(names[order[i]]) < string(names[order[j]]) })
+	var sortedNames, sortedPages [][]byte
+	for _, i := range order {
+		sortedNames = append(sortedNames, names[i])
+		sortedPages = append(sortedPages, pages[i])
+	}
+	w.skipList(blockMetaIndexPage, sortedNames, sortedPages)
+	return w.Bytes(), nil
+}
+
+// writeProperties serializes props as I2P's Mapping: a two byte length,
+// then key=value; pairs of length-prefixed strings, sorted by key.
+func writeProperties(props map[string]string) ([]byte, error) {
+	var keys []string
+	for k := range props {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var mapping bytes.Buffer
+	for _, k := range keys {
+		for i, s := range []string{k, props[k]} {
+			if len(s) > 255 {
+				return nil, fmt.Errorf("property %s is longer than 255 bytes", k)
+			}
+			mapping.WriteByte(byte(len(s)))
+			mapping.WriteString(s)
+			mapping.WriteByte("=;"[i])
+		}
+	}
+	if mapping.Len() > 0xffff {
+		return nil, fmt.Errorf("properties are longer than %d bytes", 0xffff)
+	}
+	out := make([]byte, 2, 2+mapping.Len())
+	binary.BigEndian.PutUint16(out, uint16(mapping.Len()))
+	return append(out, mapping.Bytes()...), nil
+}
+
+// readProperties parses a Mapping from the start of b, returning it and the
+// bytes after it.
+func readProperties(b []byte) (map[string]string, []byte, error) {
+	if len(b) < 2 {
+		return nil, nil, fmt.Errorf("truncated properties")
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+n {
+		return nil, nil, fmt.Errorf("truncated properties")
+	}
+	mapping, rest := b[2:2+n], b[2+n:]
+	props := make(map[string]string)
+	for len(mapping) > 0 {
+		var pair [2]string
+		for i := range pair {
+			if len(mapping) < 1 || len(mapping) < 2+int(mapping[0]) || mapping[1+int(mapping[0])] != "=;"[i] {
+				return nil, nil, fmt.Errorf("malformed properties")
+			}
+			pair[i] = string(mapping[1 : 1+int(mapping[0])])
+			mapping = mapping[2+int(mapping[0]):]
+		}
+		props[pair[0]] = pair[1]
+	}
+	return props, rest, nil
+}
+
+// readDestination decodes the destination at the start of b, which ends
+// with a certificate whose length is in its header.
+func readDestination(b []byte) (string, error) {
+	if len(b) < destinationCertificateOffset+3 {
+		return "", fmt.Errorf("truncated destination")
+	}
+	size := destinationCertificateOffset + 3 + int(binary.BigEndian.Uint16(b[destinationCertificateOffset+1:]))
+	if len(b) < size {
+		return "", fmt.Errorf("truncated destination")
+	}
+	return I2PBase64.EncodeToString(b[:size]), nil
+}
@@ -0,0 +1,146 @@
+package jump
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHostsDB(t *testing.T) {
+	ht := newHostsTxt()
+	for i := 0; i < 40; i++ {
+		ht.Append(fmt.Sprintf("host%02d.i2p", i), newDest(t).Base64(), "")
+	}
+	db := NewHostsDB(ht, "http://example.i2p/hosts.txt")
+	private := newDest(t).Base64()
+	db.Entries["privatehosts.txt"] = []HostsDBEntry{
+		{Host{Host: "host07.i2p", Destination: private}, map[string]string{HostsDBSource: "user", "notes": strings.Repeat("n", 255)}},
+	}
+	data, err := db.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data)%blockPageSize != 0 {
+		t.Errorf("block file of %d bytes is not made of whole pages", len(data))
+	}
+	read, err := ReadHostsDB(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(read.Lists, ",") != "privatehosts.txt,userhosts.txt,hosts.txt" || len(read.Entries["hosts.txt"]) != 40 || len(read.Entries["userhosts.txt"]) != 0 {
+		t.Fatalf("unexpected lists after reading: %v", read.Lists)
+	}
+	entry := read.Entries["hosts.txt"][3]
+	if entry.Host.Host != "host03.i2p" || entry.Host.Destination != ht.HostList[3].Destination || entry.Source() != "http://example.i2p/hosts.txt" || time.Since(entry.Added()) > time.Minute {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+	if notes := read.Entries["privatehosts.txt"][0].Props["notes"]; len(notes) != 255 {
+		t.Errorf("long property was not kept: %q", notes)
+	}
+
+	merged := read.HostsTxt()
+	if got := merged.ToMap(); len(got) != 40 || got["host07.i2p"] != private || got["host08.i2p"] != ht.HostList[8].Destination {
+		t.Errorf("private hosts did not take precedence: %v", got["host07.i2p"])
+	}
+	imported, err := Import(bytes.NewReader(data), FormatBlockfile, nil, DefaultFeedLimits())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported.HostList) != 40 {
+		t.Errorf("imported %d hosts", len(imported.HostList))
+	}
+	if _, err := ReadHostsDB(ht.HostsFile()); err == nil {
+		t.Error("read a hosts file as a block file")
+	}
+	if _, err := ReadHostsDB(data[:len(data)-blockPageSize]); err == nil {
+		t.Error("read a truncated block file")
+	}
+
+	is, err := NewI2PServerFromTransport(uniqueName("blockfile"), writeHosts(t, "hosts.txt", nil), nil, NewTCPTransport("127.0.0.1:0", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	is.Me.Append("served.i2p", private, "")
+	rw := httptest.NewRecorder()
+	is.WebServer.ServeHTTP(rw, httptest.NewRequest("GET", "/hostsdb.blockfile", nil))
+	served, err := ReadHostsDB(rw.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := served.HostsTxt().ToMap(); len(got) != 1 || got["served.i2p"] != private {
+		t.Errorf("unexpected hosts in the served block file: %v", got)
+	}
+}
+
+// TestHostsDBSuperblock checks the layout the Java BlockFile expects against
+// its literal values, rather than against our own constants.
+func TestHostsDBSuperblock(t *testing.T) {
+	ht := newHostsTxt()
+	ht.Append("example.i2p", newDest(t).Base64(), "")
+	data, err := NewHostsDB(ht, "").Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if magic := string(data[:8]); magic != "1A\xdeI2P\x01\x02" {
+		t.Errorf("block file starts with %q", magic)
+	}
+	if size := binary.BigEndian.Uint64(data[8:]); size != uint64(len(data)) {
+		t.Errorf("superblock gives the file length as %d, not %d", size, len(data))
+	}
+	if span, page := binary.BigEndian.Uint16(data[22:]), binary.BigEndian.Uint32(data[24:]); span != 16 || page != 1024 {
+		t.Errorf("superblock gives a span size of %d and a page size of %d", span, page)
+	}
+	if metaindex := string(data[1024:1032]); metaindex != "SkipList" {
+		t.Errorf("page 2 holds %q instead of the metaindex skip list", metaindex)
+	}
+	metanotion := append([]byte("1A\xdeIBF\x01\x02"), data[8:]...)
+	if _, err := ReadHostsDB(metanotion); err == nil {
+		t.Error("read a block file with the original metanotion magic number")
+	}
+}
+
+// TestReadJavaHostsDB reads testdata/hostsdb.blockfile, the address book of
+// a Java router, and checks it against testdata/hostsdb.txt, the hosts.txt
+// the same router exported. To make them, export hosts.txt from the address
+// book in the console of a router which only holds the names of its
+// subscriptions, stop it and copy hostsdb.blockfile from its configuration
+// directory.
+func TestReadJavaHostsDB(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "hostsdb.blockfile"))
+	if os.IsNotExist(err) {
+		t.Skip("no block file written by a Java router in testdata")
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	exported, err := ioutil.ReadFile(filepath.Join("testdata", "hostsdb.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ReadHostsTxt(bytes.NewReader(exported), DefaultFeedLimits())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := ReadHostsDB(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lists := strings.Join(db.Lists, ","); !strings.HasSuffix(lists, "hosts.txt") {
+		t.Errorf("unexpected lists: %s", lists)
+	}
+	got := db.HostsTxt().ToMap()
+	for name, dest := range want.ToMap() {
+		if got[name] != dest {
+			t.Errorf("%s: read %q, exported %q", name, got[name], dest)
+		}
+	}
+	if len(got) != len(want.ToMap()) {
+		t.Errorf("read %d names, exported %d", len(got), len(want.ToMap()))
+	}
+}
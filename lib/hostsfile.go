@@ -1,41 +1,234 @@
 package jump
 
 import (
+	"bufio"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"log"
 	"os"
 	"strings"
+	"sync"
+
+	"i2pgit.org/idk/jump-transparency/lib/naming"
 )
 
 type Host struct {
 	Host        string
 	Destination string
 	Description string
+	// Props are the properties of a line in the extended format, nil for
+	// a plain line.
+	Props map[string]string
 }
 
 func (h *Host) String() string {
-	return h.Host + "=" + h.Destination + "\n"
+	return h.line() + "\n"
 }
 
+// HostsTxt is a parsed hosts file. It is not safe to modify one which other
+// goroutines are reading, an I2PJump instead replaces its HostsTxt with a
+// modified copy. A HostsTxt made as a literal is indexed on first use.
 type HostsTxt struct {
 	HostList []Host
+	// Rejected counts the lines which were left out when parsing, by the
+	// reason they were rejected.
+	Rejected map[string]int
+	// Unparsed holds the lines of a local hosts file which were rejected,
+	// so that writing the file back with LocalFile does not lose them.
+	Unparsed []string
 	hostMap  map[string]string
+	// index maps each hostname to its position in HostList.
+	index map[string]int
+	once  sync.Once
 }
 
-func (ht *HostsTxt) ToMap() map[string]string {
-	if len(ht.hostMap) != len(ht.HostList) {
-		for _, v := range ht.HostList {
-			ht.hostMap[v.Host] = v.Destination
+// FeedLimits bounds what is accepted from a peer's hosts file.
+type FeedLimits struct {
+	// MaxBodySize is the largest hosts file, in bytes, which is read at all.
+	MaxBodySize int64 `yaml:"max_body_size"`
+	// MaxLineLength is the longest line which is parsed, longer ones are
+	// rejected.
+	MaxLineLength int `yaml:"max_line_length"`
+	// MaxEntries is the most hosts which are taken from one file, the rest
+	// are rejected.
+	MaxEntries int `yaml:"max_entries"`
+}
+
+// DefaultFeedLimits returns the limits used when none are configured.
+func DefaultFeedLimits() FeedLimits {
+	return FeedLimits{
+		MaxBodySize:   8 << 20,
+		MaxLineLength: 4096,
+		MaxEntries:    100000,
+	}
+}
+
+// Validate checks that every limit is positive.
+func (l FeedLimits) Validate() error {
+	if l.MaxBodySize <= 0 || l.MaxLineLength <= 0 || l.MaxEntries <= 0 {
+		return fmt.Errorf("feed limits must be positive")
+	}
+	return nil
+}
+
+// Reasons lines of a hosts file are rejected for.
+const (
+	RejectLineTooLong        = "line too long"
+	RejectMalformed          = "malformed"
+	RejectInvalidHostname    = "invalid hostname"
+	RejectInvalidDestination = "invalid destination"
+	RejectDuplicate          = "duplicate"
+	RejectTooManyEntries     = "too many entries"
+)
+
+// RejectedLines returns how many lines were rejected in total.
+func (ht *HostsTxt) RejectedLines() int {
+	n := 0
+	for _, count := range ht.Rejected {
+		n += count
+	}
+	return n
+}
+
+func (ht *HostsTxt) reject(reason string) {
+	ht.init()
+	ht.Rejected[reason]++
+}
+
+// init builds the maps of a HostsTxt which was not made by newHostsTxt from
+// its HostList, keeping the first entry of each hostname.
+func (ht *HostsTxt) init() {
+	ht.once.Do(func() {
+		if ht.Rejected == nil {
+			ht.Rejected = make(map[string]int)
+		}
+		if ht.hostMap != nil {
+			return
+		}
+		ht.hostMap = make(map[string]string, len(ht.HostList))
+		ht.index = make(map[string]int, len(ht.HostList))
+		for i, h := range ht.HostList {
+			if _, ok := ht.hostMap[h.Host]; !ok {
+				ht.hostMap[h.Host] = h.Destination
+				ht.index[h.Host] = i
+			}
 		}
+	})
+}
+
+// parseLine adds the host on one line of a hosts file, or counts why it
+// could not and returns the reason. Blank lines and comments are skipped. The
+// properties of an extended line are kept, any other comment after the
+// destination is ignored.
+func (ht *HostsTxt) parseLine(line string, maxEntries int) string {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ""
+	}
+	reason := ""
+	h, err := ParseEntry(line)
+	switch {
+	case err != nil:
+		reason = RejectMalformed
+	case naming.Validate(h.Host) != nil:
+		reason = RejectInvalidHostname
+	case ValidateDestination(h.Destination) != nil:
+		reason = RejectInvalidDestination
+	case maxEntries > 0 && len(ht.HostList) >= maxEntries:
+		reason = RejectTooManyEntries
+	case !ht.AppendHost(h):
+		reason = RejectDuplicate
+	}
+	if reason != "" {
+		ht.reject(reason)
 	}
+	return reason
+}
+
+func (ht *HostsTxt) ToMap() map[string]string {
+	ht.init()
 	return ht.hostMap
 }
 
 func (ht *HostsTxt) Append(host, dest, desc string) bool {
-	if _, ok := ht.ToMap()[host]; !ok {
-		ht.HostList = append(ht.HostList, Host{Host: host, Destination: dest, Description: desc})
-		return true
+	return ht.AppendHost(Host{Host: host, Destination: dest, Description: desc})
+}
+
+// AppendHost adds h unless its hostname is already taken, reporting whether
+// it was added.
+func (ht *HostsTxt) AppendHost(h Host) bool {
+	ht.init()
+	if _, ok := ht.hostMap[h.Host]; ok {
+		return false
 	}
-	return false
+	ht.index[h.Host] = len(ht.HostList)
+	ht.HostList = append(ht.HostList, h)
+	ht.hostMap[h.Host] = h.Destination
+	return true
+}
+
+// Replace swaps the entry for h's hostname for h, reporting whether there
+// was one.
+func (ht *HostsTxt) Replace(h Host) bool {
+	ht.init()
+	i, ok := ht.index[h.Host]
+	if !ok {
+		return false
+	}
+	ht.HostList[i] = h
+	ht.hostMap[h.Host] = h.Destination
+	return true
+}
+
+// Lookup returns the entry for host.
+func (ht *HostsTxt) Lookup(host string) (Host, bool) {
+	ht.init()
+	i, ok := ht.index[host]
+	if !ok {
+		return Host{}, false
+	}
+	return ht.HostList[i], true
+}
+
+// Remove deletes a host, reporting whether it was there.
+func (ht *HostsTxt) Remove(host string) bool {
+	ht.init()
+	if _, ok := ht.hostMap[host]; !ok {
+		return false
+	}
+	i := ht.index[host]
+	ht.HostList = append(ht.HostList[:i:i], ht.HostList[i+1:]...)
+	delete(ht.hostMap, host)
+	delete(ht.index, host)
+	for ; i < len(ht.HostList); i++ {
+		ht.index[ht.HostList[i].Host] = i
+	}
+	return true
+}
+
+// Copy returns a copy of the hosts file which can be modified without
+// affecting the original.
+func (ht *HostsTxt) Copy() *HostsTxt {
+	ht.init()
+	c := &HostsTxt{
+		HostList: make([]Host, len(ht.HostList)),
+		Rejected: make(map[string]int, len(ht.Rejected)),
+		Unparsed: append([]string(nil), ht.Unparsed...),
+		hostMap:  make(map[string]string, len(ht.hostMap)),
+		index:    make(map[string]int, len(ht.index)),
+	}
+	copy(c.HostList, ht.HostList)
+	for k, v := range ht.hostMap {
+		c.hostMap[k] = v
+	}
+	for k, v := range ht.index {
+		c.index[k] = v
+	}
+	for k, v := range ht.Rejected {
+		c.Rejected[k] = v
+	}
+	return c
 }
 
 func ReadHostsFile(file string) ([]string, error) {
@@ -60,20 +253,78 @@ func (ht *HostsTxt) HostsFile() []byte {
 	return returnable
 }
 
+// LocalFile returns the hosts file as it is written back to disk: the hosts,
+// then the lines which were rejected when it was read, unchanged.
+func (ht *HostsTxt) LocalFile() []byte {
+	local := ht.HostsFile()
+	for _, line := range ht.Unparsed {
+		local = append(local, line+"\n"...)
+	}
+	return local
+}
+
+// NewHostsTxt reads a local hosts file. Its rejected lines are logged and
+// kept in Unparsed rather than dropped, since they are the operator's own.
 func NewHostsTxt(file string) (*HostsTxt, error) {
-	var ht HostsTxt
 	hosts, err := ReadHostsFile(file)
-	ht.hostMap = make(map[string]string)
 	if err != nil {
 		return nil, err
 	}
+	ht := ParseHostsTxt(hosts)
+	for _, line := range ht.Unparsed {
+		log.Printf("WARNING: %s: not serving invalid line, it is kept in the file: %s", file, line)
+	}
+	return ht, nil
+}
+
+func newHostsTxt() *HostsTxt {
+	return &HostsTxt{
+		Rejected: make(map[string]int),
+		hostMap:  make(map[string]string),
+		index:    make(map[string]int),
+	}
+}
+
+// ParseHostsTxt parses the lines of a hosts file. Where a hostname appears
+// more than once, the first entry is kept. Rejected lines are kept in
+// Unparsed.
+func ParseHostsTxt(hosts []string) *HostsTxt {
+	ht := newHostsTxt()
 	for _, v := range hosts {
-		spl := strings.SplitN(v, "=", 2)
-		if len(spl) == 2 {
-			if strings.HasSuffix(spl[0], ".i2p") {
-				ht.HostList = append(ht.HostList, Host{Host: spl[0], Destination: spl[1]})
+		if ht.parseLine(v, 0) != "" {
+			ht.Unparsed = append(ht.Unparsed, strings.TrimSpace(v))
+		}
+	}
+	return ht
+}
+
+// ReadHostsTxt parses a hosts file from r within limits. Lines which are too
+// long, malformed, or which name an invalid hostname or destination are
+// skipped and counted, as are entries beyond the maximum. A body larger than
+// the limit is an error.
+func ReadHostsTxt(r io.Reader, limits FeedLimits) (*HostsTxt, error) {
+	ht := newHostsTxt()
+	lr := &io.LimitedReader{R: r, N: limits.MaxBodySize + 1}
+	rd := bufio.NewReaderSize(lr, limits.MaxLineLength+1)
+	for {
+		line, isPrefix, err := rd.ReadLine()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if isPrefix {
+			for isPrefix && err == nil {
+				_, isPrefix, err = rd.ReadLine()
 			}
+			ht.reject(RejectLineTooLong)
+			continue
 		}
+		ht.parseLine(string(line), limits.MaxEntries)
+	}
+	if lr.N <= 0 {
+		return nil, fmt.Errorf("hosts file is larger than %d bytes", limits.MaxBodySize)
 	}
-	return &ht, nil
+	return ht, nil
 }
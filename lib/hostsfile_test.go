@@ -0,0 +1,166 @@
+package jump
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadHostsTxt(t *testing.T) {
+	a, b, c := newDest(t), newDest(t), newDest(t)
+	body := strings.Join([]string{
+		"# a comment",
+		"",
+		"good.i2p=" + a.Base64(),
+		"extended.i2p=" + b.Base64() + "#!date=1",
+		"good.i2p=" + b.Base64(),
+		"no-separator.i2p",
+		"under_score.i2p=" + a.Base64(),
+		"dotless=" + a.Base64(),
+		"bad..i2p=" + a.Base64(),
+		"short.i2p=" + a.Base64()[:100],
+		"notbase64.i2p=" + strings.Replace(a.Base64(), "A", "!", -1),
+		"long.i2p=" + a.Base64() + "#" + strings.Repeat("x", 2000),
+		"third.i2p=" + c.Base64(),
+		"fourth.i2p=" + c.Base64(),
+	}, "\r\n")
+	ht, err := ReadHostsTxt(strings.NewReader(body), FeedLimits{MaxBodySize: 1 << 20, MaxLineLength: 1024, MaxEntries: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts := ht.ToMap()
+	if len(hosts) != 3 || hosts["good.i2p"] != a.Base64() || hosts["extended.i2p"] != b.Base64() || hosts["third.i2p"] != c.Base64() {
+		t.Errorf("unexpected hosts: %v", hosts)
+	}
+	want := map[string]int{
+		RejectDuplicate:          1,
+		RejectMalformed:          1,
+		RejectInvalidHostname:    3,
+		RejectInvalidDestination: 2,
+		RejectLineTooLong:        1,
+		RejectTooManyEntries:     1,
+	}
+	for reason, n := range want {
+		if ht.Rejected[reason] != n {
+			t.Errorf("expected %d lines rejected as %s, got %v", n, reason, ht.Rejected)
+		}
+	}
+	if ht.RejectedLines() != 9 {
+		t.Errorf("expected 9 rejected lines, got %d", ht.RejectedLines())
+	}
+}
+
+func TestReadHostsTxtTooLarge(t *testing.T) {
+	body := strings.Repeat("# padding\n", 100)
+	limits := FeedLimits{MaxBodySize: int64(len(body)) - 1, MaxLineLength: 1024, MaxEntries: 10}
+	if _, err := ReadHostsTxt(strings.NewReader(body), limits); err == nil {
+		t.Error("hosts file over the size limit was accepted")
+	}
+	limits.MaxBodySize++
+	if _, err := ReadHostsTxt(strings.NewReader(body), limits); err != nil {
+		t.Errorf("hosts file at the size limit was refused: %s", err)
+	}
+}
+
+func TestFetchRejectedLines(t *testing.T) {
+	dest := newDest(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rq *http.Request) {
+		rw.Write([]byte("kept.i2p=" + dest.Base64() + "\ngarbage\n<html>=\n"))
+	}))
+	defer srv.Close()
+	cfg := DefaultConfig()
+	cfg.Name = uniqueName("rejecting")
+	cfg.HostsFile = writeHosts(t, "hosts.txt", nil)
+	cfg.Local = "127.0.0.1:0"
+	cfg.LocalOnly = true
+	cfg.RateLimits.RequestsPerSecond = 100
+	peer := uniqueName("sloppy")
+	cfg.Peers = []PeerConfig{{Name: peer, URL: srv.URL + "/hosts.txt"}}
+	is, err := NewI2PServerFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start(t, is)
+	waitFetched(t, is.Scheduler, peer)
+	if n := is.Peers()[0].RejectedLines(); n != 2 {
+		t.Errorf("expected 2 rejected lines, got %d", n)
+	}
+	if page := get(t, http.DefaultClient, "http://"+is.Base32()+"/"); !strings.Contains(page, "1 hosts, 2 lines rejected") {
+		t.Error("rejected lines are not shown on the home page")
+	}
+
+	cfg.Fetch.Limits.MaxBodySize = 10
+	if err := is.Reload(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := is.Peers()[0].Fetch(); err == nil {
+		t.Error("fetched a hosts file over the reloaded size limit")
+	}
+}
+
+func TestLocalHostsTxt(t *testing.T) {
+	a, b := newDest(t), newDest(t)
+	unpadded := strings.TrimRight(b.Base64(), "=")
+	if unpadded == b.Base64() {
+		t.Fatal("test destination has no padding to leave off")
+	}
+	lines := []string{
+		"good.i2p=" + a.Base64(),
+		"good.i2p=" + b.Base64(),
+		"unpadded.i2p=" + unpadded,
+		"garbage",
+	}
+	path := filepath.Join(t.TempDir(), "hosts.txt")
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ht, err := NewHostsTxt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := ht.Lookup("unpadded.i2p"); !ok || got.Destination != unpadded || !sameDestination(unpadded, b.Base64()) {
+		t.Errorf("unpadded destination was not accepted: %v", ht.ToMap())
+	}
+	if len(ht.Unparsed) != 2 || ht.Rejected[RejectDuplicate] != 1 || ht.Rejected[RejectMalformed] != 1 {
+		t.Errorf("unexpected rejected lines: %v %v", ht.Unparsed, ht.Rejected)
+	}
+	c := ht.Copy()
+	c.Remove("good.i2p")
+	c.reject(RejectMalformed)
+	if ht.Rejected[RejectMalformed] != 1 {
+		t.Error("copy shares the rejected counts of the original")
+	}
+	local := string(c.LocalFile())
+	for _, line := range lines[1:] {
+		if !strings.Contains(local, line+"\n") {
+			t.Errorf("rewritten hosts file lost %q:\n%s", line, local)
+		}
+	}
+	if strings.Contains(string(c.HostsFile()), "garbage") {
+		t.Error("invalid line is served")
+	}
+}
+
+func TestLiteralHostsTxt(t *testing.T) {
+	a, b := newDest(t), newDest(t)
+	ht := &HostsTxt{HostList: []Host{{Host: "a.i2p", Destination: a.Base64()}, {Host: "b.i2p", Destination: b.Base64()}}}
+	if ht.ToMap()["b.i2p"] != b.Base64() {
+		t.Errorf("literal hosts file has no map: %v", ht.ToMap())
+	}
+	if h, ok := ht.Lookup("a.i2p"); !ok || h.Destination != a.Base64() {
+		t.Errorf("lookup in a literal hosts file: %+v", h)
+	}
+	if ht.Append("a.i2p", b.Base64(), "") {
+		t.Error("duplicate was appended to a literal hosts file")
+	}
+	c := ht.Copy()
+	if !c.Remove("a.i2p") || len(c.HostList) != 1 || len(ht.HostList) != 2 {
+		t.Errorf("removing from a copy of a literal hosts file: %v %v", c.HostList, ht.HostList)
+	}
+	if h, ok := c.Lookup("b.i2p"); !ok || h.Host != "b.i2p" {
+		t.Errorf("copy was not reindexed: %+v", h)
+	}
+}
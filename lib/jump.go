@@ -1,20 +1,126 @@
 package jump
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
 	"net/url"
-	"strings"
-
-	"github.com/eyedeekay/sam3/helper"
+	"sync"
+	"sync/atomic"
 )
 
+// I2PJump is a hosts file belonging to this service or mirrored from a peer.
+// The parsed hosts are published as an immutable snapshot which is swapped
+// whenever they change, so readers never need to lock.
 type I2PJump struct {
-	*HostsTxt
-	SAMAddr string
-	Name    string
-	MyURL   *url.URL
+	hosts     atomic.Value
+	limits    atomic.Value
+	signers   atomic.Value
+	pin       atomic.Value
+	alert     atomic.Value
+	appending sync.Mutex
+	SAMAddr   string
+	Name      string
+	MyURL     *url.URL
+	Transport Transport
+	// OnUpdate, if set, is called with the previous and the new snapshot
+	// after every successful fetch.
+	OnUpdate func(previous, current *HostsTxt)
+}
+
+// Hosts returns the current snapshot of the hosts file. It must not be
+// modified.
+func (j *I2PJump) Hosts() *HostsTxt {
+	return j.hosts.Load().(*HostsTxt)
+}
+
+// Limits returns the limits applied to the peer's hosts file when it is
+// fetched.
+func (j *I2PJump) Limits() FeedLimits {
+	return j.limits.Load().(FeedLimits)
+}
+
+// SetLimits changes the limits applied to the next fetch.
+func (j *I2PJump) SetLimits(limits FeedLimits) {
+	j.limits.Store(limits)
+}
+
+// Signers returns the SU3 signers pinned for the peer, by signer ID. When
+// there are any, the peer's feed is only accepted as an SU3 file signed by
+// one of them.
+func (j *I2PJump) Signers() map[string]crypto.PublicKey {
+	signers, _ := j.signers.Load().(map[string]crypto.PublicKey)
+	return signers
+}
+
+// SetSigners pins the SU3 signers the next fetch is verified with.
+func (j *I2PJump) SetSigners(signers map[string]crypto.PublicKey) {
+	j.signers.Store(signers)
+}
+
+// RejectedLines returns how many lines of the hosts file were rejected when
+// it was last parsed.
+func (j *I2PJump) RejectedLines() int {
+	return j.Hosts().RejectedLines()
+}
+
+func (j *I2PJump) ToMap() map[string]string {
+	return j.Hosts().ToMap()
+}
+
+func (j *I2PJump) HostsFile() []byte {
+	return j.Hosts().HostsFile()
+}
+
+// Append adds a host unless the hostname is already taken, reporting whether
+// it was added.
+func (j *I2PJump) Append(host, dest, desc string) bool {
+	return j.AppendHost(Host{Host: host, Destination: dest, Description: desc})
+}
+
+// AppendHost adds h unless its hostname is already taken, reporting whether
+// it was added.
+func (j *I2PJump) AppendHost(h Host) bool {
+	j.appending.Lock()
+	defer j.appending.Unlock()
+	ht := j.Hosts().Copy()
+	if !ht.AppendHost(h) {
+		return false
+	}
+	j.hosts.Store(ht)
+	return true
+}
+
+// Replace swaps the entry for h's hostname for h, reporting whether there
+// was one.
+func (j *I2PJump) Replace(h Host) bool {
+	j.appending.Lock()
+	defer j.appending.Unlock()
+	ht := j.Hosts().Copy()
+	if !ht.Replace(h) {
+		return false
+	}
+	j.hosts.Store(ht)
+	return true
+}
+
+// Remove deletes a host, reporting whether it was there.
+func (j *I2PJump) Remove(host string) bool {
+	j.appending.Lock()
+	defer j.appending.Unlock()
+	ht := j.Hosts().Copy()
+	if !ht.Remove(host) {
+		return false
+	}
+	j.hosts.Store(ht)
+	return true
 }
 
 func NewI2PJump(hostFile, samAddr, name, jumpUrl string) (*I2PJump, error) {
@@ -22,10 +128,13 @@ func NewI2PJump(hostFile, samAddr, name, jumpUrl string) (*I2PJump, error) {
 	var e error
 	j.SAMAddr = samAddr
 	j.Name = name
-	j.HostsTxt, e = NewHostsTxt(hostFile)
+	j.Transport = NewSAMTransport(name, samAddr, "")
+	ht, e := NewHostsTxt(hostFile)
 	if e != nil {
 		return nil, e
 	}
+	j.hosts.Store(ht)
+	j.limits.Store(DefaultFeedLimits())
 	j.MyURL, e = url.Parse(jumpUrl)
 	if e != nil {
 		return nil, e
@@ -37,43 +146,105 @@ var literal = `
 `
 
 func (j *I2PJump) Fetch() error {
-	session, err := sam.I2PStreamSession(j.Name, j.SAMAddr, "sam-"+j.Name+"-client")
-	if err != nil {
-		return err
-	}
-	log.Printf("looking up: %s", j.MyURL.Host)
-	hostname, err := session.Lookup(j.MyURL.Host)
+	return j.FetchContext(context.Background())
+}
+
+// FetchContext downloads and stores the peer's hosts file, giving up when ctx
+// is cancelled or its deadline passes, whether dialing or reading.
+func (j *I2PJump) FetchContext(ctx context.Context) error {
+	host, err := j.pinnedHost()
 	if err != nil {
 		return err
 	}
-	log.Printf("DIALING: %s", hostname.Base32())
-	conn, err := session.DialI2P(hostname)
+	log.Printf("DIALING: %s", host)
+	conn, err := j.Transport.Dial(ctx, host)
 	if err != nil {
 		return err
 	}
 	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
 	log.Printf("GETTING: %s", j.MyURL.String())
 	fmt.Fprintf(conn, "GET "+j.MyURL.Path+" HTTP/1.0\r\n\r\n")
-	bytes, err := ioutil.ReadAll(conn)
+	ht, err := j.readResponse(conn)
+	if ctx.Err() != nil {
+		return fmt.Errorf("fetching %s: %s", j.Name, ctx.Err())
+	}
 	if err != nil {
 		return err
 	}
-	if strings.Contains(string(bytes), "404 Not Found") {
-		return fmt.Errorf("Fetch error 404", j.Name)
+	if n := ht.RejectedLines(); n > 0 {
+		log.Printf("Rejected %d lines from %s: %v", n, j.Name, ht.Rejected)
 	}
 	log.Printf("WRITING: %s", "peer-"+j.Name+"-hosts.txt")
-	err = ioutil.WriteFile("peer-"+j.Name+"-hosts.txt", bytes, 0644)
+	err = ioutil.WriteFile("peer-"+j.Name+"-hosts.txt", ht.HostsFile(), 0644)
 	if err != nil {
 		return err
 	}
-	log.Printf("LOADING: %s", "peer-"+j.Name+"-hosts.txt")
-	j.HostsTxt, err = NewHostsTxt("peer-" + j.Name + "-hosts.txt")
+	previous := j.Hosts()
+	j.hosts.Store(ht)
+	j.alert.Store(TrustAlert{})
+	if j.OnUpdate != nil {
+		j.OnUpdate(previous, ht)
+	}
+	return nil
+}
+
+// readResponse parses the HTTP response to a fetch, and the hosts file in its
+// body within the peer's limits.
+func (j *I2PJump) readResponse(conn net.Conn) (*HostsTxt, error) {
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	err = ioutil.WriteFile("peer-"+j.Name+"-hosts.txt", j.HostsTxt.HostsFile(), 0644)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Fetch error %d: %s", resp.StatusCode, j.Name)
+	}
+	limits := j.Limits()
+	body := bufio.NewReader(resp.Body)
+	magic, _ := body.Peek(len(su3Magic))
+	if string(magic) != su3Magic {
+		if len(j.Signers()) > 0 {
+			return nil, fmt.Errorf("fetching %s: the feed is not signed by a pinned SU3 signer", j.Name)
+		}
+		ht, err := ReadHostsTxt(body, limits)
+		if err != nil {
+			return nil, fmt.Errorf("fetching %s: %s", j.Name, err)
+		}
+		return ht, nil
+	}
+	content, err := j.verifySU3(body, limits)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("fetching %s: %s", j.Name, err)
 	}
-	return nil
+	return ReadHostsTxt(bytes.NewReader(content), limits)
+}
+
+// verifySU3 reads an SU3 feed within limits, verifies it against the pinned
+// signers and returns the hosts file it holds.
+func (j *I2PJump) verifySU3(r io.Reader, limits FeedLimits) ([]byte, error) {
+	lr := &io.LimitedReader{R: r, N: limits.MaxBodySize + 1}
+	data, err := ioutil.ReadAll(lr)
+	if err != nil {
+		return nil, err
+	}
+	if lr.N <= 0 {
+		return nil, fmt.Errorf("SU3 file is larger than %d bytes", limits.MaxBodySize)
+	}
+	f, err := ParseSU3(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Verify(j.Signers()); err != nil {
+		return nil, err
+	}
+	return f.Data(limits.MaxBodySize)
 }
@@ -0,0 +1,246 @@
+package jump
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/eyedeekay/sam3/helper"
+	"github.com/eyedeekay/sam3/i2pkeys"
+	"i2pgit.org/idk/jump-transparency/lib/samtest"
+)
+
+// bridge is shared by every test, since the fake SAM bridge must outlive any
+// goroutine still talking to it. It listens on $SAMTEST_ADDR if that is set,
+// on the default SAM port if that is free, and on any free port otherwise.
+var bridge *samtest.Bridge
+
+// startBridge starts the fake SAM bridge where the tests want it.
+func startBridge() (*samtest.Bridge, error) {
+	if addr := os.Getenv("SAMTEST_ADDR"); addr != "" {
+		return samtest.NewBridgeAt(addr)
+	}
+	b, err := samtest.NewBridge()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s is taken, is a router running? Tests which accept streams will be skipped: %s\n", samtest.DefaultAddr, err)
+		return samtest.NewBridgeAt("127.0.0.1:0")
+	}
+	return b, nil
+}
+
+// needAccept skips tests which accept streams unless the bridge is on the
+// default SAM port, because sam3 reconnects there to accept whatever address
+// the session was made with.
+func needAccept(t *testing.T) {
+	if bridge.Addr() != samtest.DefaultAddr {
+		t.Skipf("accepting streams needs the fake SAM bridge on %s, it is on %s", samtest.DefaultAddr, bridge.Addr())
+	}
+}
+
+// TestMain runs the tests from inside a scratch directory, because fetching,
+// the trust chart and the SAM helpers all write files to the working
+// directory.
+func TestMain(m *testing.M) {
+	var err error
+	bridge, err = startBridge()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to start fake SAM bridge: %s\n", err)
+		os.Exit(1)
+	}
+	dir, err := ioutil.TempDir("", "jump-test")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+var sessionCounter int32
+
+// uniqueName returns a tunnel name which no other test has used.
+func uniqueName(prefix string) string {
+	return fmt.Sprintf("%s-%d", prefix, atomic.AddInt32(&sessionCounter, 1))
+}
+
+func newDest(t *testing.T) i2pkeys.I2PAddr {
+	keys, err := bridge.NewKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys.Addr()
+}
+
+// servePeer serves body as /hosts.txt from a new destination which the bridge
+// resolves as name.i2p.
+func servePeer(t *testing.T, name, body string) i2pkeys.I2PAddr {
+	needAccept(t)
+	l, err := sam.I2PListener(name+"-server", bridge.Addr(), filepath.Join(t.TempDir(), name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hosts.txt", func(rw http.ResponseWriter, rq *http.Request) {
+		rw.Write([]byte(body))
+	})
+	go http.Serve(l, mux)
+	addr := l.Addr().(i2pkeys.I2PAddr)
+	bridge.AddName(name+".i2p", addr)
+	return addr
+}
+
+// newClient returns an HTTP client which dials out through its own session on
+// the bridge.
+func newClient(t *testing.T) *http.Client {
+	session, err := sam.I2PStreamSession(uniqueName("client"), bridge.Addr(), filepath.Join(t.TempDir(), "client"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { session.Close() })
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{
+		Jar:       jar,
+		Transport: &http.Transport{Dial: session.Dial},
+	}
+}
+
+func TestFetch(t *testing.T) {
+	alpha, beta := newDest(t), newDest(t)
+	peer := uniqueName("fetchpeer")
+	servePeer(t, peer, "alpha.i2p="+alpha.Base64()+"\nbeta.i2p="+beta.Base64()+"\nnot-a-host=garbage\n")
+	j, err := NewI2PJump("", bridge.Addr(), peer, "http://"+peer+".i2p/hosts.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Fetch(); err != nil {
+		t.Fatal(err)
+	}
+	hosts := j.ToMap()
+	if len(hosts) != 2 {
+		t.Fatalf("expected 2 hosts, got %d: %v", len(hosts), hosts)
+	}
+	if hosts["alpha.i2p"] != alpha.Base64() || hosts["beta.i2p"] != beta.Base64() {
+		t.Errorf("fetched hosts do not match the peer: %v", hosts)
+	}
+	mirror, err := ioutil.ReadFile("peer-" + peer + "-hosts.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(mirror) != string(j.HostsFile()) {
+		t.Errorf("mirrored hosts file differs from the parsed hosts")
+	}
+}
+
+func TestFetchNotFound(t *testing.T) {
+	peer := uniqueName("missingpeer")
+	servePeer(t, peer, "")
+	j, err := NewI2PJump("", bridge.Addr(), peer, "http://"+peer+".i2p/nothing-here.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Fetch(); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("expected a 404 error, got %v", err)
+	}
+}
+
+func TestFetchUnknownPeer(t *testing.T) {
+	j, err := NewI2PJump("", bridge.Addr(), uniqueName("nopeer"), "http://nopeer.i2p/hosts.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Fetch(); err == nil {
+		t.Fatal("expected fetching an unresolvable peer to fail")
+	}
+}
+
+func TestFetchPinned(t *testing.T) {
+	alpha := newDest(t)
+	peer := uniqueName("pinnedpeer")
+	addr := servePeer(t, peer, "alpha.i2p="+alpha.Base64()+"\n")
+	for _, pin := range []string{addr.Base64(), strings.ToUpper(addr.Base32())} {
+		j, err := NewI2PJump("", bridge.Addr(), peer, "http://"+peer+".i2p/hosts.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		j.SetPinned(pin)
+		err = j.Fetch()
+		j.Transport.Close()
+		if err != nil {
+			t.Fatalf("fetching a peer pinned to its own destination: %s", err)
+		}
+		if _, alerted := j.TrustAlert(); alerted || j.ToMap()["alpha.i2p"] != alpha.Base64() {
+			t.Errorf("unexpected result of a pinned fetch: %v", j.ToMap())
+		}
+	}
+
+	is, err := NewI2PServerFromTransport(uniqueName("pinning"), writeHosts(t, "hosts.txt", nil), nil, NewSAMTransport(uniqueName("pinning"), bridge.Addr(), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := is.UpdatePeers([]PeerConfig{{Name: peer, URL: "http://" + peer + ".i2p/hosts.txt", Destination: addr.Base32()}}); err != nil {
+		t.Fatal(err)
+	}
+	poisoned := newDest(t)
+	bridge.AddName(peer+".i2p", poisoned)
+	err = is.Peers()[0].Fetch()
+	if err == nil || !strings.Contains(err.Error(), poisoned.Base32()) {
+		t.Fatalf("fetching a peer whose name resolves elsewhere: %v", err)
+	}
+	if alert, ok := is.Peers()[0].TrustAlert(); !ok || alert.Peer != peer {
+		t.Errorf("no trust alert was raised: %+v", alert)
+	}
+	if page := adminRequest(is, "GET", "/admin", nil).Body.String(); !strings.Contains(page, "Peer "+peer+", ") {
+		t.Errorf("moderation view does not show the trust alert: %s", page)
+	}
+
+	bridge.AddName(peer+".i2p", addr)
+	if err := is.Peers()[0].Fetch(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := is.Peers()[0].TrustAlert(); ok {
+		t.Error("trust alert was not cleared by a fetch from the pinned destination")
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rq *http.Request) {
+		rw.Write([]byte("alpha.i2p=" + alpha.Base64() + "\n"))
+	}))
+	defer srv.Close()
+	local, err := NewI2PJump("", "", uniqueName("localpinned"), "http://localpinned.i2p/hosts.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local.Transport = NewTCPTransport("", map[string]string{addr.Base32(): srv.Listener.Addr().String()})
+	local.SetPinned(addr.Base32())
+	if err := local.Fetch(); err != nil || local.ToMap()["alpha.i2p"] != alpha.Base64() {
+		t.Errorf("fetching a pinned peer over TCP: %v", err)
+	}
+	// A transport which does not say that it cannot resolve I2P names has
+	// the pin checked against its lookup.
+	local.Transport = struct{ Transport }{NewTCPTransport("", map[string]string{
+		"localpinned.i2p": srv.Listener.Addr().String(),
+		addr.Base32():     srv.Listener.Addr().String(),
+	})}
+	if err := local.Fetch(); err == nil {
+		t.Error("pin was not checked on a transport which may resolve I2P names")
+	}
+	if _, ok := local.TrustAlert(); !ok {
+		t.Error("no trust alert was raised for a lookup which is not a destination")
+	}
+	if _, err := pinnedBase32(strings.Repeat("1", 52) + ".b32.i2p"); err == nil {
+		t.Error("pin outside the Base32 alphabet was accepted")
+	}
+}
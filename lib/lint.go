@@ -0,0 +1,92 @@
+package jump
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"i2pgit.org/idk/jump-transparency/lib/naming"
+)
+
+// LintProblem is a line of a hosts file which would be rejected, and why.
+type LintProblem struct {
+	Line   int
+	Reason string
+	Text   string
+}
+
+func (p LintProblem) String() string {
+	return fmt.Sprintf("line %d: %s: %s", p.Line, p.Reason, p.Text)
+}
+
+// LintHostsTxt reads a hosts file from r within limits and returns every line
+// ReadHostsTxt would leave out, by line number.
+func LintHostsTxt(r io.Reader, limits FeedLimits) ([]LintProblem, error) {
+	ht := newHostsTxt()
+	var problems []LintProblem
+	lr := &io.LimitedReader{R: r, N: limits.MaxBodySize + 1}
+	rd := bufio.NewReaderSize(lr, limits.MaxLineLength+1)
+	for n := 1; ; n++ {
+		line, isPrefix, err := rd.ReadLine()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if isPrefix {
+			text := string(line)
+			if len(text) > 40 {
+				text = text[:40] + "..."
+			}
+			problems = append(problems, LintProblem{n, RejectLineTooLong, text})
+			for isPrefix && err == nil {
+				_, isPrefix, err = rd.ReadLine()
+			}
+			continue
+		}
+		if reason := ht.parseLine(string(line), limits.MaxEntries); reason != "" {
+			problems = append(problems, LintProblem{n, reason, strings.TrimSpace(string(line))})
+		}
+	}
+	if lr.N <= 0 {
+		return nil, fmt.Errorf("hosts file is larger than %d bytes", limits.MaxBodySize)
+	}
+	return problems, nil
+}
+
+// SignatureCheck is the outcome of verifying the signatures of a signed
+// entry.
+type SignatureCheck struct {
+	Host Host
+	Err  error
+}
+
+// VerifySignatures checks every signed entry of the hosts file: the entry's
+// own signature, and for changes of destination and subdomains the signature
+// of the old or parent key as well. A subdomain whose parent is in the file
+// must have been authorized by the parent's destination there. Unsigned
+// entries are skipped.
+func (ht *HostsTxt) VerifySignatures() []SignatureCheck {
+	var checks []SignatureCheck
+	for _, h := range ht.HostList {
+		if _, ok := h.Props[PropSig]; !ok {
+			continue
+		}
+		var err error
+		parent := naming.Parent(h.Host)
+		parentDest, hasParent := ht.ToMap()[parent]
+		_, oldsig := h.Props[PropOldSig]
+		switch {
+		case strings.EqualFold(h.Props[PropAction], ActionAddSubdomain) && hasParent:
+			err = h.VerifyParent(parent, parentDest)
+		case oldsig:
+			err = h.verifyOldSig()
+		default:
+			err = h.VerifySignature()
+		}
+		checks = append(checks, SignatureCheck{h, err})
+	}
+	return checks
+}
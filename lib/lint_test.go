@@ -0,0 +1,60 @@
+package jump
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	one, two := newDest(t), newDest(t)
+	hosts := strings.Join([]string{
+		"# comment",
+		"one.i2p=" + one.Base64(),
+		"one.i2p=" + two.Base64(),
+		"bad_name.i2p=" + two.Base64(),
+		"two.i2p=notadestination",
+		"nonsense",
+		"",
+	}, "\n")
+	problems, err := LintHostsTxt(strings.NewReader(hosts), DefaultFeedLimits())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []LintProblem{
+		{3, RejectDuplicate, "one.i2p=" + two.Base64()},
+		{4, RejectInvalidHostname, "bad_name.i2p=" + two.Base64()},
+		{5, RejectInvalidDestination, "two.i2p=notadestination"},
+		{6, RejectMalformed, "nonsense"},
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("got problems %v, want %v", problems, want)
+	}
+	for i := range want {
+		if problems[i] != want[i] {
+			t.Errorf("got problem %v, want %v", problems[i], want[i])
+		}
+	}
+
+	parent, sub, moved := newDest(t), newDest(t), newDest(t)
+	forged := signSubdomain(t, "forged.parent.i2p", sub, "parent.i2p", newDest(t))
+	ht := newHostsTxt()
+	ht.Append("parent.i2p", parent.Base64(), "")
+	ht.Append("plain.i2p", one.Base64(), "")
+	ht.AppendHost(signSubdomain(t, "sub.parent.i2p", sub, "parent.i2p", parent))
+	ht.AppendHost(forged)
+	ht.AppendHost(signChangeDest(t, "moved.i2p", two, moved, two))
+	tampered := signChangeDest(t, "tampered.i2p", one, moved, one)
+	tampered.Props[PropDate] = "1700000001"
+	ht.AppendHost(tampered)
+	failed := make(map[string]bool)
+	checks := ht.VerifySignatures()
+	for _, c := range checks {
+		failed[c.Host.Host] = c.Err != nil
+	}
+	if len(checks) != 4 {
+		t.Errorf("checked %d signed entries, want 4", len(checks))
+	}
+	if failed["sub.parent.i2p"] || failed["moved.i2p"] || !failed["forged.parent.i2p"] || !failed["tampered.i2p"] {
+		t.Errorf("unexpected verification failures: %v", failed)
+	}
+}
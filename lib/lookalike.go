@@ -0,0 +1,254 @@
+package jump
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+
+	"i2pgit.org/idk/jump-transparency/lib/naming"
+)
+
+// Reasons LookalikeReason gives for two names being alike.
+const (
+	LookalikeConfusable = "confusable characters"
+	LookalikeHyphens    = "added or removed hyphens"
+	LookalikeTypo       = "typo"
+)
+
+// maxLookalikeChecks bounds how many new names from one fetch are compared
+// against the known hosts, so that a peer replacing its whole hosts file does
+// not stall the fetch.
+const maxLookalikeChecks = 500
+
+// Lookalike is a hostname which could be mistaken for another known one,
+// and so may be a phishing name.
+type Lookalike struct {
+	Name    string
+	Similar string
+	Reason  string
+	// Source is the peer whose hosts file the name appeared in, or "" for
+	// a registration.
+	Source string
+}
+
+func (l Lookalike) String() string {
+	return fmt.Sprintf("%s looks like %s (%s)", naming.Display(l.Name), naming.Display(l.Similar), l.Reason)
+}
+
+// maxEdits is how many edits apart two names may be for one to be taken for a
+// typo of the other. Short names are left alone, since most of them are only
+// a letter or two away from some other name.
+func maxEdits(length int) int {
+	switch {
+	case length < 5:
+		return 0
+	case length < 10:
+		return 1
+	}
+	return 2
+}
+
+// LookalikeReason reports whether name could be mistaken for other, and how:
+// by sharing a skeleton, by differing only in hyphens, or by being within a
+// typo or two of it, as i2p-projekt.i2p is of i2p-project.i2p. It returns ""
+// for names which are the same or do not look alike.
+func LookalikeReason(name, other string) string {
+	if name == other {
+		return ""
+	}
+	a, b := naming.Skeleton(name), naming.Skeleton(other)
+	if a == b {
+		return LookalikeConfusable
+	}
+	if strings.Replace(name, "-", "", -1) == strings.Replace(other, "-", "", -1) {
+		return LookalikeHyphens
+	}
+	ar, br := []rune(strings.TrimSuffix(a, naming.Suffix)), []rune(strings.TrimSuffix(b, naming.Suffix))
+	shorter := len(ar)
+	if len(br) < shorter {
+		shorter = len(br)
+	}
+	limit := maxEdits(shorter)
+	if limit == 0 || len(ar)-len(br) > limit || len(br)-len(ar) > limit {
+		return ""
+	}
+	if editDistance(ar, br) <= limit {
+		return LookalikeTypo
+	}
+	return ""
+}
+
+// editDistance is the optimal string alignment distance between a and b: the
+// number of insertions, deletions, substitutions and transpositions of
+// adjacent characters which turn one into the other.
+func editDistance(a, b []rune) int {
+	rows := make([][]int, len(a)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(b)+1)
+		rows[i][0] = i
+	}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d := rows[i-1][j] + 1
+			if rows[i][j-1]+1 < d {
+				d = rows[i][j-1] + 1
+			}
+			if rows[i-1][j-1]+cost < d {
+				d = rows[i-1][j-1] + cost
+			}
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] && rows[i-2][j-2]+1 < d {
+				d = rows[i-2][j-2] + 1
+			}
+			rows[i][j] = d
+		}
+	}
+	return rows[len(a)][len(b)]
+}
+
+// knownNames returns every hostname in our own hosts file and in the hosts
+// files of the peers.
+func (ws *WebServer) knownNames() map[string]bool {
+	known := make(map[string]bool)
+	for name := range ws.Me.ToMap() {
+		known[name] = true
+	}
+	for _, peer := range ws.Peers() {
+		for name := range peer.ToMap() {
+			known[name] = true
+		}
+	}
+	return known
+}
+
+// findLookalikes compares name against known, which must not be modified
+// while it runs.
+func findLookalikes(name, source string, known map[string]bool) []Lookalike {
+	var found []Lookalike
+	for other := range known {
+		if reason := LookalikeReason(name, other); reason != "" {
+			found = append(found, Lookalike{Name: name, Similar: other, Reason: reason, Source: source})
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].Similar < found[j].Similar
+	})
+	return found
+}
+
+// LookalikesOf returns the known hosts which name could be mistaken for.
+func (ws *WebServer) LookalikesOf(name string) []Lookalike {
+	return findLookalikes(name, "", ws.knownNames())
+}
+
+// Lookalikes returns the names from peer feeds which were found to look like
+// another known host when they first appeared.
+func (ws *WebServer) Lookalikes() map[string][]Lookalike {
+	ws.mutex.RLock()
+	defer ws.mutex.RUnlock()
+	lookalikes := make(map[string][]Lookalike, len(ws.lookalikes))
+	for name, found := range ws.lookalikes {
+		lookalikes[name] = found
+	}
+	return lookalikes
+}
+
+// checkFeed returns a function which compares the names new to a peer's
+// hosts file against the known hosts after each fetch, and records the
+// lookalikes. The first fetch from a peer only sets the baseline.
+func (ws *WebServer) checkFeed(peer string) func(previous, current *HostsTxt) {
+	return func(previous, current *HostsTxt) {
+		var fresh []string
+		if len(previous.HostList) > 0 {
+			for _, h := range current.HostList {
+				if _, ok := previous.ToMap()[h.Host]; !ok {
+					fresh = append(fresh, h.Host)
+				}
+			}
+		}
+		if len(fresh) > maxLookalikeChecks {
+			log.Printf("%s: %d new names, checking the first %d for lookalikes", peer, len(fresh), maxLookalikeChecks)
+			fresh = fresh[:maxLookalikeChecks]
+		}
+		found := make(map[string][]Lookalike)
+		if len(fresh) > 0 {
+			known := ws.knownNames()
+			for _, name := range fresh {
+				if lookalikes := findLookalikes(name, peer, known); len(lookalikes) > 0 {
+					log.Printf("%s: new name %s", peer, lookalikes[0])
+					found[name] = lookalikes
+				}
+			}
+		}
+		ws.mutex.Lock()
+		defer ws.mutex.Unlock()
+		for name, lookalikes := range ws.lookalikes {
+			if _, ok := current.ToMap()[name]; !ok && lookalikes[0].Source == peer {
+				delete(ws.lookalikes, name)
+			}
+		}
+		for name, lookalikes := range found {
+			ws.lookalikes[name] = lookalikes
+		}
+		if err := WriteLookalikes(ws.lookalikesFile(), ws.lookalikes); err != nil {
+			log.Printf("saving lookalikes: %s", err)
+		}
+	}
+}
+
+func (ws *WebServer) lookalikesFile() string {
+	return ws.Me.Name + "-lookalikes.txt"
+}
+
+// WriteLookalikes saves the lookalikes found in peer feeds to path, one per
+// line, so that they survive a restart and the trust report can be made
+// offline with them.
+func WriteLookalikes(path string, lookalikes map[string][]Lookalike) error {
+	var lines []string
+	for _, found := range lookalikes {
+		for _, l := range found {
+			lines = append(lines, strings.Join([]string{l.Name, l.Similar, url.QueryEscape(l.Source), url.QueryEscape(l.Reason)}, " "))
+		}
+	}
+	sort.Strings(lines)
+	var data []byte
+	for _, line := range lines {
+		data = append(data, line+"\n"...)
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// LoadLookalikes reads back the lookalikes saved by WriteLookalikes. A
+// missing file holds none.
+func LoadLookalikes(path string) (map[string][]Lookalike, error) {
+	lines, err := ReadHostsFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lookalikes := make(map[string][]Lookalike)
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 4 {
+			continue
+		}
+		source, err := url.QueryUnescape(fields[2])
+		if err != nil {
+			continue
+		}
+		reason, err := url.QueryUnescape(fields[3])
+		if err != nil {
+			continue
+		}
+		lookalikes[fields[0]] = append(lookalikes[fields[0]], Lookalike{Name: fields[0], Similar: fields[1], Reason: reason, Source: source})
+	}
+	return lookalikes, nil
+}
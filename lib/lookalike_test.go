@@ -0,0 +1,38 @@
+package jump
+
+import (
+	"strings"
+	"testing"
+
+	"i2pgit.org/idk/jump-transparency/lib/naming"
+)
+
+func TestLookalikeReason(t *testing.T) {
+	for _, c := range []struct {
+		name, other, want string
+	}{
+		{"i2p-projekt.i2p", "i2p-project.i2p", LookalikeTypo},
+		{"stats.i2p", "stast.i2p", LookalikeTypo},
+		{"identiguy.i2p", "identiguyy.i2p", LookalikeTypo},
+		{"notbob.i2p", "not-bob.i2p", LookalikeHyphens},
+		{"paypal.i2p", naming.Normalize("раураl.i2p"), LookalikeConfusable},
+		{"forum.i2p", "forum.i2p", ""},
+		{"zzz.i2p", "zzy.i2p", ""},
+		{"identiguy.i2p", "echelon.i2p", ""},
+		{"planet.i2p", "plants.i2p", ""},
+	} {
+		if got := LookalikeReason(c.name, c.other); got != c.want {
+			t.Errorf("LookalikeReason(%q, %q) = %q, want %q", c.name, c.other, got, c.want)
+		}
+	}
+}
+
+func TestLookalikeSourceEscaped(t *testing.T) {
+	tc := &TrustChecker{Lookalikes: map[string][]Lookalike{
+		"stast.i2p": {{Name: "stast.i2p", Similar: "stats.i2p", Reason: LookalikeTypo, Source: "<script>peer</script>"}},
+	}}
+	chart := tc.Element(map[string]int{"peer": -1}, map[string]string{"peer": "dest"}, "stast.i2p")
+	if strings.Contains(chart, "<script>") || !strings.Contains(chart, "first seen at &lt;script&gt;peer&lt;/script&gt;") {
+		t.Errorf("lookalike source is not escaped: %s", chart)
+	}
+}
@@ -0,0 +1,202 @@
+package jump
+
+import (
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/justinas/nosurf"
+	"i2pgit.org/idk/jump-transparency/lib/naming"
+)
+
+var admin_template = template.Must(template.New("admin").Parse(`<html>
+<head>
+</head>
+<body>
+  <style>
+  body {
+    font-family: monospace;
+    font-size: large;
+  }
+  .warning {
+    color: darkred;
+  }
+  </style>
+  <h1>Moderation: {{ .Name }}</h1>
+  <h2>Registration Queue</h2>
+  {{range .Queue}}
+  <div>
+    <h3>{{.Display}}</h3>
+    <div>{{.Description}}</div>
+    <div><code>{{.Destination}}</code></div>
+    {{range .Lookalikes}}<div class="warning">Warning: {{.}}</div>{{end}}
+    <form action="/admin/approve" method="post">
+      <input type="hidden" name="csrf_token" value="{{ $.CSRFToken }}">
+      <input type="hidden" name="host_name" value="{{.Host}}">
+      <button type="submit">Approve</button>
+    </form>
+    <form action="/admin/reject" method="post">
+      <input type="hidden" name="csrf_token" value="{{ $.CSRFToken }}">
+      <input type="hidden" name="host_name" value="{{.Host}}">
+      <input type="text" name="reason" placeholder="Reason, shown to the submitter">
+      <button type="submit">Reject</button>
+    </form>
+  </div>
+  {{else}}
+  <div>No registrations are waiting for approval.</div>
+  {{end}}
+  <h2>Remove a Hostname</h2>
+  <div>Removed names leave our hosts file, and the removal and its reason are recorded in the
+  public transparency log. Subscribers only drop names whose owner signed the removal, so
+  names removed here stay in address books which already have them.</div>
+  <form action="/admin/remove" method="post">
+    <input type="hidden" name="csrf_token" value="{{ $.CSRFToken }}">
+    <label for="remove_name">Hostname:</label>
+    <input type="text" id="remove_name" name="host_name"></br>
+    <label for="remove_reason">Reason:</label>
+    <input type="text" id="remove_reason" name="reason"></br>
+    <button type="submit">Remove</button>
+  </form>
+  <h2>Trust Alerts</h2>
+  {{range .Alerts}}
+  <div class="warning">Peer {{.Peer}}, {{.}}</div>
+  {{else}}
+  <div>Every pinned peer resolves to the destination it is pinned to.</div>
+  {{end}}
+  <h2>Lookalike Names in Peer Feeds</h2>
+  {{range .Lookalikes}}
+  <div class="warning">{{index . 0}}, from {{(index . 0).Source}}{{if gt (len .) 1}}, and {{len .}} names in all{{end}}</div>
+  {{else}}
+  <div>No new name in a peer feed looks like a known one.</div>
+  {{end}}
+</body>
+</html>
+`))
+
+// queuedHost is a registration as the moderation view shows it.
+type queuedHost struct {
+	Host
+	Display    string
+	Lookalikes []Lookalike
+}
+
+// Moderation renders the moderation view: the registrations waiting for
+// approval, each with the known hosts it looks like, the trust alerts raised
+// by pinned peers, and the lookalike names which have appeared in peer feeds.
+func (ws *WebServer) Moderation(rw http.ResponseWriter, rq *http.Request) {
+	known := ws.knownNames()
+	var queue []queuedHost
+	for _, h := range ws.Queue.Hosts().HostList {
+		queue = append(queue, queuedHost{h, naming.Display(h.Host), findLookalikes(h.Host, "", known)})
+	}
+	lookalikes := ws.Lookalikes()
+	var names []string
+	for name := range lookalikes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var flagged [][]Lookalike
+	for _, name := range names {
+		flagged = append(flagged, lookalikes[name])
+	}
+	var alerts []TrustAlert
+	for _, peer := range ws.Peers() {
+		if alert, ok := peer.TrustAlert(); ok {
+			alerts = append(alerts, alert)
+		}
+	}
+	rw.Header().Add("Content-Type", "text/html")
+	err := admin_template.Execute(rw, struct {
+		Name       string
+		Queue      []queuedHost
+		Alerts     []TrustAlert
+		Lookalikes [][]Lookalike
+		CSRFToken  string
+	}{ws.Me.Name, queue, alerts, flagged, nosurf.Token(rq)})
+	if err != nil {
+		log.Printf("Template execution error, %s", err)
+	}
+}
+
+// Approve moves a queued registration into our own hosts file, settles its
+// ticket, records it in the history and the transparency log and writes the
+// hosts file to disk.
+func (ws *WebServer) Approve(hostname string) error {
+	entry, ok := ws.Queue.Hosts().Lookup(hostname)
+	if !ok {
+		return refuse(http.StatusNotFound, "%s is not waiting for approval", naming.Display(hostname))
+	}
+	if !ws.Me.AppendHost(entry) {
+		ws.Queue.Remove(hostname)
+		if err := ws.Tickets.Resolve(hostname, TicketRejected, "already registered"); err != nil {
+			return err
+		}
+		return refuse(http.StatusConflict, "%s is already registered", naming.Display(hostname))
+	}
+	ws.Queue.Remove(hostname)
+	log.Printf("approved registration: %s", hostname)
+	if err := ws.Tickets.Resolve(hostname, TicketApproved, ""); err != nil {
+		return err
+	}
+	return ws.record(entry, ByAdmin, "")
+}
+
+// Reject drops a queued registration, giving the submitter reason.
+func (ws *WebServer) Reject(hostname, reason string) error {
+	if !ws.Queue.Remove(hostname) {
+		return refuse(http.StatusNotFound, "%s is not waiting for approval", naming.Display(hostname))
+	}
+	log.Printf("rejected registration: %s %s", hostname, reason)
+	return ws.Tickets.Resolve(hostname, TicketRejected, reason)
+}
+
+// writeHostsFile writes our own hosts file back to where it was loaded from.
+func (ws *WebServer) writeHostsFile() error {
+	if ws.hostsFile == "" {
+		return nil
+	}
+	ws.writing.Lock()
+	defer ws.writing.Unlock()
+	return ioutil.WriteFile(ws.hostsFile, ws.Me.Hosts().LocalFile(), 0644)
+}
+
+// serveAdmin answers the moderation view and its actions.
+func (ws *WebServer) serveAdmin(rw http.ResponseWriter, rq *http.Request) {
+	if !ws.authorized(rw, rq) {
+		return
+	}
+	var action func(string) error
+	switch rq.URL.Path {
+	case "/admin", "/admin/":
+		ws.Moderation(rw, rq)
+		return
+	case "/admin/approve":
+		action = ws.Approve
+	case "/admin/reject":
+		action = func(hostname string) error {
+			return ws.Reject(hostname, rq.FormValue("reason"))
+		}
+	case "/admin/remove":
+		action = func(hostname string) error {
+			return ws.Remove(naming.Normalize(hostname), rq.FormValue("reason"))
+		}
+	default:
+		http.NotFound(rw, rq)
+		return
+	}
+	if rq.Method != http.MethodPost {
+		http.Error(rw, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := action(rq.FormValue("host_name")); err != nil {
+		status := http.StatusInternalServerError
+		if refused, ok := err.(*RegistrationError); ok {
+			status = refused.Status
+		}
+		http.Error(rw, err.Error(), status)
+		return
+	}
+	http.Redirect(rw, rq, "/admin", http.StatusSeeOther)
+}
@@ -0,0 +1,143 @@
+package jump
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/eyedeekay/sam3/i2pkeys"
+)
+
+// testAdmin are the admin credentials adminRequest configures and sends.
+var testAdmin = AdminConfig{Username: "admin", Password: "secret"}
+
+func adminRequest(is *I2PServer, method, path string, form url.Values) *httptest.ResponseRecorder {
+	is.mutex.Lock()
+	is.admin = testAdmin
+	is.mutex.Unlock()
+	rq := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+	rq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rq.SetBasicAuth(testAdmin.Username, testAdmin.Password)
+	rw := httptest.NewRecorder()
+	is.WebServer.ServeHTTP(rw, rq)
+	return rw
+}
+
+func TestModeration(t *testing.T) {
+	dest := newDest(t)
+	hosts := writeHosts(t, "hosts.txt", map[string]i2pkeys.I2PAddr{"i2p-project.i2p": dest})
+	is, err := NewI2PServerFromTransport(uniqueName("moderated"), hosts, nil, NewTCPTransport("127.0.0.1:0", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"i2p-projekt.i2p", "unrelated.i2p"} {
+		if _, err := is.Register(name, dest.Base64(), "", name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	page := adminRequest(is, "GET", "/admin", nil).Body.String()
+	if !strings.Contains(page, "Warning: i2p-projekt.i2p looks like i2p-project.i2p (typo)") {
+		t.Errorf("moderation view does not warn about the typosquat: %s", page)
+	}
+	if strings.Count(page, "Warning:") != 1 {
+		t.Errorf("moderation view warns about an unrelated name: %s", page)
+	}
+
+	if rw := adminRequest(is, "POST", "/admin/approve", url.Values{"host_name": {"unrelated.i2p"}}); rw.Code != http.StatusSeeOther {
+		t.Fatalf("approving: %d %s", rw.Code, rw.Body.String())
+	}
+	if rw := adminRequest(is, "POST", "/admin/reject", url.Values{"host_name": {"i2p-projekt.i2p"}}); rw.Code != http.StatusSeeOther {
+		t.Fatalf("rejecting: %d %s", rw.Code, rw.Body.String())
+	}
+	if len(is.Queue.ToMap()) != 0 {
+		t.Errorf("moderated registrations are still queued: %v", is.Queue.ToMap())
+	}
+	if _, ok := is.Me.ToMap()["unrelated.i2p"]; !ok {
+		t.Error("approved registration was not published")
+	}
+	if _, ok := is.Me.ToMap()["i2p-projekt.i2p"]; ok {
+		t.Error("rejected registration was published")
+	}
+	written, err := ioutil.ReadFile(hosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(written), "unrelated.i2p="+dest.Base64()) {
+		t.Errorf("approved registration was not written to the hosts file: %s", written)
+	}
+	if rw := adminRequest(is, "POST", "/admin/approve", url.Values{"host_name": {"missing.i2p"}}); rw.Code != http.StatusNotFound {
+		t.Errorf("approving a name which is not queued: %d", rw.Code)
+	}
+	if rw := adminRequest(is, "GET", "/admin/approve", nil); rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("approving with GET: %d", rw.Code)
+	}
+
+	rw := httptest.NewRecorder()
+	is.WebServer.ServeHTTP(rw, httptest.NewRequest("GET", "/admin", nil))
+	if rw.Code != http.StatusUnauthorized {
+		t.Errorf("moderation view without credentials: %d", rw.Code)
+	}
+	is.Configure(&Config{RateLimits: DefaultConfig().RateLimits, Fetch: DefaultConfig().Fetch})
+	rq := httptest.NewRequest("POST", "/admin/approve", strings.NewReader(url.Values{"host_name": {"i2p-projekt.i2p"}}.Encode()))
+	rq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rw = httptest.NewRecorder()
+	is.WebServer.ServeHTTP(rw, rq)
+	if rw.Code != http.StatusForbidden {
+		t.Errorf("approving without configured credentials: %d", rw.Code)
+	}
+}
+
+func TestFeedLookalikes(t *testing.T) {
+	dest := newDest(t)
+	var mutex sync.Mutex
+	body := "stats.i2p=" + dest.Base64() + "\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rq *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		rw.Write([]byte(body))
+	}))
+	defer srv.Close()
+	hosts := writeHosts(t, "hosts.txt", map[string]i2pkeys.I2PAddr{"identiguy.i2p": dest})
+	is, err := NewI2PServerFromTransport(uniqueName("watching"), hosts, nil, NewTCPTransport("127.0.0.1:0", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := is.UpdatePeers([]PeerConfig{{Name: uniqueName("feed"), URL: srv.URL + "/hosts.txt"}}); err != nil {
+		t.Fatal(err)
+	}
+	peer := is.Peers()[0]
+	if err := peer.Fetch(); err != nil {
+		t.Fatal(err)
+	}
+	mutex.Lock()
+	body += "stast.i2p=" + dest.Base64() + "\nidentiguyy.i2p=" + dest.Base64() + "\nfresh-site.i2p=" + dest.Base64() + "\n"
+	mutex.Unlock()
+	if err := peer.Fetch(); err != nil {
+		t.Fatal(err)
+	}
+	lookalikes := is.Lookalikes()
+	if len(lookalikes) != 2 || lookalikes["stast.i2p"] == nil || lookalikes["identiguyy.i2p"] == nil {
+		t.Fatalf("unexpected lookalikes: %v", lookalikes)
+	}
+	if got := lookalikes["identiguyy.i2p"][0]; got.Similar != "identiguy.i2p" || got.Source != peer.Name {
+		t.Errorf("unexpected lookalike: %+v", got)
+	}
+	chart := is.TrustChartSinglePage("stast.i2p")
+	if !strings.Contains(chart, "Warning: stast.i2p looks like stats.i2p (typo)") {
+		t.Errorf("trust chart does not warn about the lookalike: %s", chart)
+	}
+
+	mutex.Lock()
+	body = "stats.i2p=" + dest.Base64() + "\n"
+	mutex.Unlock()
+	if err := peer.Fetch(); err != nil {
+		t.Fatal(err)
+	}
+	if lookalikes := is.Lookalikes(); len(lookalikes) != 0 {
+		t.Errorf("lookalikes which left the feed are still flagged: %v", lookalikes)
+	}
+}
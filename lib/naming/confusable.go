@@ -0,0 +1,57 @@
+package naming
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// prototypes maps characters which are easily mistaken for a Latin letter or
+// digit to that letter, after the confusables data of Unicode TR39. Only the
+// characters which look the same as their prototype in common fonts are
+// listed.
+var prototypes = map[rune]rune{
+	// Digits and dotless letters.
+	'0': 'o', '1': 'l', 'ı': 'i', 'ȷ': 'j',
+	// Cyrillic.
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'һ': 'h', 'і': 'i', 'ї': 'i',
+	'ј': 'j', 'к': 'k', 'ӏ': 'l', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p',
+	'ԛ': 'q', 'ѕ': 's', 'т': 't', 'с': 'c', 'у': 'y', 'ԝ': 'w', 'х': 'x',
+	'ԁ': 'd', 'ɡ': 'g', 'ո': 'n', 'ս': 'u', 'ց': 'g', 'օ': 'o', 'ք': 'p',
+	// Greek.
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v',
+	'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x', 'γ': 'y', 'ω': 'w',
+	// Latin letters from other blocks.
+	'ɑ': 'a', 'ƅ': 'b', 'ɩ': 'i', 'ʟ': 'l', 'ɴ': 'n', 'ɪ': 'i', 'ꜱ': 's',
+	'ᴄ': 'c', 'ᴅ': 'd', 'ᴇ': 'e', 'ᴋ': 'k', 'ᴍ': 'm', 'ᴏ': 'o', 'ᴘ': 'p',
+	'ᴛ': 't', 'ᴜ': 'u', 'ᴠ': 'v', 'ᴡ': 'w', 'ᴢ': 'z',
+}
+
+// sequences are runs of letters which together look like a single one, in
+// the form they are rewritten to.
+var sequences = strings.NewReplacer("m", "rn", "w", "vv")
+
+// Skeleton returns the form of a hostname two names share when they look
+// alike: Punycode labels are decoded, accented letters decomposed and their
+// combining marks dropped, and every
+// character replaced by the letter it is mistaken for.
+func Skeleton(name string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(ToUnicode(name)) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		r = unicode.ToLower(r)
+		if p, ok := prototypes[r]; ok {
+			r = p
+		}
+		b.WriteRune(r)
+	}
+	return sequences.Replace(b.String())
+}
+
+// Confusable reports whether two different hostnames look alike.
+func Confusable(a, b string) bool {
+	return a != b && Skeleton(a) == Skeleton(b)
+}
@@ -0,0 +1,73 @@
+package naming
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
+)
+
+// ACEPrefix marks a label as the Punycode encoding of a Unicode label.
+const ACEPrefix = "xn--"
+
+// profile converts hostnames under the IDNA2008 rules for registering names,
+// which refuse anything a lookup would have to map first.
+var profile = idna.Registration
+
+// dots are the characters IDNA treats as label separators.
+var dots = strings.NewReplacer("。", ".", "．", ".", "｡", ".")
+
+// ToASCII converts a hostname written in Unicode to the ASCII form address
+// books store, lowercasing it and encoding each label which is not plain
+// ASCII with Punycode. ASCII names are only lowercased.
+func ToASCII(name string) (string, error) {
+	return profile.ToASCII(norm.NFC.String(strings.ToLower(dots.Replace(name))))
+}
+
+// ToUnicode converts the Punycode labels of a hostname back to Unicode.
+// Labels which do not decode to a valid name are left as they are.
+func ToUnicode(name string) string {
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if !strings.HasPrefix(label, ACEPrefix) {
+			continue
+		}
+		if decoded, err := profile.ToUnicode(label); err == nil {
+			labels[i] = decoded
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+// Display returns a hostname the way it is shown to people: its Unicode form
+// followed by the stored form in parentheses, or just the name if it has no
+// Punycode labels.
+func Display(name string) string {
+	if u := ToUnicode(name); u != name {
+		return u + " (" + name + ")"
+	}
+	return name
+}
+
+// checkACE explains what is wrong with an xn-- label, or returns "" if it
+// encodes a valid Unicode label in canonical form.
+func checkACE(label string) string {
+	decoded, err := profile.ToUnicode(label)
+	if err != nil {
+		return fmt.Sprintf("is not a valid IDNA label: %s", err)
+	}
+	for _, r := range decoded {
+		if r > 0x7f && !(unicode.IsLetter(r) || unicode.IsMark(r) || unicode.IsDigit(r)) {
+			return fmt.Sprintf("encodes %q, only letters, digits and hyphens are allowed", r)
+		}
+	}
+	if decoded == label || strings.IndexFunc(decoded, func(r rune) bool { return r > 0x7f }) < 0 {
+		return "only encodes ASCII, which should be written as it is"
+	}
+	if canonical, err := profile.ToASCII(decoded); err != nil || canonical != label {
+		return "is not the canonical punycode encoding of its name"
+	}
+	return ""
+}
@@ -0,0 +1,145 @@
+// Package naming implements the rules I2P address books apply to hostnames,
+// following the checks made by the Java router's address book and by i2pd.
+//
+// A valid hostname is lowercase, ends in .i2p, and is made of labels of
+// letters, digits and hyphens separated by single dots. Labels may not start
+// or end with a hyphen, and may only contain a double hyphen as an IDNA
+// "xn--" prefix. Names which could be mistaken for Base32 addresses, and
+// names the router and its proxies reserve for themselves, are refused.
+//
+// Internationalized names are stored in their IDNA form, with every label
+// outside ASCII encoded with Punycode under the IDNA2008 registration rules.
+// Normalize converts names written in Unicode, and Validate checks that xn--
+// labels decode to letters and digits.
+package naming
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// MaxLength is the longest hostname, .i2p suffix included.
+	MaxLength = 67
+	// MaxLabelLength is the longest single label of a hostname.
+	MaxLabelLength = 63
+	// Suffix is the top-level domain of every hostname.
+	Suffix = ".i2p"
+)
+
+// reserved are names the router, its console and its proxies use locally.
+// They are refused along with all of their subdomains.
+var reserved = []string{
+	"b32.i2p",
+	"proxy.i2p",
+	"router.i2p",
+	"console.i2p",
+	"localhost.i2p",
+	"localhost",
+}
+
+// Error explains why a hostname is not valid.
+type Error struct {
+	Name   string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%q is not a valid I2P hostname: %s", e.Name, e.Reason)
+}
+
+func invalid(name, format string, args ...interface{}) error {
+	return &Error{Name: name, Reason: fmt.Sprintf(format, args...)}
+}
+
+// Normalize returns name the way address books store it: trimmed of
+// surrounding space, lowercased, and with Unicode labels converted to
+// Punycode. A name which cannot be converted is returned lowercased, for
+// Validate to refuse.
+func Normalize(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if ascii, err := ToASCII(name); err == nil {
+		return ascii
+	}
+	return name
+}
+
+// Reserved reports whether name, or a domain it is under, is reserved.
+func Reserved(name string) bool {
+	for _, r := range reserved {
+		if name == r || strings.HasSuffix(name, "."+r) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parent returns the domain name is directly under, or "" if name is a
+// top-level hostname like example.i2p.
+func Parent(name string) string {
+	i := strings.Index(name, ".")
+	if i < 0 || !strings.Contains(name[i+1:], ".") {
+		return ""
+	}
+	return name[i+1:]
+}
+
+// base32Like reports whether name has the shape of a Base32 address without
+// its .b32 label, 52 Base32 characters followed by .i2p.
+func base32Like(name string) bool {
+	label := strings.TrimSuffix(name, Suffix)
+	if len(label) != 52 {
+		return false
+	}
+	for _, r := range label {
+		if !(r >= 'a' && r <= 'z' || r >= '2' && r <= '7') {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate checks name against the naming rules. The name has to be
+// normalized already, an uppercase letter is an error.
+func Validate(name string) error {
+	if name == "" {
+		return invalid(name, "it is empty")
+	}
+	if Reserved(name) {
+		return invalid(name, "it is reserved for local use by the router")
+	}
+	if !strings.HasSuffix(name, Suffix) || len(name) == len(Suffix) {
+		return invalid(name, "it must end in %s", Suffix)
+	}
+	if len(name) > MaxLength {
+		return invalid(name, "it is %d characters long, the limit is %d", len(name), MaxLength)
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '.' || r == '-') {
+			return invalid(name, "it contains %q, only lowercase letters, digits, hyphens and dots are allowed", r)
+		}
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(name, Suffix), ".") {
+		if label == "" {
+			return invalid(name, "it has an empty label")
+		}
+		if len(label) > MaxLabelLength {
+			return invalid(name, "the label %q is longer than %d characters", label, MaxLabelLength)
+		}
+		if strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return invalid(name, "the label %q starts or ends with a hyphen", label)
+		}
+		if strings.Contains(label, "--") && !strings.HasPrefix(label, "xn--") {
+			return invalid(name, "the label %q contains a double hyphen outside of an xn-- prefix", label)
+		}
+		if strings.HasPrefix(label, ACEPrefix) {
+			if reason := checkACE(label); reason != "" {
+				return invalid(name, "the label %q %s", label, reason)
+			}
+		}
+	}
+	if base32Like(name) {
+		return invalid(name, "it could be mistaken for a Base32 address")
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package naming
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	for _, name := range []string{
+		"example.i2p",
+		"sub.example.i2p",
+		"a.i2p",
+		"0-9.i2p",
+		"www.xn--bcher-kva.i2p",
+		strings.Repeat("a", 63) + ".i2p",
+		"proxyless.i2p",
+	} {
+		if err := Validate(name); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+	}
+	for _, name := range []string{
+		"",
+		".i2p",
+		"example",
+		"example.com",
+		"Example.i2p",
+		"under_score.i2p",
+		"sp ace.i2p",
+		"bad..i2p",
+		".lead.i2p",
+		"-lead.i2p",
+		"trail-.i2p",
+		"a.-b.i2p",
+		"dou--ble.i2p",
+		strings.Repeat("a", 64) + ".i2p",
+		strings.Repeat("a.", 33) + "i2p",
+		strings.Repeat("a", 52) + ".i2p",
+		"b32.i2p",
+		strings.Repeat("a", 52) + ".b32.i2p",
+		"proxy.i2p",
+		"router.i2p",
+		"console.i2p",
+		"www.console.i2p",
+		"localhost",
+		"localhost.i2p",
+		"xn--n3h.i2p",
+		"xn--abc.i2p",
+		"xn--example-.i2p",
+		"xn--zz.i2p",
+	} {
+		err := Validate(name)
+		if err == nil {
+			t.Errorf("%q was accepted", name)
+			continue
+		}
+		if e, ok := err.(*Error); !ok || e.Name != name || e.Reason == "" {
+			t.Errorf("%q: unexpected error %#v", name, err)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	for in, want := range map[string]string{
+		"  Example.I2P\n": "example.i2p",
+		"Bücher.i2p":      "xn--bcher-kva.i2p",
+		"www.пример.i2p":  "www.xn--e1afmkfd.i2p",
+		"日本語。i2p":         "xn--wgv71a119e.i2p",
+	} {
+		if got := Normalize(in); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", in, got, want)
+		}
+		if err := Validate(Normalize(in)); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestIDNA(t *testing.T) {
+	// Samples from RFC 3492 section 7.1 which are valid hostnames.
+	for _, c := range []struct{ unicode, encoded string }{
+		{"bücher.i2p", "xn--bcher-kva.i2p"},
+		{"münchen.i2p", "xn--mnchen-3ya.i2p"},
+		{"пример.i2p", "xn--e1afmkfd.i2p"},
+		{"他们为什么不说中文.i2p", "xn--ihqwcrb4cv8a8dqg056pqjye.i2p"},
+	} {
+		encoded, err := ToASCII(c.unicode)
+		if err != nil || encoded != c.encoded {
+			t.Errorf("ToASCII(%q) = %q, %v, want %q", c.unicode, encoded, err, c.encoded)
+		}
+		if decoded := ToUnicode(c.encoded); decoded != c.unicode {
+			t.Errorf("ToUnicode(%q) = %q, want %q", c.encoded, decoded, c.unicode)
+		}
+	}
+	for _, bad := range []string{"under_score.i2p", "a\u200db.i2p", "Ⅻ.i2p"} {
+		if ascii, err := ToASCII(bad); err == nil {
+			t.Errorf("ToASCII(%q) = %q", bad, ascii)
+		}
+	}
+	if got := ToUnicode("xn--zz.xn--bcher-kva.i2p"); got != "xn--zz.bücher.i2p" {
+		t.Errorf("invalid label was decoded: %q", got)
+	}
+	if got := Display("www.xn--bcher-kva.i2p"); got != "www.bücher.i2p (www.xn--bcher-kva.i2p)" {
+		t.Errorf("Display: %q", got)
+	}
+}
+
+func TestConfusable(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want bool
+	}{
+		{"paypal.i2p", Normalize("раураl.i2p"), true},
+		{"google.i2p", "g00gle.i2p", true},
+		{"modern.i2p", "rnodern.i2p", true},
+		{"forum.i2p", Normalize("fοrum.i2p"), true},
+		{"forum.i2p", "forum.i2p", false},
+		{"forum.i2p", "forums.i2p", false},
+		{Normalize("bu\u0308cher.i2p"), "bucher.i2p", true},
+	} {
+		if got := Confusable(c.a, c.b); got != c.want {
+			t.Errorf("Confusable(%q, %q) = %v", c.a, c.b, got)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package jump
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/eyedeekay/sam3/i2pkeys"
+)
+
+// TrustAlert is raised when a peer's hostname resolves to another
+// destination than the one it is pinned to, which means the local address
+// book has been poisoned or the peer has moved without telling us.
+type TrustAlert struct {
+	Time    time.Time
+	Peer    string
+	Message string
+}
+
+func (a TrustAlert) String() string {
+	return fmt.Sprintf("%s: %s", a.Time.UTC().Format(time.RFC3339), a.Message)
+}
+
+// pinnedBase32 returns the Base32 address of a pin, which is either a full
+// destination or a Base32 address.
+func pinnedBase32(pin string) (string, error) {
+	if b32 := strings.ToLower(pin); strings.HasSuffix(b32, ".b32.i2p") {
+		hash := strings.TrimSuffix(b32, ".b32.i2p")
+		if len(hash) != 52 || strings.Trim(hash, "abcdefghijklmnopqrstuvwxyz234567") != "" {
+			return "", fmt.Errorf("%s is not a Base32 address", pin)
+		}
+		return b32, nil
+	}
+	if err := ValidateDestination(pin); err != nil {
+		return "", err
+	}
+	return i2pkeys.I2PAddr(pin).Base32(), nil
+}
+
+// Pinned returns the destination the peer is pinned to, if any.
+func (j *I2PJump) Pinned() string {
+	pin, _ := j.pin.Load().(string)
+	return pin
+}
+
+// SetPinned pins the peer to a destination or Base32 address. Fetches then
+// refuse to go ahead when the peer's hostname resolves to anything else, and
+// connect to the pinned address rather than to whatever the name resolves
+// to.
+func (j *I2PJump) SetPinned(pin string) {
+	j.pin.Store(pin)
+}
+
+// TrustAlert returns the alert raised by the last fetch, if it raised one.
+func (j *I2PJump) TrustAlert() (TrustAlert, bool) {
+	alert, ok := j.alert.Load().(TrustAlert)
+	return alert, ok && alert.Message != ""
+}
+
+// pinnedHost checks that the peer's hostname resolves to its pinned
+// destination and returns the address to dial it at: its Base32 address if
+// it is pinned, its hostname otherwise. A mismatch raises a trust alert.
+// On a transport which does not resolve I2P names, such as plain TCP, there is
+// no address book to check, so the pinned address is dialed without a lookup.
+func (j *I2PJump) pinnedHost() (string, error) {
+	pin := j.Pinned()
+	if pin == "" {
+		return j.MyURL.Host, nil
+	}
+	want, err := pinnedBase32(pin)
+	if err != nil {
+		return "", fmt.Errorf("%s is pinned to an invalid destination: %s", j.Name, err)
+	}
+	if r, ok := j.Transport.(Resolver); ok && !r.Resolves() {
+		return want, nil
+	}
+	resolved, err := j.Transport.Lookup(j.MyURL.Host)
+	if err != nil {
+		return "", err
+	}
+	got, err := i2pkeys.NewI2PAddrFromString(resolved.String())
+	if err != nil {
+		return "", j.raiseAlert(fmt.Sprintf("%s does not resolve to an I2P destination, but peer %s is pinned to %s", j.MyURL.Hostname(), j.Name, want))
+	}
+	if got.Base32() != want {
+		return "", j.raiseAlert(fmt.Sprintf("%s resolves to %s, but peer %s is pinned to %s", j.MyURL.Hostname(), got.Base32(), j.Name, want))
+	}
+	return want, nil
+}
+
+// raiseAlert records and logs a trust alert, returning the error which
+// refuses the fetch.
+func (j *I2PJump) raiseAlert(message string) error {
+	j.alert.Store(TrustAlert{time.Now(), j.Name, message})
+	log.Printf("TRUST ALERT: %s", message)
+	return fmt.Errorf("refusing to fetch %s: %s", j.Name, message)
+}
@@ -0,0 +1,307 @@
+package jump
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"i2pgit.org/idk/jump-transparency/lib/naming"
+)
+
+// RegistrationError is a registration which was refused for a reason the
+// registrant should be told about. Status is the HTTP status to answer with.
+type RegistrationError struct {
+	Status int
+	// Field and Code are set when a submitted field failed validation:
+	// the field, as named in the API, and one of the Code constants.
+	Field   string
+	Code    string
+	Message string
+}
+
+// Codes of the validation errors a registration can be refused with.
+const (
+	CodeInvalidHostname    = "invalid_hostname"
+	CodeHostnameMismatch   = "hostname_mismatch"
+	CodeInvalidLine        = "invalid_line"
+	CodeInvalidDestination = "invalid_destination"
+	CodeInvalidSignature   = "invalid_signature"
+	CodeMissingSignature   = "missing_signature"
+)
+
+func (e *RegistrationError) Error() string {
+	return e.Message
+}
+
+func refuse(status int, format string, args ...interface{}) error {
+	return &RegistrationError{Status: status, Message: fmt.Sprintf(format, args...)}
+}
+
+// invalid refuses a registration whose field failed validation as code.
+func invalid(field, code, format string, args ...interface{}) error {
+	return &RegistrationError{Status: http.StatusBadRequest, Field: field, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Registration is a registration which was accepted.
+type Registration struct {
+	Entry Host
+	// Applied is set when the registration took effect at once, as an
+	// authorized change of destination or removal does, instead of being
+	// queued for approval.
+	Applied bool
+	// Ticket is the receipt for a queued registration.
+	Ticket Ticket
+}
+
+// String returns the message for the registrant.
+func (r *Registration) String() string {
+	if r.Applied {
+		switch strings.ToLower(r.Entry.Props[PropAction]) {
+		case ActionRemove:
+			return fmt.Sprintf("%s has been removed.", naming.Display(r.Entry.Host))
+		case ActionRenew:
+			return fmt.Sprintf("%s has been renewed.", naming.Display(r.Entry.Host))
+		}
+		return fmt.Sprintf("%s now points to the new destination.", naming.Display(r.Entry.Host))
+	}
+	return fmt.Sprintf("%s has been queued for approval by the administrator. Your ticket is %s, and what becomes of the registration is shown at %s", naming.Display(r.Entry.Host), r.Ticket.ID, statusPath(r.Ticket.ID))
+}
+
+// Register queues a hostname registration from client for approval, after
+// checking the hostname against the naming rules and the destination for
+// well-formedness, that the hostname cannot be mistaken for one which is
+// already registered or queued, and that client has not registered a name too
+// recently. Unicode hostnames are queued in their Punycode form.
+//
+// The destination may instead be a signed line in the extended hosts file
+// format, for the hostname or with hostname left empty. A signature on it has
+// to be valid, and a subdomain is only accepted with the authorization of the
+// key its parent domain is registered here with. A line with
+// action=changedest, signed by the key the hostname is registered with,
+// moves the hostname to its new destination without waiting for approval,
+// a remove command line signed by that key removes it, and one with
+// action=renew renews it under the expiry policy.
+func (ws *WebServer) Register(hostname, destination, description, client string) (*Registration, error) {
+	entry := Host{Host: hostname, Destination: destination}
+	if isEntry(destination) {
+		var err error
+		if entry, err = ParseEntry(destination); err != nil {
+			return nil, invalid("destination", CodeInvalidLine, "%s", err)
+		}
+		if hostname != "" && naming.Normalize(hostname) != entry.Host {
+			return nil, invalid("hostname", CodeHostnameMismatch, "The authentication string is for %s, not %s", entry.Host, hostname)
+		}
+	}
+	entry.Description = description
+	entry.Host = naming.Normalize(entry.Host)
+	hostname = entry.Host
+	if err := naming.Validate(hostname); err != nil {
+		return nil, invalid("hostname", CodeInvalidHostname, "%s", err)
+	}
+	if err := ValidateDestination(entry.Destination); err != nil {
+		return nil, invalid("destination", CodeInvalidDestination, "The authentication string is not a valid destination: %s", err)
+	}
+	if _, signed := entry.Props[PropSig]; signed {
+		if err := entry.VerifySignature(); err != nil {
+			return nil, invalid("sig", CodeInvalidSignature, "The authentication string has an invalid signature: %s", err)
+		}
+	}
+	switch strings.ToLower(entry.Props[PropAction]) {
+	case ActionChangeDest:
+		if err := ws.admit(client, func() error { return ws.changeDest(entry) }); err != nil {
+			return nil, err
+		}
+		return &Registration{Entry: entry, Applied: true}, nil
+	case ActionRemove:
+		if err := ws.removeSigned(entry); err != nil {
+			return nil, err
+		}
+		return &Registration{Entry: entry, Applied: true}, nil
+	case ActionRenew:
+		if err := ws.renew(entry); err != nil {
+			return nil, err
+		}
+		return &Registration{Entry: entry, Applied: true}, nil
+	}
+	if parent := naming.Parent(hostname); parent != "" {
+		parentDest, ok := ws.Me.ToMap()[parent]
+		if !ok {
+			return nil, refuse(http.StatusForbidden, "%s is a subdomain of %s, which is not registered here", naming.Display(hostname), naming.Display(parent))
+		}
+		if err := entry.VerifyParent(parent, parentDest); err != nil {
+			return nil, refuse(http.StatusForbidden, "Subdomains have to be authorized by the key of their parent domain: %s", err)
+		}
+	}
+	if _, taken := ws.Me.ToMap()[hostname]; taken {
+		return nil, refuse(http.StatusConflict, "%s is already registered", naming.Display(hostname))
+	}
+	if held, ok := ws.Expiry.Held(hostname); ok {
+		return nil, refuse(http.StatusConflict, "%s has expired and is held for its owner to renew until %s", naming.Display(hostname), held.Expired.Add(ws.expiryPolicy().Grace).UTC().Format(time.RFC3339))
+	}
+	if existing := ws.confusedWith(hostname); existing != "" {
+		return nil, refuse(http.StatusConflict, "%s could be mistaken for %s, which is already registered", naming.Display(hostname), naming.Display(existing))
+	}
+	err := ws.admit(client, func() error {
+		if !ws.Queue.AppendHost(entry) {
+			return refuse(http.StatusConflict, "%s is already awaiting approval", naming.Display(hostname))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ticket, err := ws.Tickets.Issue(hostname)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("client registered: %s %s %s", hostname, entry.Destination, description)
+	return &Registration{Entry: entry, Ticket: ticket}, nil
+}
+
+// admit runs register for client unless client registered something too
+// recently, and starts the client's wait for the next registration if it
+// succeeds.
+func (ws *WebServer) admit(client string, register func() error) error {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	if last, ok := ws.limited[client]; ok && time.Since(last) < ws.registrationInterval {
+		return refuse(http.StatusTooManyRequests, "Only one hostname may be registered every %s", ws.registrationInterval)
+	}
+	if err := register(); err != nil {
+		return err
+	}
+	ws.limited[client] = time.Now()
+	return nil
+}
+
+// changeDest moves a hostname in our hosts file to the destination of entry,
+// a changedest line signed by the key the hostname is registered with, and
+// records the change in the history.
+func (ws *WebServer) changeDest(entry Host) error {
+	current, ok := ws.Me.Hosts().Lookup(entry.Host)
+	if !ok {
+		return refuse(http.StatusNotFound, "%s is not registered here", naming.Display(entry.Host))
+	}
+	if sameDestination(current.Destination, entry.Destination) {
+		return refuse(http.StatusConflict, "%s already points to this destination", naming.Display(entry.Host))
+	}
+	if err := entry.VerifyRollover(current.Destination); err != nil {
+		return refuse(http.StatusForbidden, "%s", err)
+	}
+	if entry.Description == "" {
+		entry.Description = current.Description
+	}
+	ws.Me.Replace(entry)
+	log.Printf("changed destination: %s %s", entry.Host, entry.Destination)
+	return ws.record(entry, ByOwner, "")
+}
+
+// removalWindow is how far the date of a signed removal may be from the time
+// it is submitted. Removals are published, so without it anyone could replay
+// one to take a name down again after its owner registers it anew.
+const removalWindow = time.Hour * 24
+
+// removeSigned removes a hostname from our hosts file on behalf of its owner,
+// given entry, a remove command line signed by the key it is registered with
+// and dated after the last change to it.
+func (ws *WebServer) removeSigned(entry Host) error {
+	current, ok := ws.Me.Hosts().Lookup(entry.Host)
+	if !ok {
+		return refuse(http.StatusNotFound, "%s is not registered here", naming.Display(entry.Host))
+	}
+	if _, signed := entry.Props[PropSig]; !signed || !sameDestination(current.Destination, entry.Destination) {
+		return refuse(http.StatusForbidden, "A removal of %s has to be signed by the key it is registered with", naming.Display(entry.Host))
+	}
+	if !entry.isCommand() {
+		return refuse(http.StatusBadRequest, "A removal has to be a command line, #!action=remove#date=...#dest=...#name=...#sig=...")
+	}
+	unix, err := strconv.ParseInt(entry.Props[PropDate], 10, 64)
+	if err != nil {
+		return refuse(http.StatusBadRequest, "A removal needs the date it was signed at")
+	}
+	date := time.Unix(unix, 0)
+	if d := time.Since(date); d > removalWindow || d < -removalWindow {
+		return refuse(http.StatusBadRequest, "A removal has to be dated within %s of now", removalWindow)
+	}
+	if events := ws.History.Of(entry.Host); len(events) > 0 && !date.After(events[len(events)-1].Time) {
+		return refuse(http.StatusConflict, "This removal of %s is dated before its last change", naming.Display(entry.Host))
+	}
+	return ws.remove(entry, ByOwner, "")
+}
+
+// Remove takes a hostname down from our hosts file on the administrator's
+// behalf, giving reason in the transparency log.
+func (ws *WebServer) Remove(hostname, reason string) error {
+	current, ok := ws.Me.Hosts().Lookup(hostname)
+	if !ok {
+		return refuse(http.StatusNotFound, "%s is not registered here", naming.Display(hostname))
+	}
+	removal := Host{Host: hostname, Destination: current.Destination, Props: map[string]string{
+		PropAction: ActionRemove,
+		PropDate:   strconv.FormatInt(time.Now().Unix(), 10),
+	}}
+	return ws.remove(removal, ByAdmin, reason)
+}
+
+// remove deletes the hostname of removal, the line which announces its
+// removal to subscribers, from our hosts file.
+func (ws *WebServer) remove(removal Host, by, reason string) error {
+	if !ws.Me.Remove(removal.Host) {
+		return refuse(http.StatusNotFound, "%s is not registered here", naming.Display(removal.Host))
+	}
+	log.Printf("removed by the %s: %s %s", by, removal.Host, reason)
+	return ws.record(removal, by, reason)
+}
+
+// record saves a change to our hosts file in the history and the
+// transparency log, restarts the lifetime of a name which was added or
+// changed, and writes the hosts file to disk.
+func (ws *WebServer) record(entry Host, by, reason string) error {
+	if err := ws.History.Record(entry); err != nil {
+		return err
+	}
+	action := HistoryEvent{Entry: entry}.Action()
+	if err := ws.Transparency.Append(action, entry.Host, by, reason); err != nil {
+		return err
+	}
+	if action == ActionRemove {
+		ws.Expiry.Forget(entry.Host)
+	} else {
+		ws.Expiry.Seen(entry.Host, time.Now())
+	}
+	if err := ws.Expiry.Save(); err != nil {
+		return err
+	}
+	return ws.writeHostsFile()
+}
+
+// PublishedHostsFile returns our hosts file as it is served to subscribers:
+// the registered hosts followed by the remove command lines of the names
+// their owners took down, so that address books drop them too. Address books
+// only act on removals signed by the removed destination, so the names the
+// administrator or the expiry policy took down are only left out.
+func (ws *WebServer) PublishedHostsFile() []byte {
+	hosts := ws.Me.HostsFile()
+	for _, removal := range ws.History.Removals() {
+		if _, signed := removal.Entry.Props[PropSig]; signed && removal.Entry.isCommand() {
+			hosts = append(hosts, removal.Entry.String()...)
+		}
+	}
+	return hosts
+}
+
+// confusedWith returns a registered or queued hostname which looks like
+// hostname, or "" if there is none.
+func (ws *WebServer) confusedWith(hostname string) string {
+	for _, hosts := range []map[string]string{ws.Me.ToMap(), ws.Queue.ToMap()} {
+		for existing := range hosts {
+			if LookalikeReason(hostname, existing) == LookalikeConfusable {
+				return existing
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,431 @@
+// Package samtest provides an in-process fake SAMv3 bridge for exercising
+// code which talks to an I2P router through SAM, without running a router.
+//
+// The bridge understands HELLO, DEST GENERATE, SESSION CREATE (STYLE=STREAM),
+// NAMING LOOKUP, STREAM CONNECT and STREAM ACCEPT. Streams between sessions
+// are spliced together over loopback TCP. Generated destinations use Ed25519
+// signing keys, and the bridge keeps the private halves so tests can sign
+// messages as any destination it created.
+//
+// The sam3 library connects every stream, lookup and accept to the default
+// SAM port regardless of the address a session was created with, so a bridge
+// which is meant to carry streams has to listen on DefaultAddr, and only one
+// such bridge can run on a host at a time.
+package samtest
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eyedeekay/sam3/i2pkeys"
+)
+
+// DefaultAddr is the address a SAM bridge listens on by default.
+const DefaultAddr = "127.0.0.1:7656"
+
+var i2pB64 = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-~")
+
+// acceptDelay gives a STREAM ACCEPT client time to consume the peer
+// destination line before any stream data follows it on the same socket.
+var acceptDelay = time.Millisecond * 50
+
+type session struct {
+	id      string
+	dest    i2pkeys.I2PAddr
+	control net.Conn
+	accepts chan net.Conn
+	closed  chan struct{}
+}
+
+// Bridge is a fake SAM bridge listening on a loopback TCP port.
+type Bridge struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	sessions map[string]*session
+	names    map[string]i2pkeys.I2PAddr
+	signers  map[i2pkeys.I2PAddr]ed25519.PrivateKey
+	conns    map[net.Conn]bool
+	done     chan struct{}
+	closed   bool
+	// Timeout bounds how long a STREAM CONNECT waits for the other side to
+	// call STREAM ACCEPT.
+	Timeout time.Duration
+}
+
+// NewBridge starts a fake SAM bridge on DefaultAddr.
+func NewBridge() (*Bridge, error) {
+	return NewBridgeAt(DefaultAddr)
+}
+
+// NewBridgeAt starts a fake SAM bridge listening on addr. A port of 0 picks a
+// free port, which is enough for testing session setup and lookups.
+func NewBridgeAt(addr string) (*Bridge, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	b := &Bridge{
+		listener: l,
+		sessions: make(map[string]*session),
+		names:    make(map[string]i2pkeys.I2PAddr),
+		signers:  make(map[i2pkeys.I2PAddr]ed25519.PrivateKey),
+		conns:    make(map[net.Conn]bool),
+		done:     make(chan struct{}),
+		Timeout:  time.Second * 10,
+	}
+	go b.serve()
+	return b, nil
+}
+
+// Addr returns the host:port of the bridge, suitable for use as a SAM address.
+func (b *Bridge) Addr() string {
+	return b.listener.Addr().String()
+}
+
+// Close stops the bridge and drops every open session and stream.
+func (b *Bridge) Close() error {
+	b.mutex.Lock()
+	if !b.closed {
+		close(b.done)
+	}
+	b.closed = true
+	for c := range b.conns {
+		c.Close()
+	}
+	b.mutex.Unlock()
+	return b.listener.Close()
+}
+
+// AddName makes name resolvable through NAMING LOOKUP to dest, as if it were
+// in the router's address book.
+func (b *Bridge) AddName(name string, dest i2pkeys.I2PAddr) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.names[name] = dest
+}
+
+// NewKeys generates a destination the bridge can later sign for.
+func (b *Bridge) NewKeys() (i2pkeys.I2PKeys, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+	dest := make([]byte, 384, 391)
+	if _, err := rand.Read(dest[:352]); err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+	copy(dest[352:], pub)
+	// KEY certificate, 4 byte payload: Ed25519 signing, ElGamal crypto.
+	dest = append(dest, 5, 0, 4, 0, 7, 0, 0)
+	private := make([]byte, 256)
+	if _, err := rand.Read(private); err != nil {
+		return i2pkeys.I2PKeys{}, err
+	}
+	both := append(append(append([]byte{}, dest...), private...), priv.Seed()...)
+	addr := i2pkeys.I2PAddr(i2pB64.EncodeToString(dest))
+	b.mutex.Lock()
+	b.signers[addr] = priv
+	b.mutex.Unlock()
+	return i2pkeys.NewKeys(addr, i2pB64.EncodeToString(both)), nil
+}
+
+// Sign returns an I2P Base64 encoded signature over message by a destination
+// which was generated by this bridge.
+func (b *Bridge) Sign(addr i2pkeys.I2PAddr, message []byte) (string, error) {
+	b.mutex.Lock()
+	priv, ok := b.signers[addr]
+	b.mutex.Unlock()
+	if !ok {
+		return "", fmt.Errorf("destination was not generated by this bridge")
+	}
+	return i2pB64.EncodeToString(ed25519.Sign(priv, message)), nil
+}
+
+// Sessions returns the IDs of the sessions which are currently open.
+func (b *Bridge) Sessions() []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	var ids []string
+	for id := range b.sessions {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Drop forgets the session with the given ID and hangs up its control
+// socket, as a restarting bridge would. It reports whether there was such a
+// session.
+func (b *Bridge) Drop(id string) bool {
+	b.mutex.Lock()
+	s, ok := b.sessions[id]
+	delete(b.sessions, id)
+	b.mutex.Unlock()
+	if ok {
+		s.control.Close()
+	}
+	return ok
+}
+
+func (b *Bridge) serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		b.mutex.Lock()
+		if b.closed {
+			b.mutex.Unlock()
+			conn.Close()
+			return
+		}
+		b.conns[conn] = true
+		b.mutex.Unlock()
+		go b.handle(conn)
+	}
+}
+
+func (b *Bridge) forget(conn net.Conn) {
+	b.mutex.Lock()
+	delete(b.conns, conn)
+	b.mutex.Unlock()
+}
+
+// parseArgs splits the KEY=VALUE arguments of a SAM command.
+func parseArgs(fields []string) map[string]string {
+	args := make(map[string]string)
+	for _, f := range fields {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) == 2 {
+			args[kv[0]] = kv[1]
+		} else {
+			args[kv[0]] = ""
+		}
+	}
+	return args
+}
+
+// handle runs the command loop of one SAM control socket. The socket is
+// handed off when it becomes a stream, and the session it created, if any, is
+// dropped when it closes.
+func (b *Bridge) handle(conn net.Conn) {
+	var owned *session
+	handedOff := false
+	defer func() {
+		if owned != nil {
+			b.mutex.Lock()
+			if b.sessions[owned.id] == owned {
+				delete(b.sessions, owned.id)
+			}
+			b.mutex.Unlock()
+			close(owned.closed)
+		}
+		if !handedOff {
+			b.forget(conn)
+			conn.Close()
+		}
+	}()
+	rd := bufio.NewReader(conn)
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			fmt.Fprintf(conn, "%s STATUS RESULT=I2P_ERROR MESSAGE=\"malformed command\"\n", strings.Join(fields, " "))
+			continue
+		}
+		args := parseArgs(fields[2:])
+		switch fields[0] + " " + fields[1] {
+		case "HELLO VERSION":
+			io.WriteString(conn, "HELLO REPLY RESULT=OK VERSION=3.1\n")
+		case "DEST GENERATE":
+			keys, err := b.NewKeys()
+			if err != nil {
+				fmt.Fprintf(conn, "DEST REPLY RESULT=I2P_ERROR MESSAGE=\"%s\"\n", err)
+				continue
+			}
+			fmt.Fprintf(conn, "DEST REPLY PUB=%s PRIV=%s\n", keys.Addr().Base64(), keys.String())
+		case "NAMING LOOKUP":
+			name := args["NAME"]
+			if dest, ok := b.lookup(name); ok {
+				fmt.Fprintf(conn, "NAMING REPLY RESULT=OK NAME=%s VALUE=%s\n", name, dest.Base64())
+			} else {
+				fmt.Fprintf(conn, "NAMING REPLY RESULT=KEY_NOT_FOUND NAME=%s\n", name)
+			}
+		case "SESSION CREATE":
+			if owned != nil {
+				io.WriteString(conn, "SESSION STATUS RESULT=I2P_ERROR MESSAGE=\"session already created\"\n")
+				continue
+			}
+			s, reply := b.create(conn, args)
+			owned = s
+			io.WriteString(conn, reply)
+		case "STREAM CONNECT":
+			handedOff = b.connect(conn, args)
+			return
+		case "STREAM ACCEPT":
+			handedOff = b.accept(conn, args)
+			return
+		default:
+			fmt.Fprintf(conn, "%s STATUS RESULT=I2P_ERROR MESSAGE=\"unsupported command\"\n", fields[0])
+		}
+	}
+}
+
+func (b *Bridge) lookup(name string) (i2pkeys.I2PAddr, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if dest, ok := b.names[name]; ok {
+		return dest, true
+	}
+	if strings.HasSuffix(name, ".b32.i2p") {
+		for _, s := range b.sessions {
+			if s.dest.Base32() == name {
+				return s.dest, true
+			}
+		}
+		for _, dest := range b.names {
+			if dest.Base32() == name {
+				return dest, true
+			}
+		}
+	}
+	return "", false
+}
+
+// publicFromPrivate extracts the destination from a SAM private key string.
+func publicFromPrivate(priv string) (i2pkeys.I2PAddr, error) {
+	raw, err := i2pB64.DecodeString(priv)
+	if err != nil {
+		return "", err
+	}
+	if len(raw) < 387 {
+		return "", fmt.Errorf("private key too short")
+	}
+	end := 387 + int(binary.BigEndian.Uint16(raw[385:387]))
+	if len(raw) < end {
+		return "", fmt.Errorf("private key truncated")
+	}
+	return i2pkeys.I2PAddr(i2pB64.EncodeToString(raw[:end])), nil
+}
+
+func (b *Bridge) create(control net.Conn, args map[string]string) (*session, string) {
+	if args["STYLE"] != "STREAM" {
+		return nil, "SESSION STATUS RESULT=I2P_ERROR MESSAGE=\"only STREAM sessions are supported\"\n"
+	}
+	priv := args["DESTINATION"]
+	if priv == "TRANSIENT" {
+		keys, err := b.NewKeys()
+		if err != nil {
+			return nil, "SESSION STATUS RESULT=I2P_ERROR MESSAGE=\"" + err.Error() + "\"\n"
+		}
+		priv = keys.String()
+	}
+	dest, err := publicFromPrivate(priv)
+	if err != nil {
+		return nil, "SESSION STATUS RESULT=INVALID_KEY\n"
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if _, ok := b.sessions[args["ID"]]; ok {
+		return nil, "SESSION STATUS RESULT=DUPLICATED_ID\n"
+	}
+	for _, s := range b.sessions {
+		if s.dest == dest {
+			return nil, "SESSION STATUS RESULT=DUPLICATED_DEST\n"
+		}
+	}
+	s := &session{id: args["ID"], dest: dest, control: control, accepts: make(chan net.Conn), closed: make(chan struct{})}
+	b.sessions[s.id] = s
+	return s, "SESSION STATUS RESULT=OK DESTINATION=" + priv + "\n"
+}
+
+func (b *Bridge) session(id string) *session {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.sessions[id]
+}
+
+func (b *Bridge) connect(conn net.Conn, args map[string]string) bool {
+	from := b.session(args["ID"])
+	if from == nil {
+		io.WriteString(conn, "STREAM STATUS RESULT=INVALID_ID\n")
+		return false
+	}
+	var to *session
+	b.mutex.Lock()
+	for _, s := range b.sessions {
+		if s.dest.Base64() == args["DESTINATION"] {
+			to = s
+		}
+	}
+	b.mutex.Unlock()
+	if to == nil {
+		io.WriteString(conn, "STREAM STATUS RESULT=CANT_REACH_PEER\n")
+		return false
+	}
+	var peer net.Conn
+	select {
+	case peer = <-to.accepts:
+	case <-to.closed:
+		io.WriteString(conn, "STREAM STATUS RESULT=CANT_REACH_PEER\n")
+		return false
+	case <-time.After(b.Timeout):
+		io.WriteString(conn, "STREAM STATUS RESULT=TIMEOUT\n")
+		return false
+	}
+	if _, err := fmt.Fprintf(peer, "%s FROM_PORT=0 TO_PORT=0\n", from.dest.Base64()); err != nil {
+		peer.Close()
+		io.WriteString(conn, "STREAM STATUS RESULT=CANT_REACH_PEER\n")
+		return false
+	}
+	time.Sleep(acceptDelay)
+	io.WriteString(conn, "STREAM STATUS RESULT=OK\n")
+	go b.splice(conn, peer)
+	return true
+}
+
+func (b *Bridge) accept(conn net.Conn, args map[string]string) bool {
+	s := b.session(args["ID"])
+	if s == nil {
+		io.WriteString(conn, "STREAM STATUS RESULT=INVALID_ID\n")
+		return false
+	}
+	io.WriteString(conn, "STREAM STATUS RESULT=OK\n")
+	go func() {
+		select {
+		case s.accepts <- conn:
+		case <-s.closed:
+			b.forget(conn)
+			conn.Close()
+		case <-b.done:
+		}
+	}()
+	return true
+}
+
+// splice copies stream data both ways until either side hangs up.
+func (b *Bridge) splice(a, c net.Conn) {
+	done := make(chan struct{}, 2)
+	copier := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+	go copier(a, c)
+	go copier(c, a)
+	<-done
+	a.Close()
+	c.Close()
+	b.forget(a)
+	b.forget(c)
+}
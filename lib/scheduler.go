@@ -0,0 +1,268 @@
+package jump
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Schedule controls how often a peer is fetched and how failures are
+// retried.
+type Schedule struct {
+	// Interval is the time between successful fetches.
+	Interval time.Duration `yaml:"interval"`
+	// Jitter is the most that is randomly added to each interval, so that
+	// peers drift apart instead of being fetched in lockstep.
+	Jitter time.Duration `yaml:"jitter"`
+	// Timeout bounds a whole fetch, dialing and reading included.
+	Timeout time.Duration `yaml:"timeout"`
+	// Backoff is the delay before retrying after the first failure. It
+	// doubles with each consecutive failure up to MaxBackoff.
+	Backoff    time.Duration `yaml:"backoff"`
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+}
+
+// DefaultSchedule returns the schedule used when none is configured.
+func DefaultSchedule() Schedule {
+	return Schedule{
+		Interval:   time.Hour,
+		Jitter:     time.Minute * 10,
+		Timeout:    time.Minute * 2,
+		Backoff:    time.Minute,
+		MaxBackoff: time.Hour * 6,
+	}
+}
+
+// WithDefaults returns the schedule with its zero settings taken from d.
+func (s Schedule) WithDefaults(d Schedule) Schedule {
+	if s.Interval == 0 {
+		s.Interval = d.Interval
+	}
+	if s.Jitter == 0 {
+		s.Jitter = d.Jitter
+	}
+	if s.Timeout == 0 {
+		s.Timeout = d.Timeout
+	}
+	if s.Backoff == 0 {
+		s.Backoff = d.Backoff
+	}
+	if s.MaxBackoff == 0 {
+		s.MaxBackoff = d.MaxBackoff
+	}
+	return s
+}
+
+// Validate checks that none of the durations are negative.
+func (s Schedule) Validate() error {
+	if s.Interval < 0 || s.Jitter < 0 || s.Timeout < 0 || s.Backoff < 0 || s.MaxBackoff < 0 {
+		return fmt.Errorf("schedule durations must not be negative")
+	}
+	return nil
+}
+
+// retryDelay returns how long to wait after the given number of consecutive
+// failures.
+func (s Schedule) retryDelay(failures int) time.Duration {
+	delay := s.Backoff
+	for i := 1; i < failures && delay < s.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > s.MaxBackoff {
+		delay = s.MaxBackoff
+	}
+	return delay
+}
+
+// PeerStatus reports the state of a scheduled peer.
+type PeerStatus struct {
+	Next      time.Time
+	LastFetch time.Time
+	LastError error
+	Failures  int
+	Fetches   int
+	Running   bool
+}
+
+type scheduledPeer struct {
+	peer     *I2PJump
+	schedule Schedule
+	status   PeerStatus
+}
+
+// Scheduler fetches each peer on its own schedule, running at most Workers
+// fetches at once.
+type Scheduler struct {
+	Workers int
+	mutex   sync.Mutex
+	peers   map[string]*scheduledPeer
+	active  int
+	wake    chan struct{}
+	running sync.WaitGroup
+	// fetching holds the names of the peers being fetched, so that a peer
+	// replaced during a fetch is not fetched again until it is over.
+	fetching map[string]bool
+}
+
+// NewScheduler returns a scheduler which runs at most workers fetches at once.
+func NewScheduler(workers int) *Scheduler {
+	return &Scheduler{
+		Workers:  workers,
+		peers:    make(map[string]*scheduledPeer),
+		fetching: make(map[string]bool),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+func (s *Scheduler) poke() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Set schedules peer, replacing any peer of the same name. A peer which is
+// already scheduled keeps its state and only has its schedule updated, a new
+// one is fetched as soon as a worker is free and any fetch of the peer it
+// replaces is over.
+func (s *Scheduler) Set(peer *I2PJump, schedule Schedule) {
+	schedule = schedule.WithDefaults(DefaultSchedule())
+	s.mutex.Lock()
+	if sp, ok := s.peers[peer.Name]; ok && sp.peer == peer {
+		sp.schedule = schedule
+	} else {
+		s.peers[peer.Name] = &scheduledPeer{
+			peer:     peer,
+			schedule: schedule,
+			status:   PeerStatus{Next: time.Now()},
+		}
+	}
+	s.mutex.Unlock()
+	s.poke()
+}
+
+// Remove stops fetching the named peer.
+func (s *Scheduler) Remove(name string) {
+	s.mutex.Lock()
+	delete(s.peers, name)
+	s.mutex.Unlock()
+	s.poke()
+}
+
+// SetWorkers changes how many fetches may run at once.
+func (s *Scheduler) SetWorkers(workers int) {
+	s.mutex.Lock()
+	s.Workers = workers
+	s.mutex.Unlock()
+	s.poke()
+}
+
+// FetchAll makes every peer due immediately.
+func (s *Scheduler) FetchAll() {
+	s.mutex.Lock()
+	now := time.Now()
+	for _, sp := range s.peers {
+		sp.status.Next = now
+	}
+	s.mutex.Unlock()
+	s.poke()
+}
+
+// Status returns the state of the named peer.
+func (s *Scheduler) Status(name string) (PeerStatus, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sp, ok := s.peers[name]
+	if !ok {
+		return PeerStatus{}, false
+	}
+	return sp.status, true
+}
+
+// Run fetches peers as they become due until ctx is done, then waits for
+// the fetches it started to give up.
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		timer := time.NewTimer(s.dispatch(ctx))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			s.running.Wait()
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// dispatch starts the fetches which are due, as far as the worker limit
+// allows, and returns how long to wait until the next one is due.
+func (s *Scheduler) dispatch(ctx context.Context) time.Duration {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	wait := time.Hour
+	var due []*scheduledPeer
+	for _, sp := range s.peers {
+		if sp.status.Running || s.fetching[sp.peer.Name] {
+			continue
+		}
+		if !sp.status.Next.After(now) {
+			due = append(due, sp)
+		} else if d := sp.status.Next.Sub(now); d < wait {
+			wait = d
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].status.Next.Before(due[j].status.Next)
+	})
+	for _, sp := range due {
+		if s.active >= s.Workers {
+			break
+		}
+		s.active++
+		s.fetching[sp.peer.Name] = true
+		sp.status.Running = true
+		s.running.Add(1)
+		go s.fetch(ctx, sp)
+	}
+	return wait
+}
+
+func (s *Scheduler) fetch(ctx context.Context, sp *scheduledPeer) {
+	defer s.running.Done()
+	s.mutex.Lock()
+	timeout := sp.schedule.Timeout
+	s.mutex.Unlock()
+	fctx, cancel := context.WithTimeout(ctx, timeout)
+	err := sp.peer.FetchContext(fctx)
+	cancel()
+
+	s.mutex.Lock()
+	s.active--
+	delete(s.fetching, sp.peer.Name)
+	sp.status.Running = false
+	sp.status.LastFetch = time.Now()
+	sp.status.LastError = err
+	sp.status.Fetches++
+	if err != nil {
+		sp.status.Failures++
+		delay := sp.schedule.retryDelay(sp.status.Failures)
+		sp.status.Next = sp.status.LastFetch.Add(delay)
+		log.Printf("Error fetching peer hosts.txt: %s %s, retrying in %s", sp.peer.Name, err, delay)
+	} else {
+		sp.status.Failures = 0
+		next := sp.schedule.Interval
+		if sp.schedule.Jitter > 0 {
+			next += time.Duration(rand.Int63n(int64(sp.schedule.Jitter)))
+		}
+		sp.status.Next = sp.status.LastFetch.Add(next)
+	}
+	s.mutex.Unlock()
+	s.poke()
+}
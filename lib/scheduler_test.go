@@ -0,0 +1,192 @@
+package jump
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitFetched waits until the scheduler has finished fetching the named peer
+// at least once, and fails the test if that fetch failed.
+func waitFetched(t *testing.T, s *Scheduler, name string) PeerStatus {
+	deadline := time.Now().Add(time.Second * 10)
+	for {
+		status, ok := s.Status(name)
+		if ok && status.Fetches > 0 {
+			if status.LastError != nil {
+				t.Fatalf("fetching %s failed: %s", name, status.LastError)
+			}
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s to be fetched", name)
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+}
+
+// tcpPeer returns a peer which fetches its hosts file from srv.
+func tcpPeer(t *testing.T, srv *httptest.Server) *I2PJump {
+	name := uniqueName("scheduled")
+	j, err := NewI2PJump("", "", name, "http://"+name+".i2p/hosts.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Transport = NewTCPTransport("", map[string]string{name + ".i2p": srv.Listener.Addr().String()})
+	return j
+}
+
+func runScheduler(t *testing.T, workers int) *Scheduler {
+	s := NewScheduler(workers)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go s.Run(ctx)
+	return s
+}
+
+func TestSchedulerWorkers(t *testing.T) {
+	dest := newDest(t)
+	var mutex sync.Mutex
+	running, most := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rq *http.Request) {
+		mutex.Lock()
+		running++
+		if running > most {
+			most = running
+		}
+		mutex.Unlock()
+		time.Sleep(time.Millisecond * 100)
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+		rw.Write([]byte("worker.i2p=" + dest.Base64() + "\n"))
+	}))
+	defer srv.Close()
+	s := runScheduler(t, 2)
+	var peers []*I2PJump
+	for i := 0; i < 5; i++ {
+		peer := tcpPeer(t, srv)
+		peers = append(peers, peer)
+		s.Set(peer, Schedule{Interval: time.Hour})
+	}
+	for _, peer := range peers {
+		waitFetched(t, s, peer.Name)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if most > 2 {
+		t.Errorf("%d fetches ran at once with 2 workers", most)
+	}
+}
+
+func TestSchedulerInterval(t *testing.T) {
+	dest := newDest(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rq *http.Request) {
+		rw.Write([]byte("often.i2p=" + dest.Base64() + "\n"))
+	}))
+	defer srv.Close()
+	s := runScheduler(t, 1)
+	peer := tcpPeer(t, srv)
+	s.Set(peer, Schedule{Interval: time.Millisecond * 50, Jitter: time.Millisecond})
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		if status, _ := s.Status(peer.Name); status.Fetches >= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("peer was not fetched again after its interval")
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+}
+
+func TestSchedulerTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rq *http.Request) {
+		select {
+		case <-rq.Context().Done():
+		case <-release:
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+	s := runScheduler(t, 1)
+	peer := tcpPeer(t, srv)
+	s.Set(peer, Schedule{Timeout: time.Millisecond * 200, Backoff: time.Hour})
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		status, _ := s.Status(peer.Name)
+		if status.Fetches > 0 {
+			if status.LastError == nil || status.Failures != 1 {
+				t.Errorf("hung fetch was not counted as a failure: %+v", status)
+			}
+			if wait := time.Until(status.Next); wait < time.Minute*59 {
+				t.Errorf("failed peer is retried after %s instead of backing off", wait)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("hung fetch did not time out")
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	s := Schedule{Backoff: time.Minute, MaxBackoff: time.Minute * 5}
+	for _, c := range []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, time.Minute * 2},
+		{3, time.Minute * 4},
+		{4, time.Minute * 5},
+		{40, time.Minute * 5},
+	} {
+		if got := s.retryDelay(c.failures); got != c.want {
+			t.Errorf("retry delay after %d failures: %s, want %s", c.failures, got, c.want)
+		}
+	}
+}
+
+func TestSchedulerReplaceDuringFetch(t *testing.T) {
+	dest := newDest(t)
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rq *http.Request) {
+		started <- struct{}{}
+		<-release
+		rw.Write([]byte("replaced.i2p=" + dest.Base64() + "\n"))
+	}))
+	defer srv.Close()
+	var releasing sync.Once
+	releaseAll := func() { releasing.Do(func() { close(release) }) }
+	defer releaseAll()
+	s := runScheduler(t, 2)
+	old := tcpPeer(t, srv)
+	s.Set(old, Schedule{Interval: time.Hour})
+	<-started
+
+	replacement, err := NewI2PJump("", "", old.Name, old.MyURL.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	replacement.Transport = old.Transport
+	s.Set(replacement, Schedule{Interval: time.Hour})
+	select {
+	case <-started:
+		t.Fatal("replacement was fetched while the peer it replaced was")
+	case <-time.After(time.Millisecond * 200):
+	}
+	releaseAll()
+	select {
+	case <-started:
+	case <-time.After(time.Second * 5):
+		t.Fatal("replacement was not fetched after the peer it replaced")
+	}
+	waitFetched(t, s, replacement.Name)
+}
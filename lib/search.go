@@ -0,0 +1,84 @@
+package jump
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"i2pgit.org/idk/jump-transparency/lib/naming"
+)
+
+// maxSearchResults bounds how many hostnames a search lists.
+const maxSearchResults = 100
+
+var search_template = template.Must(template.New("search").Parse(`<html>
+<head>
+</head>
+<body>
+  <style>
+  body {
+    font-family: monospace;
+    font-size: large;
+  }
+  </style>
+  <h1>Search: {{ .Name }}</h1>
+  <form action="/search" method="get">
+    <input type="text" name="q" value="{{.Query}}">
+    <button type="submit">Search</button>
+  </form>
+  {{range .Results}}
+  <div><a href="/jump?a={{.Host}}">{{.Display}}</a></div>
+  {{else}}{{if .Query}}
+  <div>No hostname registered here contains {{.Query}}.</div>
+  {{end}}{{end}}
+</body>
+</html>
+`))
+
+// searchResult is a hostname as the search page shows it.
+type searchResult struct {
+	Host    string
+	Display string
+}
+
+// Search returns our hostnames which contain query, written either in
+// Unicode or in the stored Punycode form, sorted.
+func (ws *WebServer) Search(query string) []string {
+	query = strings.ToLower(strings.TrimSpace(query))
+	if query == "" {
+		return nil
+	}
+	ascii := naming.Normalize(query)
+	var found []string
+	for host := range ws.Me.ToMap() {
+		if strings.Contains(host, ascii) || strings.Contains(naming.ToUnicode(host), query) {
+			found = append(found, host)
+		}
+	}
+	sort.Strings(found)
+	if len(found) > maxSearchResults {
+		found = found[:maxSearchResults]
+	}
+	return found
+}
+
+// serveSearch renders the search page, showing each hostname found in its
+// Unicode form.
+func (ws *WebServer) serveSearch(rw http.ResponseWriter, rq *http.Request) {
+	query := rq.URL.Query().Get("q")
+	var results []searchResult
+	for _, host := range ws.Search(query) {
+		results = append(results, searchResult{host, naming.Display(host)})
+	}
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := search_template.Execute(rw, struct {
+		Name    string
+		Query   string
+		Results []searchResult
+	}{ws.Me.Name, query, results})
+	if err != nil {
+		log.Printf("Template execution error, %s", err)
+	}
+}
@@ -0,0 +1,327 @@
+package jump
+
+import (
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"math/big"
+	"strings"
+
+	"github.com/eyedeekay/sam3/i2pkeys"
+)
+
+// Signature types, as numbered in the I2P common structures specification.
+const (
+	SigTypeDSA_SHA1              = 0
+	SigTypeECDSA_SHA256_P256     = 1
+	SigTypeECDSA_SHA384_P384     = 2
+	SigTypeECDSA_SHA512_P521     = 3
+	SigTypeEdDSA_SHA512_Ed25519  = 7
+	destinationPublicKeyLength   = 256
+	destinationSigningKeyLength  = 128
+	destinationCertificateOffset = destinationPublicKeyLength + destinationSigningKeyLength
+	certificateTypeNull          = 0
+	certificateTypeKey           = 5
+	cryptoTypeElGamal            = 0
+	cryptoTypeX25519             = 4
+)
+
+// dsaParameters are the fixed DSA group every DSA_SHA1 destination signs in,
+// from the I2P cryptography specification.
+var dsaParameters = dsa.Parameters{
+	P: hexInt("9C05B2AA960D9B97B8931963C9CC9E8C3026E9B8ED92FAD0A69CC886D5BF8015" +
+		"FCADAE31A0AD18FAB3F01B00A358DE237655C4964AFAA2B337E96AD316B9FB1C" +
+		"C564B5AEC5B69A9FF6C3E4548707FEF8503D91DD8602E867E6D35D2235C1869C" +
+		"E2479C3B9D5401DE04E0727FB33D6511285D4CF29538D9E3B6051F5B22CC1C93"),
+	Q: hexInt("A5DFC28FEF4CA1E286744CD8EED9D29D684046B7"),
+	G: hexInt("0C1F4D27D40093B429E962D7223824E0BBC47E7C832A39236FC683AF84889581" +
+		"075FF9082ED32353D4374D7301CDA1D23C431F4698599DDA02451824FF369752" +
+		"593647CC3DDC197DE985E43D136CDCFC6BD5409CD2F450821142A5E6F8EB1C3A" +
+		"B5D0484B8129FCF17BCE4F7F33321C3CB3DBB14A905E7B2B3E93BE4708CBCC82"),
+}
+
+func hexInt(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("invalid hex constant " + s)
+	}
+	return n
+}
+
+// I2PBase64 is the modified Base64 alphabet used by I2P for destinations and
+// signatures.
+var I2PBase64 = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-~")
+
+// padBase64 adds the padding some hosts files leave off their destinations.
+func padBase64(s string) string {
+	if n := len(s) % 4; n != 0 {
+		s += strings.Repeat("=", 4-n)
+	}
+	return s
+}
+
+// decodeDestination decodes a Base64 destination, padded or not.
+func decodeDestination(dest string) ([]byte, error) {
+	return I2PBase64.DecodeString(padBase64(dest))
+}
+
+// ParseDestination parses a Base64 destination, padded or not.
+func ParseDestination(dest string) (i2pkeys.I2PAddr, error) {
+	return i2pkeys.NewI2PAddrFromString(padBase64(dest))
+}
+
+// signingKeyLength returns the length of the signing public key for a given
+// signature type, or 0 if the type is unknown.
+func signingKeyLength(sigtype int) int {
+	switch sigtype {
+	case SigTypeDSA_SHA1:
+		return 128
+	case SigTypeECDSA_SHA256_P256:
+		return 64
+	case SigTypeECDSA_SHA384_P384:
+		return 96
+	case SigTypeECDSA_SHA512_P521:
+		return 132
+	case SigTypeEdDSA_SHA512_Ed25519:
+		return 32
+	}
+	return 0
+}
+
+// signingPrivateKeyLength returns the length of the signing private key for
+// a given signature type, or 0 if the type is unknown.
+func signingPrivateKeyLength(sigtype int) int {
+	switch sigtype {
+	case SigTypeDSA_SHA1:
+		return 20
+	case SigTypeECDSA_SHA256_P256:
+		return 32
+	case SigTypeECDSA_SHA384_P384:
+		return 48
+	case SigTypeECDSA_SHA512_P521:
+		return 66
+	case SigTypeEdDSA_SHA512_Ed25519:
+		return 32
+	}
+	return 0
+}
+
+// SigningPublicKey extracts the signature type and the raw signing public key
+// from a full Base64 destination.
+func SigningPublicKey(addr i2pkeys.I2PAddr) (int, []byte, error) {
+	dest, err := decodeDestination(string(addr))
+	if err != nil {
+		return 0, nil, fmt.Errorf("destination is not valid I2P Base64: %s", err)
+	}
+	if len(dest) < destinationCertificateOffset+3 {
+		return 0, nil, fmt.Errorf("destination too short: %d bytes", len(dest))
+	}
+	certType := dest[destinationCertificateOffset]
+	certLen := int(binary.BigEndian.Uint16(dest[destinationCertificateOffset+1:]))
+	payload := dest[destinationCertificateOffset+3:]
+	if len(payload) < certLen {
+		return 0, nil, fmt.Errorf("destination certificate truncated")
+	}
+	payload = payload[:certLen]
+	sigtype := SigTypeDSA_SHA1
+	switch certType {
+	case certificateTypeNull:
+	case certificateTypeKey:
+		if certLen < 4 {
+			return 0, nil, fmt.Errorf("key certificate too short: %d bytes", certLen)
+		}
+		sigtype = int(binary.BigEndian.Uint16(payload))
+	default:
+		return 0, nil, fmt.Errorf("unsupported certificate type %d", certType)
+	}
+	keylen := signingKeyLength(sigtype)
+	if keylen == 0 {
+		return sigtype, nil, fmt.Errorf("unsupported signature type %d", sigtype)
+	}
+	if keylen <= destinationSigningKeyLength {
+		return sigtype, dest[destinationCertificateOffset-keylen : destinationCertificateOffset], nil
+	}
+	excess := keylen - destinationSigningKeyLength
+	if len(payload) < 4+excess {
+		return sigtype, nil, fmt.Errorf("key certificate missing %d excess signing key bytes", excess)
+	}
+	key := append([]byte{}, dest[destinationPublicKeyLength:destinationCertificateOffset]...)
+	return sigtype, append(key, payload[4:4+excess]...), nil
+}
+
+// ValidateDestination checks that dest is a well-formed Base64 destination:
+// that it decodes, that its certificate accounts for exactly the bytes after
+// the keys, and that its signing key type is one we know.
+func ValidateDestination(dest string) error {
+	raw, err := decodeDestination(dest)
+	if err != nil {
+		return fmt.Errorf("destination is not valid I2P Base64: %s", err)
+	}
+	if len(raw) < destinationCertificateOffset+3 {
+		return fmt.Errorf("destination too short: %d bytes", len(raw))
+	}
+	certLen := int(binary.BigEndian.Uint16(raw[destinationCertificateOffset+1:]))
+	if len(raw) != destinationCertificateOffset+3+certLen {
+		return fmt.Errorf("destination is %d bytes but its certificate says %d", len(raw), destinationCertificateOffset+3+certLen)
+	}
+	_, _, err = SigningPublicKey(i2pkeys.I2PAddr(dest))
+	return err
+}
+
+// VerifyDestinationSignature checks that signature, an I2P Base64 encoded
+// signature, was made over message by the signing key of the destination addr.
+func VerifyDestinationSignature(addr i2pkeys.I2PAddr, message []byte, signature string) error {
+	sig, err := I2PBase64.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("signature is not base64-encoded: %s", err)
+	}
+	sigtype, key, err := SigningPublicKey(addr)
+	if err != nil {
+		return err
+	}
+	switch sigtype {
+	case SigTypeEdDSA_SHA512_Ed25519:
+		if len(sig) != ed25519.SignatureSize {
+			return fmt.Errorf("invalid Ed25519 signature length %d", len(sig))
+		}
+		if !ed25519.Verify(ed25519.PublicKey(key), message, sig) {
+			return fmt.Errorf("signature verification failed")
+		}
+		return nil
+	case SigTypeDSA_SHA1:
+		return verifyDSA(key, message, sig)
+	case SigTypeECDSA_SHA256_P256:
+		return verifyECDSA(elliptic.P256(), sha256.New(), key, message, sig)
+	case SigTypeECDSA_SHA384_P384:
+		return verifyECDSA(elliptic.P384(), sha512.New384(), key, message, sig)
+	case SigTypeECDSA_SHA512_P521:
+		return verifyECDSA(elliptic.P521(), sha512.New(), key, message, sig)
+	}
+	return fmt.Errorf("signature type %d is not supported for verification", sigtype)
+}
+
+// verifyDSA checks a DSA_SHA1 signature, the 20 byte r followed by the 20 byte
+// s, made by the signing key of an old style destination.
+func verifyDSA(key, message, sig []byte) error {
+	if len(sig) != 40 {
+		return fmt.Errorf("invalid DSA signature length %d", len(sig))
+	}
+	pub := dsa.PublicKey{Parameters: dsaParameters, Y: new(big.Int).SetBytes(key)}
+	digest := sha1.Sum(message)
+	r := new(big.Int).SetBytes(sig[:20])
+	s := new(big.Int).SetBytes(sig[20:])
+	if !dsa.Verify(&pub, digest[:], r, s) {
+		return fmt.Errorf("signature verification failed")
+	}
+	return nil
+}
+
+func verifyECDSA(curve elliptic.Curve, h hash.Hash, key, message, sig []byte) error {
+	half := len(key) / 2
+	if len(sig) != len(key) {
+		return fmt.Errorf("invalid ECDSA signature length %d", len(sig))
+	}
+	pub := ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(key[:half]),
+		Y:     new(big.Int).SetBytes(key[half:]),
+	}
+	h.Write(message)
+	r := new(big.Int).SetBytes(sig[:half])
+	s := new(big.Int).SetBytes(sig[half:])
+	if !ecdsa.Verify(&pub, h.Sum(nil), r, s) {
+		return fmt.Errorf("signature verification failed")
+	}
+	return nil
+}
+
+// fixed returns n as a big-endian number of exactly size bytes.
+func fixed(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	return append(make([]byte, size-len(b)), b...)
+}
+
+// SignWithKeys signs message with the signing private key in keys, a
+// destination's private keys as SAM hands them out, and returns the
+// signature in I2P Base64.
+func SignWithKeys(keys i2pkeys.I2PKeys, message []byte) (string, error) {
+	raw, err := decodeDestination(keys.String())
+	if err != nil {
+		return "", fmt.Errorf("private keys are not valid I2P Base64: %s", err)
+	}
+	if len(raw) < destinationCertificateOffset+3 {
+		return "", fmt.Errorf("private keys too short: %d bytes", len(raw))
+	}
+	certLen := int(binary.BigEndian.Uint16(raw[destinationCertificateOffset+1:]))
+	destLen := destinationCertificateOffset + 3 + certLen
+	if len(raw) < destLen {
+		return "", fmt.Errorf("private keys truncated")
+	}
+	sigtype, _, err := SigningPublicKey(i2pkeys.I2PAddr(I2PBase64.EncodeToString(raw[:destLen])))
+	if err != nil {
+		return "", err
+	}
+	// The encryption private key comes between the destination and the
+	// signing private key, and its length depends on the crypto type.
+	cryptoType := cryptoTypeElGamal
+	if raw[destinationCertificateOffset] == certificateTypeKey {
+		cryptoType = int(binary.BigEndian.Uint16(raw[destinationCertificateOffset+5:]))
+	}
+	start := destLen
+	switch cryptoType {
+	case cryptoTypeElGamal:
+		start += 256
+	case cryptoTypeX25519:
+		start += 32
+	default:
+		return "", fmt.Errorf("unsupported crypto type %d", cryptoType)
+	}
+	end := start + signingPrivateKeyLength(sigtype)
+	if len(raw) < end {
+		return "", fmt.Errorf("private keys missing the signing private key")
+	}
+	key := raw[start:end]
+	var sig []byte
+	switch sigtype {
+	case SigTypeEdDSA_SHA512_Ed25519:
+		sig = ed25519.Sign(ed25519.NewKeyFromSeed(key), message)
+	case SigTypeDSA_SHA1:
+		priv := dsa.PrivateKey{PublicKey: dsa.PublicKey{Parameters: dsaParameters}, X: new(big.Int).SetBytes(key)}
+		digest := sha1.Sum(message)
+		r, s, err := dsa.Sign(rand.Reader, &priv, digest[:])
+		if err != nil {
+			return "", err
+		}
+		sig = append(fixed(r, 20), fixed(s, 20)...)
+	case SigTypeECDSA_SHA256_P256:
+		sig, err = signECDSA(elliptic.P256(), sha256.New(), key, message)
+	case SigTypeECDSA_SHA384_P384:
+		sig, err = signECDSA(elliptic.P384(), sha512.New384(), key, message)
+	case SigTypeECDSA_SHA512_P521:
+		sig, err = signECDSA(elliptic.P521(), sha512.New(), key, message)
+	}
+	if err != nil {
+		return "", err
+	}
+	return I2PBase64.EncodeToString(sig), nil
+}
+
+func signECDSA(curve elliptic.Curve, h hash.Hash, key, message []byte) ([]byte, error) {
+	priv := ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: curve}, D: new(big.Int).SetBytes(key)}
+	priv.X, priv.Y = curve.ScalarBaseMult(key)
+	h.Write(message)
+	r, s, err := ecdsa.Sign(rand.Reader, &priv, h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return append(fixed(r, len(key)), fixed(s, len(key))...), nil
+}
@@ -0,0 +1,131 @@
+package jump
+
+import (
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/eyedeekay/sam3/i2pkeys"
+)
+
+// rawDest builds a destination with the signing public key key, laid out in
+// front of the certificate the way I2P does, and random encryption key bytes.
+func rawDest(t *testing.T, key, cert []byte) i2pkeys.I2PAddr {
+	dest := make([]byte, destinationCertificateOffset)
+	if _, err := rand.Read(dest); err != nil {
+		t.Fatal(err)
+	}
+	copy(dest[destinationCertificateOffset-len(key):], key)
+	return i2pkeys.I2PAddr(I2PBase64.EncodeToString(append(dest, cert...)))
+}
+
+func TestVerifyDestinationSignature(t *testing.T) {
+	message := []byte("example.i2p=dest#!date=1700000000")
+	signers := map[string]func() (i2pkeys.I2PAddr, string){
+		"DSA_SHA1": func() (i2pkeys.I2PAddr, string) {
+			var priv dsa.PrivateKey
+			priv.Parameters = dsaParameters
+			if err := dsa.GenerateKey(&priv, rand.Reader); err != nil {
+				t.Fatal(err)
+			}
+			digest := sha1.Sum(message)
+			r, s, err := dsa.Sign(rand.Reader, &priv, digest[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			addr := rawDest(t, fixed(priv.Y, 128), []byte{certificateTypeNull, 0, 0})
+			return addr, I2PBase64.EncodeToString(append(fixed(r, 20), fixed(s, 20)...))
+		},
+		"ECDSA_SHA256_P256": func() (i2pkeys.I2PAddr, string) {
+			priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			digest := sha256.Sum256(message)
+			r, s, err := ecdsa.Sign(rand.Reader, priv, digest[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			key := append(fixed(priv.X, 32), fixed(priv.Y, 32)...)
+			addr := rawDest(t, key, []byte{certificateTypeKey, 0, 4, 0, SigTypeECDSA_SHA256_P256, 0, 0})
+			return addr, I2PBase64.EncodeToString(append(fixed(r, 32), fixed(s, 32)...))
+		},
+		"EdDSA_SHA512_Ed25519": func() (i2pkeys.I2PAddr, string) {
+			addr := newDest(t)
+			sig, err := bridge.Sign(addr, message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return addr, sig
+		},
+	}
+	for name, sign := range signers {
+		addr, sig := sign()
+		if err := ValidateDestination(string(addr)); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+		if err := VerifyDestinationSignature(addr, message, sig); err != nil {
+			t.Errorf("%s: %s", name, err)
+		}
+		if VerifyDestinationSignature(addr, append(message, '0'), sig) == nil {
+			t.Errorf("%s: signature over another message was verified", name)
+		}
+		if VerifyDestinationSignature(newDest(t), message, sig) == nil {
+			t.Errorf("%s: signature was verified against another destination", name)
+		}
+	}
+}
+
+func TestSignWithKeys(t *testing.T) {
+	message := []byte("jump.b32.i2p:announcer:1700000000")
+	var dsaKey dsa.PrivateKey
+	dsaKey.Parameters = dsaParameters
+	if err := dsa.GenerateKey(&dsaKey, rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p521, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The P-521 signing key is longer than the space for it in front of the
+	// certificate, and its last 4 bytes go into the key certificate.
+	p521Pub := append(fixed(p521.X, 66), fixed(p521.Y, 66)...)
+	elgamal := make([]byte, 256)
+	x25519 := make([]byte, 32)
+	keys := map[string]i2pkeys.I2PKeys{}
+	both := func(name string, addr i2pkeys.I2PAddr, private, signing []byte) {
+		raw, err := decodeDestination(string(addr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw = append(append(raw, private...), signing...)
+		keys[name] = i2pkeys.NewKeys(addr, I2PBase64.EncodeToString(raw))
+	}
+	both("DSA_SHA1", rawDest(t, fixed(dsaKey.Y, 128), []byte{certificateTypeNull, 0, 0}), elgamal, fixed(dsaKey.X, 20))
+	both("ECDSA_SHA384_P384", rawDest(t, append(fixed(p384.X, 48), fixed(p384.Y, 48)...), []byte{certificateTypeKey, 0, 4, 0, SigTypeECDSA_SHA384_P384, 0, cryptoTypeX25519}), x25519, fixed(p384.D, 48))
+	both("ECDSA_SHA512_P521", rawDest(t, p521Pub[:128], append([]byte{certificateTypeKey, 0, 8, 0, SigTypeECDSA_SHA512_P521, 0, 0}, p521Pub[128:]...)), elgamal, fixed(p521.D, 66))
+	if keys["EdDSA_SHA512_Ed25519"], err = bridge.NewKeys(); err != nil {
+		t.Fatal(err)
+	}
+	for name, k := range keys {
+		sig, err := SignWithKeys(k, message)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if err := VerifyDestinationSignature(k.Addr(), message, sig); err != nil {
+			t.Errorf("%s: signature does not verify: %s", name, err)
+		}
+	}
+	if _, err := SignWithKeys(i2pkeys.NewKeys(keys["DSA_SHA1"].Addr(), string(keys["DSA_SHA1"].Addr())), message); err == nil {
+		t.Error("signed with a destination and no private keys")
+	}
+}
@@ -0,0 +1,298 @@
+package jump
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strconv"
+	"time"
+)
+
+// The SU3 container is I2P's signed file format: a header naming the
+// signature type, the version and the signer, then the content, then a
+// signature over everything before it.
+const (
+	su3Magic      = "I2Psu3"
+	su3HeaderSize = 40
+	// su3MinVersion is the shortest the version field may be; shorter
+	// versions are padded with zeros.
+	su3MinVersion = 16
+)
+
+// SU3 signature types, as numbered by I2P.
+const (
+	SU3RSASHA256   = 4
+	SU3RSASHA384   = 5
+	SU3RSASHA512   = 6
+	SU3EdDSASHA512 = 7
+)
+
+// SU3 file and content types used for hosts feeds.
+const (
+	SU3FileTxtGz      = 4
+	SU3ContentUnknown = 0
+)
+
+// SU3File is a parsed SU3 container.
+type SU3File struct {
+	SigType     uint16
+	Version     string
+	SignerID    string
+	FileType    byte
+	ContentType byte
+	Content     []byte
+	Signature   []byte
+	// signed is everything the signature covers.
+	signed []byte
+}
+
+// ParseSU3 parses an SU3 container without verifying it.
+func ParseSU3(data []byte) (*SU3File, error) {
+	if len(data) < su3HeaderSize || string(data[:len(su3Magic)]) != su3Magic {
+		return nil, fmt.Errorf("not an SU3 file")
+	}
+	if data[7] != 0 {
+		return nil, fmt.Errorf("unknown SU3 format version %d", data[7])
+	}
+	sigLen := int(binary.BigEndian.Uint16(data[10:]))
+	versionLen := int(data[13])
+	signerLen := int(data[15])
+	contentLen := binary.BigEndian.Uint64(data[16:])
+	end := uint64(su3HeaderSize + versionLen + signerLen)
+	if contentLen > uint64(len(data)) || end+contentLen+uint64(sigLen) != uint64(len(data)) {
+		return nil, fmt.Errorf("SU3 file is %d bytes, its header says %d", len(data), end+contentLen+uint64(sigLen))
+	}
+	f := &SU3File{
+		SigType:     binary.BigEndian.Uint16(data[8:]),
+		Version:     string(bytes.TrimRight(data[su3HeaderSize:su3HeaderSize+versionLen], "\x00")),
+		SignerID:    string(data[su3HeaderSize+versionLen : end]),
+		FileType:    data[25],
+		ContentType: data[27],
+		Content:     data[end : end+contentLen],
+		Signature:   data[end+contentLen:],
+		signed:      data[:end+contentLen],
+	}
+	return f, nil
+}
+
+// Verify checks the signature with the key pinned for the signer of the
+// file.
+func (f *SU3File) Verify(signers map[string]crypto.PublicKey) error {
+	key, ok := signers[f.SignerID]
+	if !ok {
+		return fmt.Errorf("SU3 file is signed by %q, which is not a pinned signer", f.SignerID)
+	}
+	switch f.SigType {
+	case SU3EdDSASHA512:
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("pinned key of %s is not an Ed25519 key", f.SignerID)
+		}
+		digest := sha512.Sum512(f.signed)
+		if !ed25519.Verify(pub, digest[:], f.Signature) {
+			return fmt.Errorf("invalid SU3 signature by %s", f.SignerID)
+		}
+		return nil
+	case SU3RSASHA256, SU3RSASHA384, SU3RSASHA512:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("pinned key of %s is not an RSA key", f.SignerID)
+		}
+		hash, digest := su3Digest(f.SigType, f.signed)
+		if err := rsa.VerifyPKCS1v15(pub, hash, digest, f.Signature); err != nil {
+			return fmt.Errorf("invalid SU3 signature by %s", f.SignerID)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported SU3 signature type %d", f.SigType)
+}
+
+func su3Digest(sigType uint16, data []byte) (crypto.Hash, []byte) {
+	switch sigType {
+	case SU3RSASHA256:
+		sum := sha256.Sum256(data)
+		return crypto.SHA256, sum[:]
+	case SU3RSASHA384:
+		sum := sha512.Sum384(data)
+		return crypto.SHA384, sum[:]
+	}
+	sum := sha512.Sum512(data)
+	return crypto.SHA512, sum[:]
+}
+
+// Data returns the content, decompressed if the file type says it is
+// gzipped. No more than limit bytes are returned.
+func (f *SU3File) Data(limit int64) ([]byte, error) {
+	var r io.Reader = bytes.NewReader(f.Content)
+	if f.FileType == SU3FileTxtGz {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("SU3 content is larger than %d bytes", limit)
+	}
+	return data, nil
+}
+
+// SU3Signer is the operator key the hosts feeds are signed with, and the
+// self-signed certificate peers pin it by.
+type SU3Signer struct {
+	ID          string
+	Key         ed25519.PrivateKey
+	Certificate []byte
+}
+
+// LoadSU3Signer reads the signing key and certificate of signer id from
+// keyPath and certPath, creating both if the key does not exist yet.
+func LoadSU3Signer(id, keyPath, certPath string) (*SU3Signer, error) {
+	keyPEM, err := ioutil.ReadFile(keyPath)
+	if os.IsNotExist(err) {
+		return newSU3Signer(id, keyPath, certPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM key", keyPath)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", keyPath, err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: SU3 signing key is not an Ed25519 key", keyPath)
+	}
+	cert, err := ioutil.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := LoadSU3Certificates(certPath)
+	if err != nil {
+		return nil, err
+	}
+	if pub, ok := certs[id].(ed25519.PublicKey); !ok || !pub.Equal(edKey.Public()) {
+		return nil, fmt.Errorf("%s is not the certificate of %s for %s", certPath, id, keyPath)
+	}
+	return &SU3Signer{id, edKey, cert}, nil
+}
+
+func newSU3Signer(id, keyPath, certPath string) (*SU3Signer, error) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: id},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, key)
+	if err != nil {
+		return nil, err
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}), 0600); err != nil {
+		return nil, err
+	}
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(certPath, cert, 0644); err != nil {
+		return nil, err
+	}
+	return &SU3Signer{id, key, cert}, nil
+}
+
+// Sign wraps content, gzipped, in an SU3 container signed by s and versioned
+// with the time it was signed.
+func (s *SU3Signer) Sign(content []byte) ([]byte, error) {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	if _, err := w.Write(content); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	version := []byte(strconv.FormatInt(time.Now().Unix(), 10))
+	if len(version) < su3MinVersion {
+		version = append(version, make([]byte, su3MinVersion-len(version))...)
+	}
+	if len(s.ID) > 255 {
+		return nil, fmt.Errorf("SU3 signer ID is longer than 255 bytes")
+	}
+	header := make([]byte, su3HeaderSize)
+	copy(header, su3Magic)
+	binary.BigEndian.PutUint16(header[8:], SU3EdDSASHA512)
+	binary.BigEndian.PutUint16(header[10:], ed25519.SignatureSize)
+	header[13] = byte(len(version))
+	header[15] = byte(len(s.ID))
+	binary.BigEndian.PutUint64(header[16:], uint64(gz.Len()))
+	header[25] = SU3FileTxtGz
+	header[27] = SU3ContentUnknown
+	signed := append(append(append(header, version...), s.ID...), gz.Bytes()...)
+	digest := sha512.Sum512(signed)
+	return append(signed, ed25519.Sign(s.Key, digest[:])...), nil
+}
+
+// LoadSU3Certificates reads the PEM certificates in files and returns their
+// public keys by the signer ID in their common name.
+func LoadSU3Certificates(files ...string) (map[string]crypto.PublicKey, error) {
+	signers := make(map[string]crypto.PublicKey)
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", file, err)
+			}
+			signers[cert.Subject.CommonName] = cert.PublicKey
+		}
+	}
+	if len(signers) == 0 && len(files) > 0 {
+		return nil, fmt.Errorf("no certificates in %v", files)
+	}
+	return signers, nil
+}
@@ -0,0 +1,130 @@
+package jump
+
+import (
+	"crypto/ed25519"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/eyedeekay/sam3/i2pkeys"
+)
+
+func TestSU3(t *testing.T) {
+	dir := t.TempDir()
+	signer, err := LoadSU3Signer("operator@mail.i2p", filepath.Join(dir, "su3.key"), filepath.Join(dir, "su3.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := LoadSU3Signer("operator@mail.i2p", filepath.Join(dir, "su3.key"), filepath.Join(dir, "su3.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.Key.Equal(signer.Key) {
+		t.Error("signing key was not reloaded")
+	}
+	other, err := LoadSU3Signer("operator@mail.i2p", filepath.Join(dir, "other.key"), filepath.Join(dir, "other.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned, err := LoadSU3Certificates(filepath.Join(dir, "su3.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := signer.Sign([]byte("example.i2p=dest\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ParseSU3(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Verify(pinned); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := f.Data(1024); err != nil || string(content) != "example.i2p=dest\n" {
+		t.Errorf("unexpected content %q: %v", content, err)
+	}
+	forged, err := other.Sign([]byte("example.i2p=dest\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, err := ParseSU3(forged); err != nil || f.Verify(pinned) == nil {
+		t.Errorf("SU3 file signed by another key was verified: %v", err)
+	}
+	data[len(data)-ed25519.SignatureSize-1] ^= 1
+	if f, err := ParseSU3(data); err != nil || f.Verify(pinned) == nil {
+		t.Errorf("altered SU3 file was verified: %v", err)
+	}
+	if _, err := ParseSU3(data[:len(data)-1]); err == nil {
+		t.Error("truncated SU3 file was parsed")
+	}
+}
+
+func TestSU3Feed(t *testing.T) {
+	dest := newDest(t)
+	hosts := writeHosts(t, "hosts.txt", map[string]i2pkeys.I2PAddr{"signed.i2p": dest})
+	cfg := DefaultConfig()
+	cfg.Name = uniqueName("su3publisher")
+	cfg.HostsFile = hosts
+	cfg.LocalOnly = true
+	cfg.Local = "127.0.0.1:0"
+	cfg.SU3.Signer = "publisher@mail.i2p"
+	publisher, err := NewI2PServerFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(publisher.WebServer)
+	defer srv.Close()
+	rw := httptest.NewRecorder()
+	publisher.WebServer.ServeHTTP(rw, httptest.NewRequest("GET", "/su3-signer.crt", nil))
+	if !strings.Contains(rw.Body.String(), "BEGIN CERTIFICATE") {
+		t.Fatalf("signer certificate is not served: %s", rw.Body.String())
+	}
+	cert := filepath.Join(t.TempDir(), "publisher.crt")
+	if err := ioutil.WriteFile(cert, rw.Body.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wrong := filepath.Join(t.TempDir(), "wrong.crt")
+	if _, err := LoadSU3Signer("publisher@mail.i2p", filepath.Join(t.TempDir(), "wrong.key"), wrong); err != nil {
+		t.Fatal(err)
+	}
+
+	is, err := NewI2PServerFromTransport(uniqueName("su3subscriber"), writeHosts(t, "hosts.txt", nil), nil, NewTCPTransport("127.0.0.1:0", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		path, cert string
+		ok         bool
+	}{
+		{"/hosts.su3", cert, true},
+		{"/hosts.su3", wrong, false},
+		{"/hosts.txt", cert, false},
+		{"/hosts.su3", "", false},
+	} {
+		peer := PeerConfig{Name: uniqueName("su3peer"), URL: srv.URL + c.path}
+		if c.cert != "" {
+			peer.SU3Signers = []string{c.cert}
+		}
+		if err := is.UpdatePeers([]PeerConfig{peer}); err != nil {
+			t.Fatal(err)
+		}
+		err := is.Peers()[0].Fetch()
+		if c.ok && (err != nil || is.Peers()[0].ToMap()["signed.i2p"] != dest.Base64()) {
+			t.Errorf("fetching %s pinned to %s: %v", c.path, c.cert, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("fetching %s pinned to %s succeeded", c.path, c.cert)
+		}
+	}
+
+	rw = httptest.NewRecorder()
+	is.WebServer.ServeHTTP(rw, httptest.NewRequest("GET", "/hosts.su3", nil))
+	if rw.Code != http.StatusNotFound {
+		t.Errorf("SU3 feed served without a signer: %d", rw.Code)
+	}
+}
@@ -0,0 +1,187 @@
+package jump
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"i2pgit.org/idk/jump-transparency/lib/naming"
+)
+
+// States of a registration ticket.
+const (
+	TicketPending  = "pending"
+	TicketApproved = "approved"
+	TicketRejected = "rejected"
+)
+
+// Ticket is the receipt for a registration waiting for approval. Its ID is
+// the only thing needed to look up what became of the registration.
+type Ticket struct {
+	ID        string    `json:"id"`
+	Hostname  string    `json:"hostname"`
+	Status    string    `json:"status"`
+	Reason    string    `json:"reason,omitempty"`
+	Submitted time.Time `json:"submitted"`
+	Updated   time.Time `json:"updated"`
+}
+
+// Display returns the hostname as it is shown to people.
+func (t Ticket) Display() string {
+	return naming.Display(t.Hostname)
+}
+
+func (t Ticket) String() string {
+	reason := url.QueryEscape(t.Reason)
+	if reason == "" {
+		reason = "-"
+	}
+	return strings.Join([]string{t.ID, strconv.FormatInt(t.Submitted.Unix(), 10), strconv.FormatInt(t.Updated.Unix(), 10), t.Status, t.Hostname, reason}, " ")
+}
+
+// Tickets keeps the tickets issued for registrations, saved to a file
+// whenever one changes.
+type Tickets struct {
+	mutex   sync.RWMutex
+	path    string
+	tickets map[string]Ticket
+}
+
+// LoadTickets reads the tickets saved at path. A missing file is no tickets.
+func LoadTickets(path string) (*Tickets, error) {
+	ts := &Tickets{path: path, tickets: make(map[string]Ticket)}
+	lines, err := ReadHostsFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		fields := strings.Split(line, " ")
+		if len(fields) != 6 {
+			continue
+		}
+		submitted, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		updated, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		reason := ""
+		if fields[5] != "-" {
+			reason, _ = url.QueryUnescape(fields[5])
+		}
+		ts.tickets[fields[0]] = Ticket{fields[0], fields[4], fields[3], reason, time.Unix(submitted, 0), time.Unix(updated, 0)}
+	}
+	return ts, nil
+}
+
+// save writes the tickets to disk. The caller holds the lock.
+func (ts *Tickets) save() error {
+	if ts.path == "" {
+		return nil
+	}
+	var lines []string
+	for _, t := range ts.tickets {
+		lines = append(lines, t.String())
+	}
+	sort.Strings(lines)
+	return ioutil.WriteFile(ts.path, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+// Issue creates a pending ticket for a registration of hostname.
+func (ts *Tickets) Issue(hostname string) (Ticket, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return Ticket{}, err
+	}
+	now := time.Now()
+	t := Ticket{ID: hex.EncodeToString(id), Hostname: hostname, Status: TicketPending, Submitted: now, Updated: now}
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	ts.tickets[t.ID] = t
+	return t, ts.save()
+}
+
+// Resolve settles the pending tickets for hostname with status and the
+// moderator's reason.
+func (ts *Tickets) Resolve(hostname, status, reason string) error {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	for id, t := range ts.tickets {
+		if t.Hostname == hostname && t.Status == TicketPending {
+			t.Status, t.Reason, t.Updated = status, reason, time.Now()
+			ts.tickets[id] = t
+		}
+	}
+	return ts.save()
+}
+
+// Lookup returns the ticket with id.
+func (ts *Tickets) Lookup(id string) (Ticket, bool) {
+	ts.mutex.RLock()
+	defer ts.mutex.RUnlock()
+	t, ok := ts.tickets[id]
+	return t, ok
+}
+
+var status_template = template.Must(template.New("status").Parse(`<html>
+<head>
+</head>
+<body>
+  <style>
+  body {
+    font-family: monospace;
+    font-size: large;
+  }
+  </style>
+  <h1>Registration of {{.Display}}</h1>
+  <div>Ticket: <code>{{.ID}}</code></div>
+  <div>Submitted: {{.Submitted.UTC.Format "2006-01-02 15:04 MST"}}</div>
+  {{if eq .Status "pending"}}
+  <div>Waiting for approval by the administrator.</div>
+  {{else}}
+  <div>{{if eq .Status "approved"}}Approved{{else}}Rejected{{end}} on {{.Updated.UTC.Format "2006-01-02 15:04 MST"}}{{with .Reason}}: {{.}}{{end}}</div>
+  {{end}}
+</body>
+</html>
+`))
+
+// serveStatus answers /status/TICKET with a page showing what became of a
+// registration, and /status/TICKET.json with the ticket as JSON.
+func (ws *WebServer) serveStatus(rw http.ResponseWriter, rq *http.Request) {
+	id := strings.TrimPrefix(rq.URL.Path, "/status/")
+	asJSON := strings.HasSuffix(id, ".json")
+	ticket, ok := ws.Tickets.Lookup(strings.TrimSuffix(id, ".json"))
+	if !ok {
+		http.Error(rw, "No registration has this ticket", http.StatusNotFound)
+		return
+	}
+	if asJSON {
+		rw.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(rw).Encode(ticket); err != nil {
+			log.Printf("Encoding ticket %s: %s", ticket.ID, err)
+		}
+		return
+	}
+	rw.Header().Add("Content-Type", "text/html")
+	if err := status_template.Execute(rw, ticket); err != nil {
+		log.Printf("Template execution error, %s", err)
+	}
+}
+
+// statusPath returns where the status of a ticket can be looked up.
+func statusPath(id string) string {
+	return fmt.Sprintf("/status/%s", id)
+}
@@ -0,0 +1,70 @@
+package jump
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestTicketStatus(t *testing.T) {
+	dest := newDest(t)
+	name := uniqueName("tickets")
+	is, err := NewI2PServerFromTransport(name, writeHosts(t, "hosts.txt", nil), nil, NewTCPTransport("127.0.0.1:0", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tickets := make(map[string]string)
+	for _, host := range []string{"approved.i2p", "rejected.i2p", "waiting.i2p"} {
+		registration, err := is.Register(host, dest.Base64(), "", host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(registration.String(), statusPath(registration.Ticket.ID)) {
+			t.Errorf("submitter was not told where to look up the registration: %q", registration)
+		}
+		tickets[host] = registration.Ticket.ID
+	}
+	if rw := adminRequest(is, "POST", "/admin/approve", url.Values{"host_name": {"approved.i2p"}}); rw.Code != http.StatusSeeOther {
+		t.Fatalf("approving: %d %s", rw.Code, rw.Body.String())
+	}
+	if rw := adminRequest(is, "POST", "/admin/reject", url.Values{"host_name": {"rejected.i2p"}, "reason": {"impersonates a known site"}}); rw.Code != http.StatusSeeOther {
+		t.Fatalf("rejecting: %d %s", rw.Code, rw.Body.String())
+	}
+
+	for host, want := range map[string]Ticket{
+		"approved.i2p": {Status: TicketApproved},
+		"rejected.i2p": {Status: TicketRejected, Reason: "impersonates a known site"},
+		"waiting.i2p":  {Status: TicketPending},
+	} {
+		rw := httptest.NewRecorder()
+		is.WebServer.ServeHTTP(rw, httptest.NewRequest("GET", statusPath(tickets[host])+".json", nil))
+		var got Ticket
+		if err := json.NewDecoder(rw.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != tickets[host] || got.Hostname != host || got.Status != want.Status || got.Reason != want.Reason {
+			t.Errorf("status of %s: %+v, want %+v", host, got, want)
+		}
+	}
+	rw := httptest.NewRecorder()
+	is.WebServer.ServeHTTP(rw, httptest.NewRequest("GET", statusPath(tickets["rejected.i2p"]), nil))
+	if page := rw.Body.String(); !strings.Contains(page, "Rejected on ") || !strings.Contains(page, "impersonates a known site") {
+		t.Errorf("status page does not show the rejection: %s", page)
+	}
+	rw = httptest.NewRecorder()
+	is.WebServer.ServeHTTP(rw, httptest.NewRequest("GET", statusPath("unknown"), nil))
+	if rw.Code != http.StatusNotFound {
+		t.Errorf("unknown ticket: %d", rw.Code)
+	}
+
+	saved, err := LoadTickets(name + "-tickets.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := saved.Lookup(tickets["rejected.i2p"]); got.Status != TicketRejected || got.Reason != "impersonates a known site" {
+		t.Errorf("ticket after reloading: %+v", got)
+	}
+}
@@ -0,0 +1,134 @@
+package jump
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Who made a change recorded in the transparency log.
+const (
+	ByAdmin = "admin"
+	ByOwner = "owner"
+)
+
+// LogEntry is one change recorded in the transparency log. Hash covers the
+// entry and, through the hash of the entry before it, the whole log up to
+// it, so that entries cannot be altered or dropped unnoticed.
+type LogEntry struct {
+	Time     time.Time
+	Action   string
+	Hostname string
+	By       string
+	Reason   string
+	Hash     string
+}
+
+// body is the entry as it is written, without its hash.
+func (e LogEntry) body() string {
+	reason := url.QueryEscape(e.Reason)
+	if reason == "" {
+		reason = "-"
+	}
+	return strings.Join([]string{strconv.FormatInt(e.Time.Unix(), 10), e.Action, e.Hostname, e.By, reason}, " ")
+}
+
+func (e LogEntry) String() string {
+	return e.Hash + " " + e.body()
+}
+
+func chainHash(previous string, e LogEntry) string {
+	sum := sha256.Sum256([]byte(previous + " " + e.body()))
+	return hex.EncodeToString(sum[:])
+}
+
+// TransparencyLog is the public, append-only record of every change made to
+// our hosts file: what was done to which name, by the administrator or by
+// the owner of the name, and why.
+type TransparencyLog struct {
+	mutex   sync.RWMutex
+	path    string
+	entries []LogEntry
+}
+
+// LoadTransparencyLog reads the log saved at path, which it appends new
+// entries to. A missing file is an empty log.
+func LoadTransparencyLog(path string) (*TransparencyLog, error) {
+	l := &TransparencyLog{path: path}
+	lines, err := ReadHostsFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for n, line := range lines {
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, " ")
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("%s:%d: malformed transparency log entry", path, n+1)
+		}
+		unix, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, n+1, err)
+		}
+		reason := ""
+		if fields[5] != "-" {
+			if reason, err = url.QueryUnescape(fields[5]); err != nil {
+				return nil, fmt.Errorf("%s:%d: %s", path, n+1, err)
+			}
+		}
+		l.entries = append(l.entries, LogEntry{time.Unix(unix, 0), fields[2], fields[3], fields[4], reason, fields[0]})
+	}
+	return l, nil
+}
+
+// Append records a change and saves it.
+func (l *TransparencyLog) Append(action, hostname, by, reason string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	e := LogEntry{Time: time.Now(), Action: action, Hostname: hostname, By: by, Reason: reason}
+	previous := ""
+	if len(l.entries) > 0 {
+		previous = l.entries[len(l.entries)-1].Hash
+	}
+	e.Hash = chainHash(previous, e)
+	l.entries = append(l.entries, e)
+	if l.path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, e); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Entries returns the whole log, oldest first.
+func (l *TransparencyLog) Entries() []LogEntry {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return append([]LogEntry(nil), l.entries...)
+}
+
+// Verify checks the hash chain of the log, returning an error naming the
+// first entry which does not match it.
+func (l *TransparencyLog) Verify() error {
+	previous := ""
+	for n, e := range l.Entries() {
+		if chainHash(previous, e) != e.Hash {
+			return fmt.Errorf("transparency log entry %d has been altered", n+1)
+		}
+		previous = e.Hash
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package jump
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eyedeekay/sam3/i2pkeys"
+)
+
+func TestTransparencyLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transparency.txt")
+	l, err := LoadTransparencyLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Append(ActionAdd, "one.i2p", ByAdmin, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Append(ActionRemove, "one.i2p", ByAdmin, "phishing, reported twice"); err != nil {
+		t.Fatal(err)
+	}
+	l, err = LoadTransparencyLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := l.Entries()
+	if len(entries) != 2 || entries[1].Action != ActionRemove || entries[1].Reason != "phishing, reported twice" || entries[0].Reason != "" {
+		t.Fatalf("unexpected entries after reloading: %v", entries)
+	}
+	if err := l.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(strings.Replace(string(saved), "phishing", "spam", 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if l, err = LoadTransparencyLog(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Verify(); err == nil || !strings.Contains(err.Error(), "entry 2") {
+		t.Errorf("altered entry was not detected: %v", err)
+	}
+}
+
+func TestRemoval(t *testing.T) {
+	owned, banned, kept := newDest(t), newDest(t), newDest(t)
+	hosts := writeHosts(t, "hosts.txt", map[string]i2pkeys.I2PAddr{"owned.i2p": owned, "banned.i2p": banned, "kept.i2p": kept})
+	is, err := NewI2PServerFromTransport(uniqueName("removing"), hosts, nil, NewTCPTransport("127.0.0.1:0", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rw := adminRequest(is, "POST", "/admin/remove", url.Values{"host_name": {"Banned.i2p"}, "reason": {"phishing"}}); rw.Code != http.StatusSeeOther {
+		t.Fatalf("removing as the administrator: %d %s", rw.Code, rw.Body.String())
+	}
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	unsigned := "#!action=remove#date=" + now + "#dest=" + owned.Base64() + "#name=owned.i2p"
+	if _, err := is.Register("", unsigned, "", "someone"); err == nil || err.(*RegistrationError).Status != http.StatusForbidden {
+		t.Errorf("unsigned removal: %v", err)
+	}
+	signRemoval := func(date string) Host {
+		removal := Host{Host: "owned.i2p", Destination: owned.Base64(), Props: map[string]string{
+			PropAction: ActionRemove,
+			PropDate:   date,
+			PropDest:   owned.Base64(),
+			PropName:   "owned.i2p",
+		}}
+		sig, err := bridge.Sign(owned, []byte(removal.line(PropSig)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		removal.Props[PropSig] = sig
+		return removal
+	}
+	stale := signRemoval("1700000000")
+	if _, err := is.Register("", stale.line(), "", "owner"); err == nil {
+		t.Error("stale removal was accepted")
+	}
+	removal := signRemoval(now)
+	if !strings.HasPrefix(removal.line(), "#!action=remove#date=") {
+		t.Fatalf("removal is not a command line: %s", removal.line())
+	}
+	registration, err := is.Register("", removal.line(), "", "owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(registration.String(), "has been removed") {
+		t.Errorf("owner was told %q", registration)
+	}
+
+	if got := is.Me.ToMap(); len(got) != 1 || got["kept.i2p"] == "" {
+		t.Errorf("unexpected hosts after removals: %v", got)
+	}
+	written, err := ioutil.ReadFile(hosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(written), "banned.i2p") || strings.Contains(string(written), "owned.i2p") {
+		t.Errorf("removed names are still in the hosts file: %s", written)
+	}
+
+	rw := httptest.NewRecorder()
+	is.WebServer.ServeHTTP(rw, httptest.NewRequest("GET", "/hosts.txt", nil))
+	published := rw.Body.String()
+	if !strings.HasPrefix(published, "kept.i2p=") || !strings.Contains(published, "\n"+removal.line()+"\n") {
+		t.Errorf("published hosts file does not announce the owner's removal: %s", published)
+	}
+	if strings.Contains(published, "banned.i2p") {
+		t.Errorf("published hosts file announces an unsigned removal: %s", published)
+	}
+
+	rw = httptest.NewRecorder()
+	is.WebServer.ServeHTTP(rw, httptest.NewRequest("GET", "/transparency", nil))
+	log := rw.Body.String()
+	if !strings.Contains(log, " remove banned.i2p admin phishing\n") || !strings.Contains(log, " remove owned.i2p owner -\n") {
+		t.Errorf("transparency log does not record the removals: %s", log)
+	}
+	if events := is.History.Of("banned.i2p"); len(events) != 1 || events[0].Action() != ActionRemove {
+		t.Errorf("unexpected history of banned.i2p: %v", events)
+	}
+	if rw := adminRequest(is, "POST", "/admin/remove", url.Values{"host_name": {"banned.i2p"}}); rw.Code != http.StatusNotFound {
+		t.Errorf("removing a name twice: %d", rw.Code)
+	}
+
+	is.Me.Append("owned.i2p", owned.Base64(), "")
+	if err := is.History.Record(Host{Host: "owned.i2p", Destination: owned.Base64()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := is.Register("", removal.line(), "", "replayer"); err == nil || err.(*RegistrationError).Status != http.StatusConflict {
+		t.Errorf("replayed removal after the name was registered again: %v", err)
+	}
+}
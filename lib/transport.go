@@ -0,0 +1,398 @@
+package jump
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eyedeekay/sam3"
+	"github.com/eyedeekay/sam3/helper"
+	"github.com/eyedeekay/sam3/i2pkeys"
+)
+
+// Transport is the network a jump service listens on and reaches its peers
+// through. It lets the service run over I2P, or over plain TCP when it is
+// embedded in another program or tested against local HTTP servers.
+type Transport interface {
+	// Listen opens the listener the web interface is served on.
+	Listen() (net.Listener, error)
+	// Dial connects to host, which may be a hostname, a Base32 address or,
+	// on transports which use them, a host:port pair. It gives up when ctx
+	// is done.
+	Dial(ctx context.Context, host string) (net.Conn, error)
+	// Lookup resolves host to an address. Over I2P the address is the full
+	// destination, an i2pkeys.I2PAddr.
+	Lookup(host string) (net.Addr, error)
+	// Close closes every outbound connection the transport still has open,
+	// and makes later dials fail. Listeners are closed by their owners.
+	Close() error
+}
+
+// Resolver may be implemented by a Transport to say whether its Lookup
+// resolves I2P names to their destinations. Pinned peers are checked against
+// such a lookup before they are fetched, and dialed at their pinned address
+// without one on transports which say they do not. Transports which do not
+// implement it are assumed to resolve I2P names.
+type Resolver interface {
+	Resolves() bool
+}
+
+// Signer may be implemented by a Transport which holds the private keys of
+// the destination it listens on. Our announces are signed with them.
+type Signer interface {
+	// Sign returns the I2P Base64 signature over message by our
+	// destination's signing key.
+	Sign(message []byte) (string, error)
+}
+
+// SAMTransport reaches I2P through a SAM bridge. Every outbound connection
+// is dialed from one long-lived client session, which is created on first
+// use, checked periodically and recreated when the bridge has lost it.
+type SAMTransport struct {
+	Name     string
+	SAMAddr  string
+	KeysPath string
+	// HealthInterval is how often the client session is checked.
+	HealthInterval time.Duration
+	mutex          sync.Mutex
+	client         *sam3.StreamSession
+	keys           *i2pkeys.I2PKeys
+	conns          map[net.Conn]bool
+	watching       chan struct{}
+	closed         bool
+}
+
+// NewSAMTransport returns a transport which talks to the SAM bridge at samaddr.
+// The listener it opens uses the persistent keys stored at keyspath, outbound
+// connections use transient ones.
+func NewSAMTransport(name, samaddr, keyspath string) *SAMTransport {
+	return &SAMTransport{
+		Name:           name,
+		SAMAddr:        samaddr,
+		KeysPath:       keyspath,
+		HealthInterval: time.Minute,
+		conns:          make(map[net.Conn]bool),
+	}
+}
+
+func (t *SAMTransport) Listen() (net.Listener, error) {
+	return sam.I2PListener(t.Name, t.SAMAddr, t.KeysPath)
+}
+
+// Sign signs message with the keys the listener was opened with, which the
+// listener keeps next to KeysPath.
+func (t *SAMTransport) Sign(message []byte) (string, error) {
+	keys, err := i2pkeys.LoadKeys(t.KeysPath + ".i2p.private")
+	if err != nil {
+		return "", err
+	}
+	return SignWithKeys(keys, message)
+}
+
+func (t *SAMTransport) Lookup(host string) (net.Addr, error) {
+	addr, err := t.lookup(host)
+	if err != nil {
+		return nil, err
+	}
+	return addr, nil
+}
+
+// lookup resolves host over a plain control socket, since naming lookups do
+// not need a session's tunnels.
+func (t *SAMTransport) lookup(host string) (i2pkeys.I2PAddr, error) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	s, err := sam3.NewSAM(t.SAMAddr)
+	if err != nil {
+		return "", err
+	}
+	defer s.Close()
+	return s.Lookup(host)
+}
+
+// ClientID returns the ID of the client session on the bridge.
+func (t *SAMTransport) ClientID() string {
+	return t.Name + "-client"
+}
+
+// clientSession returns the client session, creating it if there is none.
+// The session keeps its destination when it is recreated.
+func (t *SAMTransport) clientSession() (*sam3.StreamSession, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.closed {
+		return nil, fmt.Errorf("%s: transport is closed", t.Name)
+	}
+	if t.client != nil {
+		return t.client, nil
+	}
+	s, err := sam3.NewSAM(t.SAMAddr)
+	if err != nil {
+		return nil, err
+	}
+	if t.keys == nil {
+		keys, err := s.NewKeys()
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		t.keys = &keys
+	}
+	session, err := s.NewStreamSession(t.ClientID(), *t.keys, sam3.Options_Medium)
+	if err != nil {
+		return nil, err
+	}
+	t.client = session
+	if t.watching == nil && t.HealthInterval > 0 {
+		t.watching = make(chan struct{})
+		go t.watch(t.watching)
+	}
+	return session, nil
+}
+
+// reset drops session if it is still the client session, so that the next
+// dial creates a new one.
+func (t *SAMTransport) reset(session *sam3.StreamSession) {
+	t.mutex.Lock()
+	if t.client == session {
+		t.client = nil
+	}
+	t.mutex.Unlock()
+	session.Close()
+}
+
+// Check verifies that the bridge still knows the client session, by asking
+// for a session with the same ID and destination. While the session is alive
+// the bridge refuses; if it has forgotten it, after a restart for instance,
+// the new session takes its place.
+func (t *SAMTransport) Check() error {
+	t.mutex.Lock()
+	session, keys := t.client, t.keys
+	t.mutex.Unlock()
+	if session == nil {
+		return nil
+	}
+	s, err := sam3.NewSAM(t.SAMAddr)
+	if err != nil {
+		t.reset(session)
+		return err
+	}
+	probe, err := s.NewStreamSession(session.ID(), *keys, sam3.Options_Medium)
+	if err != nil {
+		if err.Error() == "Duplicate tunnel name" || err.Error() == "Duplicate destination" {
+			return nil
+		}
+		t.reset(session)
+		return err
+	}
+	log.Printf("%s: client session was lost by the bridge, reconnected", t.Name)
+	t.mutex.Lock()
+	replaced := t.client == session && !t.closed
+	if replaced {
+		t.client = probe
+	}
+	t.mutex.Unlock()
+	if replaced {
+		session.Close()
+	} else {
+		probe.Close()
+	}
+	return nil
+}
+
+func (t *SAMTransport) watch(stop chan struct{}) {
+	ticker := time.NewTicker(t.HealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := t.Check(); err != nil {
+				log.Printf("%s: client session check failed: %s", t.Name, err)
+			}
+		}
+	}
+}
+
+// trackedConn removes itself from its transport's open connections when it
+// is closed.
+type trackedConn struct {
+	net.Conn
+	transport *SAMTransport
+}
+
+func (c *trackedConn) Close() error {
+	c.transport.mutex.Lock()
+	delete(c.transport.conns, c)
+	c.transport.mutex.Unlock()
+	return c.Conn.Close()
+}
+
+// Close closes every connection dialed through the transport which is still
+// open, and the client session.
+func (t *SAMTransport) Close() error {
+	t.mutex.Lock()
+	t.closed = true
+	client, conns := t.client, t.conns
+	t.client = nil
+	t.conns = make(map[net.Conn]bool)
+	if t.watching != nil {
+		close(t.watching)
+		t.watching = nil
+	}
+	t.mutex.Unlock()
+	for conn := range conns {
+		conn.(*trackedConn).Conn.Close()
+	}
+	if client != nil {
+		return client.Close()
+	}
+	return nil
+}
+
+func (t *SAMTransport) Dial(ctx context.Context, host string) (net.Conn, error) {
+	type dialed struct {
+		conn net.Conn
+		err  error
+	}
+	result := make(chan dialed, 1)
+	go func() {
+		conn, err := t.dial(host)
+		result <- dialed{conn, err}
+	}()
+	select {
+	case r := <-result:
+		return r.conn, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-result; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// peerError reports whether a failed STREAM CONNECT was the fault of the peer
+// rather than of the client session.
+func peerError(err error) bool {
+	switch err.Error() {
+	case "Can not reach peer", "Timeout", "Invalid key":
+		return true
+	}
+	return false
+}
+
+func (t *SAMTransport) dial(host string) (net.Conn, error) {
+	addr, err := t.lookup(host)
+	if err != nil {
+		return nil, err
+	}
+	for retried := false; ; retried = true {
+		session, err := t.clientSession()
+		if err != nil {
+			return nil, err
+		}
+		conn, err := session.DialI2P(addr)
+		if err == nil {
+			tracked := &trackedConn{Conn: conn, transport: t}
+			t.mutex.Lock()
+			closed := t.closed
+			if !closed {
+				t.conns[tracked] = true
+			}
+			t.mutex.Unlock()
+			if closed {
+				conn.Close()
+				return nil, fmt.Errorf("%s: transport is closed", t.Name)
+			}
+			return tracked, nil
+		}
+		if peerError(err) {
+			return nil, err
+		}
+		log.Printf("%s: dialing from the client session failed, reconnecting: %s", t.Name, err)
+		t.reset(session)
+		if retried {
+			return nil, err
+		}
+	}
+}
+
+// TCPTransport serves and fetches over plain TCP. Hostnames are resolved
+// through Hosts first, which maps names like peer.i2p to host:port pairs, and
+// otherwise through the system resolver on port 80. I2P names are never
+// passed to the system resolver, so that they do not leak to DNS.
+type TCPTransport struct {
+	Addr  string
+	Hosts map[string]string
+}
+
+// NewTCPTransport returns a transport which listens on addr and resolves the
+// names in hosts to the host:port pairs they map to.
+func NewTCPTransport(addr string, hosts map[string]string) *TCPTransport {
+	if hosts == nil {
+		hosts = make(map[string]string)
+	}
+	return &TCPTransport{
+		Addr:  addr,
+		Hosts: hosts,
+	}
+}
+
+func (t *TCPTransport) resolve(host string) (string, error) {
+	if mapped, ok := t.Hosts[host]; ok {
+		return mapped, nil
+	}
+	name, port, err := net.SplitHostPort(host)
+	if err == nil {
+		if mapped, ok := t.Hosts[name]; ok {
+			return mapped, nil
+		}
+	} else {
+		name, port = host, "80"
+	}
+	if strings.HasSuffix(strings.ToLower(strings.TrimSuffix(name, ".")), ".i2p") {
+		return "", fmt.Errorf("%s is an I2P name with no local mapping, refusing to look it up in DNS", name)
+	}
+	return net.JoinHostPort(name, port), nil
+}
+
+func (t *TCPTransport) Listen() (net.Listener, error) {
+	return net.Listen("tcp", t.Addr)
+}
+
+func (t *TCPTransport) Lookup(host string) (net.Addr, error) {
+	addr, err := t.resolve(host)
+	if err != nil {
+		return nil, err
+	}
+	return net.ResolveTCPAddr("tcp", addr)
+}
+
+func (t *TCPTransport) Dial(ctx context.Context, host string) (net.Conn, error) {
+	addr, err := t.resolve(host)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+// Resolves reports false: Lookup resolves names to host:port pairs, never to
+// I2P destinations.
+func (t *TCPTransport) Resolves() bool {
+	return false
+}
+
+// Close does nothing, as TCP connections do not hold on to anything beyond
+// themselves.
+func (t *TCPTransport) Close() error {
+	return nil
+}
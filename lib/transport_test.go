@@ -0,0 +1,144 @@
+package jump
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/eyedeekay/sam3/i2pkeys"
+)
+
+func TestTCPTransportFetch(t *testing.T) {
+	dest := newDest(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rq *http.Request) {
+		rw.Write([]byte("local.i2p=" + dest.Base64() + "\n"))
+	}))
+	defer srv.Close()
+	j, err := NewI2PJump("", "", uniqueName("tcppeer"), "http://tcppeer.i2p/hosts.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Transport = NewTCPTransport("", map[string]string{"tcppeer.i2p": srv.Listener.Addr().String()})
+	if err := j.Fetch(); err != nil {
+		t.Fatal(err)
+	}
+	if got := j.ToMap()["local.i2p"]; got != dest.Base64() {
+		t.Errorf("fetched the wrong destination for local.i2p: %q", got)
+	}
+	for _, host := range []string{"unmapped.i2p", "unmapped.i2p:80", "nytzrhrjjfsutowojvxi7hphesskpqqr65wpistz6wa7cpajhp7a.b32.i2p"} {
+		if _, err := j.Transport.Lookup(host); err == nil || !strings.Contains(err.Error(), "no local mapping") {
+			t.Errorf("%s was looked up outside the mapping: %v", host, err)
+		}
+	}
+}
+
+func TestTCPTransportServer(t *testing.T) {
+	dest := newDest(t)
+	hosts := writeHosts(t, "hosts.txt", map[string]i2pkeys.I2PAddr{"local.i2p": dest})
+	is, err := NewI2PServerFromTransport(uniqueName("tcpjump"), hosts, nil, NewTCPTransport("127.0.0.1:0", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start(t, is)
+	if is.I2PAddr != nil {
+		t.Error("TCP server claims an I2P address")
+	}
+	body := get(t, http.DefaultClient, "http://"+is.Base32()+"/hosts.txt")
+	if body != "local.i2p="+dest.Base64()+"\n" {
+		t.Errorf("unexpected hosts.txt over TCP: %q", body)
+	}
+	if _, err := is.Transport.Lookup("localhost:0"); err != nil {
+		t.Errorf("TCP lookup failed: %s", err)
+	}
+}
+
+func TestListenLocal(t *testing.T) {
+	needAccept(t)
+	dest := newDest(t)
+	hosts := writeHosts(t, "hosts.txt", map[string]i2pkeys.I2PAddr{"both.i2p": dest})
+	is, err := NewI2PServer(uniqueName("localjump"), bridge.Addr(), filepath.Join(t.TempDir(), "keys"), hosts, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := is.ListenLocal("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	start(t, is)
+	want := "both.i2p=" + dest.Base64() + "\n"
+	if body := get(t, http.DefaultClient, "http://"+is.LocalListener.Addr().String()+"/hosts.txt"); body != want {
+		t.Errorf("unexpected hosts.txt on the local listener: %q", body)
+	}
+	if body := get(t, newClient(t), "http://"+is.Base32()+"/hosts.txt"); body != want {
+		t.Errorf("unexpected hosts.txt over I2P: %q", body)
+	}
+}
+
+// bridgeSessions returns the sessions on the bridge which belong to name.
+func bridgeSessions(name string) []string {
+	var ids []string
+	for _, id := range bridge.Sessions() {
+		if id == name || strings.HasPrefix(id, name+"-") {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func dialPeer(t *testing.T, transport Transport, peer string) {
+	conn, err := transport.Dial(context.Background(), peer+".i2p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestSAMTransportSharedSession(t *testing.T) {
+	peer := uniqueName("peer")
+	servePeer(t, peer, "")
+	transport := NewSAMTransport(uniqueName("shared"), bridge.Addr(), "")
+	for i := 0; i < 3; i++ {
+		dialPeer(t, transport, peer)
+	}
+	if ids := bridgeSessions(transport.Name); len(ids) != 1 || ids[0] != transport.ClientID() {
+		t.Errorf("expected only the client session on the bridge, found %v", ids)
+	}
+	if err := transport.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := transport.Dial(context.Background(), peer+".i2p"); err == nil {
+		t.Error("dialed through a closed transport")
+	}
+}
+
+func TestSAMTransportReconnect(t *testing.T) {
+	peer := uniqueName("peer")
+	servePeer(t, peer, "")
+	transport := NewSAMTransport(uniqueName("reconnect"), bridge.Addr(), "")
+	defer transport.Close()
+	dialPeer(t, transport, peer)
+
+	// A dial from a session the bridge has lost reconnects and retries.
+	bridge.Drop(transport.ClientID())
+	dialPeer(t, transport, peer)
+
+	// So does the health check, without waiting for a dial to fail.
+	bridge.Drop(transport.ClientID())
+	if err := transport.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if ids := bridgeSessions(transport.Name); len(ids) != 1 {
+		t.Fatalf("health check did not recreate the client session: %v", ids)
+	}
+	if err := transport.Check(); err != nil {
+		t.Errorf("health check of a live session failed: %s", err)
+	}
+	if ids := bridgeSessions(transport.Name); len(ids) != 1 {
+		t.Errorf("health check disturbed a live session: %v", ids)
+	}
+	dialPeer(t, transport, peer)
+}
@@ -0,0 +1,371 @@
+package jump
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+
+	"github.com/eyedeekay/sam3/i2pkeys"
+	"i2pgit.org/idk/jump-transparency/lib/naming"
+)
+
+// Verdicts of a trust check, by what a peer has for a hostname.
+const (
+	TrustAgrees    = "agrees"
+	TrustDisagrees = "disagrees"
+	TrustOnlyPeer  = "only peer"
+	TrustOnlyUs    = "only us"
+)
+
+// trustVerdicts names the agreement codes returned by Check.
+var trustVerdicts = map[int]string{1: TrustAgrees, 0: TrustDisagrees, -1: TrustOnlyPeer, -2: TrustOnlyUs}
+
+// TrustChecker compares our hosts file with the hosts files of peers. It
+// needs nothing but the hosts files, so the server checks the peers it
+// mirrors with it and the same report can be made offline from saved files.
+type TrustChecker struct {
+	Me    *I2PJump
+	Peers []*I2PJump
+	// History, if set, is the history of Me, used to tell peers which
+	// missed a change of destination from hijacks.
+	History *History
+	// Lookalikes, if set, are the names from peer feeds which look like
+	// another known host.
+	Lookalikes map[string][]Lookalike
+}
+
+// Check compares the destination Me has for hostname with the ones the
+// peers have. agrees holds 1 for each peer with the same destination, 0 for
+// one with another, -1 for one which has it when Me does not, and -2 under
+// Me's name when none of the peers have it; votes holds the destination each
+// of them has.
+func (tc *TrustChecker) Check(hostname string) (agrees map[string]int, votes map[string]string, host string) {
+	myval, ok := tc.Me.ToMap()[hostname]
+	host = hostname
+	agrees = make(map[string]int)
+	votes = make(map[string]string)
+	if ok {
+		myaddr, err := ParseDestination(myval)
+		for _, peer := range tc.Peers {
+			val, ok := peer.ToMap()[hostname]
+			if ok {
+				if err == nil {
+					valaddr, err := ParseDestination(val)
+					if err == nil {
+						if valaddr.Base32() == myaddr.Base32() {
+							agrees[peer.Name] = 1
+						} else {
+							agrees[peer.Name] = 0
+						}
+						votes[peer.Name] = valaddr.String()
+					}
+				}
+			}
+		}
+		if err == nil {
+			if len(agrees) == 0 || len(votes) == 0 {
+				agrees[tc.Me.Name] = -2
+				votes[tc.Me.Name] = myaddr.String()
+			}
+		}
+	} else {
+		for _, peer := range tc.Peers {
+			val, ok := peer.ToMap()[hostname]
+			if ok {
+				valaddr, err := ParseDestination(val)
+				if err == nil {
+					agrees[peer.Name] = -1
+					votes[peer.Name] = valaddr.String()
+				}
+			}
+		}
+	}
+	return
+}
+
+// ParentAuthorization checks a subdomain in a peer's hosts file for the
+// authorization of its parent domain, as the peer or, failing that, we know
+// the parent. It returns nil for hostnames which are not subdomains or which
+// the peer does not have.
+func (tc *TrustChecker) ParentAuthorization(peer *I2PJump, hostname string) error {
+	parent := naming.Parent(hostname)
+	if parent == "" {
+		return nil
+	}
+	entry, ok := peer.Hosts().Lookup(hostname)
+	if !ok {
+		return nil
+	}
+	parentDest, ok := peer.ToMap()[parent]
+	if !ok {
+		if parentDest, ok = tc.Me.ToMap()[parent]; !ok {
+			return fmt.Errorf("%s is a subdomain of %s, which no one knows", hostname, parent)
+		}
+	}
+	return entry.VerifyParent(parent, parentDest)
+}
+
+// Disagreement explains why peer has another destination for hostname than
+// we do: because it still has one which was rolled over to ours with the old
+// key's authorization, because it has a rollover from ours which the key we
+// know authorized, or for no reason which can be verified, as with a hijack.
+func (tc *TrustChecker) Disagreement(peer *I2PJump, hostname string) string {
+	ours, ok := tc.Me.Hosts().Lookup(hostname)
+	if !ok {
+		return ""
+	}
+	theirs, ok := peer.Hosts().Lookup(hostname)
+	if !ok || sameDestination(ours.Destination, theirs.Destination) {
+		return ""
+	}
+	if tc.History != nil && tc.History.RolledOver(hostname, theirs.Destination) {
+		return "They have not caught up with an authorized change to our destination yet."
+	}
+	if theirs.VerifyRollover(ours.Destination) == nil {
+		return "They have an authorized change from our destination to a new one."
+	}
+	return "No authorized change of destination explains the difference, the name may have been hijacked."
+}
+
+// peer returns the peer called name, or nil.
+func (tc *TrustChecker) peer(name string) *I2PJump {
+	for _, peer := range tc.Peers {
+		if peer.Name == name {
+			return peer
+		}
+	}
+	return nil
+}
+
+// Hostnames returns every hostname the peers have, sorted.
+func (tc *TrustChecker) Hostnames() []string {
+	seen := make(map[string]bool)
+	var hostnames []string
+	for _, peer := range tc.Peers {
+		for host := range peer.ToMap() {
+			if !seen[host] {
+				seen[host] = true
+				hostnames = append(hostnames, host)
+			}
+		}
+	}
+	sort.Strings(hostnames)
+	return hostnames
+}
+
+// sortedVoters returns the names in agrees, sorted.
+func sortedVoters(agrees map[string]int) []string {
+	var names []string
+	for name := range agrees {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Element renders the result of Check for hostname as a section of the
+// trust chart.
+func (tc *TrustChecker) Element(agrees map[string]int, votes map[string]string, hostname string) string {
+	var r string
+	if len(agrees) > 0 && len(votes) > 0 {
+		r += `<div class="server_` + hostname + `">`
+		r += `  <h1 class="server_` + hostname + `"> Hostname ` + html.EscapeString(naming.Display(hostname)) + `</h2>`
+		for _, lookalike := range tc.Lookalikes[hostname] {
+			r += `  <h4 class="lookalike"> Warning: ` + html.EscapeString(lookalike.String()) + `, first seen at ` + html.EscapeString(lookalike.Source) + `</h4>`
+		}
+		for _, peerindex := range sortedVoters(agrees) {
+			agree := agrees[peerindex]
+			peer := tc.peer(peerindex)
+			r += `<div class="server_` + peerindex + `">`
+			r += `  <h2 class="server_` + peerindex + `"> Server ` + peerindex + `</h2>`
+
+			if agree == 1 {
+				r += `  <h4 class="server_` + peerindex + `">`
+				r += `    Agrees with us about the base32 destination`
+				r += `  </h4>`
+			} else if agree == 0 {
+				r += `  <h4 class="server_` + peerindex + `">`
+				r += `    Disagrees with us about the base32 destination`
+				r += `  </h4>`
+				if peer != nil {
+					r += `  <h4 class="server_` + peerindex + `">`
+					r += `    ` + html.EscapeString(tc.Disagreement(peer, hostname))
+					r += `  </h4>`
+				}
+			} else if agree == -1 {
+				r += `  <h4 class="server_` + peerindex + `">`
+				r += `    Has a record  of this host, but we do not.`
+				r += `  </h4>`
+			} else if agree == -2 {
+				r += `  <h4 class="server_` + peerindex + `">`
+				r += `    Only we have a record of this host.`
+				r += `  </h4>`
+			}
+			if peer != nil {
+				if err := tc.ParentAuthorization(peer, hostname); err != nil {
+					if _, legacy := err.(*UnsignedSubdomainError); legacy {
+						r += `  <h4 class="legacy">`
+						r += `    Note: ` + html.EscapeString(err.Error()) + `, it is an unsigned legacy entry`
+					} else {
+						r += `  <h4 class="unauthorized">`
+						r += `    Warning: ` + html.EscapeString(err.Error())
+					}
+					r += `  </h4>`
+				}
+			}
+			r += `  <div class="server_` + peerindex + `">`
+			r += `    Sees the base64 address as: ` + votes[peerindex]
+			r += `  </div>`
+			r += `</div>`
+		}
+		r += `</div>`
+	}
+	return r
+}
+
+// trustPage wraps sections of the trust chart in a page.
+func trustPage(body string) string {
+	return `<html>
+<head>
+</head>
+<body>
+  <style>
+  body {
+    font-family: monospace;
+    font-size: large;
+  }
+  b    {
+    color: black;
+  }
+  p    {
+    color: darkgrey;
+  }
+  input, textarea {
+    position: sticky;
+    left: 20%;
+    width: 70%;
+  }
+  </style>` + body + `</body>
+</html>`
+}
+
+// Chart renders the trust chart of every hostname the peers have.
+func (tc *TrustChecker) Chart() string {
+	var body string
+	for _, host := range tc.Hostnames() {
+		body += tc.Element(tc.Check(host))
+	}
+	return trustPage(body)
+}
+
+// ChartSinglePage renders the trust chart of one hostname.
+func (tc *TrustChecker) ChartSinglePage(hostname string) string {
+	return trustPage(tc.Element(tc.Check(naming.Normalize(hostname))))
+}
+
+// TrustVote is what one hosts file has for a hostname in a trust report.
+type TrustVote struct {
+	Peer        string `json:"peer"`
+	Verdict     string `json:"verdict"`
+	Base32      string `json:"base32"`
+	Destination string `json:"destination"`
+	// Explanation says why a peer which disagrees does.
+	Explanation string `json:"explanation,omitempty"`
+	// Warning is set when a subdomain's authorization by its parent is
+	// missing or invalid.
+	Warning string `json:"warning,omitempty"`
+	// Notice is set for an unsigned legacy subdomain, whose authorization
+	// cannot be checked.
+	Notice string `json:"notice,omitempty"`
+}
+
+// TrustRecord is the trust check of one hostname.
+type TrustRecord struct {
+	Hostname   string      `json:"hostname"`
+	Lookalikes []string    `json:"lookalikes,omitempty"`
+	Votes      []TrustVote `json:"votes"`
+}
+
+// TrustReport holds the same checks as the trust chart, for archiving and
+// comparing from one day to the next. It carries no timestamp, so reports of
+// unchanged hosts files are identical.
+type TrustReport struct {
+	Me    string        `json:"me"`
+	Peers []string      `json:"peers"`
+	Hosts []TrustRecord `json:"hosts"`
+}
+
+// Record returns the trust check of hostname, or false if no one has it.
+func (tc *TrustChecker) Record(hostname string) (TrustRecord, bool) {
+	agrees, votes, _ := tc.Check(hostname)
+	if len(agrees) == 0 || len(votes) == 0 {
+		return TrustRecord{}, false
+	}
+	record := TrustRecord{Hostname: hostname}
+	for _, lookalike := range tc.Lookalikes[hostname] {
+		record.Lookalikes = append(record.Lookalikes, lookalike.String()+", first seen at "+lookalike.Source)
+	}
+	for _, name := range sortedVoters(agrees) {
+		vote := TrustVote{
+			Peer:        name,
+			Verdict:     trustVerdicts[agrees[name]],
+			Base32:      i2pkeys.I2PAddr(votes[name]).Base32(),
+			Destination: votes[name],
+		}
+		if peer := tc.peer(name); peer != nil {
+			if agrees[name] == 0 {
+				vote.Explanation = tc.Disagreement(peer, hostname)
+			}
+			if err := tc.ParentAuthorization(peer, hostname); err != nil {
+				if _, legacy := err.(*UnsignedSubdomainError); legacy {
+					vote.Notice = err.Error()
+				} else {
+					vote.Warning = err.Error()
+				}
+			}
+		}
+		record.Votes = append(record.Votes, vote)
+	}
+	return record, true
+}
+
+// Report checks every hostname the peers have.
+func (tc *TrustChecker) Report() TrustReport {
+	report := TrustReport{Me: tc.Me.Name, Peers: []string{}, Hosts: []TrustRecord{}}
+	for _, peer := range tc.Peers {
+		report.Peers = append(report.Peers, peer.Name)
+	}
+	for _, host := range tc.Hostnames() {
+		if record, ok := tc.Record(host); ok {
+			report.Hosts = append(report.Hosts, record)
+		}
+	}
+	return report
+}
+
+// JSON returns the report as indented JSON.
+func (r TrustReport) JSON() ([]byte, error) {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// CSV returns the report with a row for each vote, and a header.
+func (r TrustReport) CSV() ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"hostname", "peer", "verdict", "base32", "explanation", "warning", "notice", "lookalikes"})
+	for _, host := range r.Hosts {
+		for _, vote := range host.Votes {
+			w.Write([]string{host.Hostname, vote.Peer, vote.Verdict, vote.Base32, vote.Explanation, vote.Warning, vote.Notice, strings.Join(host.Lookalikes, "; ")})
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
@@ -0,0 +1,107 @@
+package jump
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/eyedeekay/sam3/i2pkeys"
+)
+
+func TestTrustReport(t *testing.T) {
+	same, theirs, ours := newDest(t), newDest(t), newDest(t)
+	serve := func(body string) string {
+		srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rq *http.Request) {
+			rw.Write([]byte(body))
+		}))
+		t.Cleanup(srv.Close)
+		return srv.URL + "/hosts.txt"
+	}
+	hosts := writeHosts(t, "hosts.txt", map[string]i2pkeys.I2PAddr{"same.i2p": same, "moved.i2p": ours})
+	is, err := NewI2PServerFromTransport(uniqueName("reporting"), hosts, nil, NewTCPTransport("127.0.0.1:0", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	agreeing, hijacked := uniqueName("agreeing"), uniqueName("hijacked")
+	err = is.UpdatePeers([]PeerConfig{
+		{Name: agreeing, URL: serve("same.i2p=" + same.Base64() + "\nmoved.i2p=" + ours.Base64() + "\n")},
+		{Name: hijacked, URL: serve("same.i2p=" + same.Base64() + "\nmoved.i2p=" + theirs.Base64() + "\nnew.i2p=" + theirs.Base64() + "\nsarne.i2p=" + theirs.Base64() + "\n")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for _, peer := range is.Peers() {
+		if err := peer.Fetch(); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "peer-"+peer.Name+"-hosts.txt"), peer.HostsFile(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// sarne.i2p is new since an earlier fetch, and looks like same.i2p.
+		is.checkFeed(peer.Name)(ParseHostsTxt([]string{"same.i2p=" + same.Base64()}), peer.Hosts())
+	}
+	if len(is.Lookalikes()["sarne.i2p"]) == 0 {
+		t.Fatalf("no lookalike was found: %v", is.Lookalikes())
+	}
+
+	files, err := SavedPeerFiles(dir)
+	if err != nil || len(files) != 2 {
+		t.Fatalf("found peer files %v: %v", files, err)
+	}
+	tc, err := LoadTrustChecker(hosts, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.Me.Name = is.Me.Name
+	if _, err := tc.LoadSavedState("."); err != nil {
+		t.Fatal(err)
+	}
+	if chart := tc.Chart(); chart != is.TrustChart() || !strings.Contains(chart, "sarne.i2p looks like same.i2p") {
+		t.Errorf("offline trust chart differs from the server's:\n%s", chart)
+	}
+	report := tc.Report()
+	if len(report.Hosts) != 4 || report.Hosts[0].Hostname != "moved.i2p" {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	for _, vote := range report.Hosts[0].Votes {
+		want := map[string]string{agreeing: TrustAgrees, hijacked: TrustDisagrees}[vote.Peer]
+		if vote.Verdict != want {
+			t.Errorf("%s: verdict %q, want %q", vote.Peer, vote.Verdict, want)
+		}
+		if vote.Verdict == TrustDisagrees && (vote.Base32 != theirs.Base32() || !strings.Contains(vote.Explanation, "hijacked")) {
+			t.Errorf("unexpected disagreement: %+v", vote)
+		}
+	}
+	data, err := report.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw := httptest.NewRecorder()
+	is.WebServer.ServeHTTP(rw, httptest.NewRequest("GET", "/trust.json", nil))
+	if rw.Body.String() != string(data) {
+		t.Errorf("offline trust.json differs from the server's:\n%s", data)
+	}
+
+	site := filepath.Join(dir, "site")
+	if err := tc.WriteSite(site); err != nil {
+		t.Fatal(err)
+	}
+	csv, err := ioutil.ReadFile(filepath.Join(site, "trust.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(csv), "new.i2p,"+hijacked+","+TrustOnlyPeer+","+theirs.Base32()) {
+		t.Errorf("unexpected trust.csv: %s", csv)
+	}
+	page, err := ioutil.ReadFile(filepath.Join(site, "trustrecord", "same.i2p.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(page) != is.TrustChartSinglePage("same.i2p") {
+		t.Errorf("offline page of same.i2p differs from the server's:\n%s", page)
+	}
+}
@@ -0,0 +1,113 @@
+package jump
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SavedPeerName returns the name of the peer whose hosts file was saved as
+// file: the file name without its directory, its ".txt", and the "peer-"
+// and "-hosts" around the name of a peer-NAME-hosts.txt.
+func SavedPeerName(file string) string {
+	name := strings.TrimSuffix(filepath.Base(file), ".txt")
+	if strings.HasPrefix(name, "peer-") && strings.HasSuffix(name, "-hosts") && len(name) > len("peer--hosts") {
+		name = strings.TrimSuffix(strings.TrimPrefix(name, "peer-"), "-hosts")
+	}
+	return name
+}
+
+// SavedPeerFiles returns the peer-NAME-hosts.txt files in dir.
+func SavedPeerFiles(dir string) ([]string, error) {
+	return filepath.Glob(filepath.Join(dir, "peer-*-hosts.txt"))
+}
+
+// SavedServerName returns the name of the server whose state is saved in
+// dir, found by its NAME-transparency.txt, or "" unless there is exactly one.
+func SavedServerName(dir string) string {
+	logs, err := filepath.Glob(filepath.Join(dir, "*-transparency.txt"))
+	if err != nil || len(logs) != 1 {
+		return ""
+	}
+	return strings.TrimSuffix(filepath.Base(logs[0]), "-transparency.txt")
+}
+
+// LoadSavedState loads the history of Me and the lookalikes the server found
+// in peer feeds from the NAME-history.txt and NAME-lookalikes.txt saved in
+// dir under Me's name, so that the report made offline is the one the server
+// serves. It returns the files which are missing; the history is left alone
+// if it is already set.
+func (tc *TrustChecker) LoadSavedState(dir string) (missing []string, err error) {
+	history := filepath.Join(dir, tc.Me.Name+"-history.txt")
+	if tc.History == nil {
+		if _, err := os.Stat(history); err != nil {
+			missing = append(missing, history)
+		} else if tc.History, err = LoadHistory(history); err != nil {
+			return nil, err
+		}
+	}
+	lookalikes := filepath.Join(dir, tc.Me.Name+"-lookalikes.txt")
+	if _, err := os.Stat(lookalikes); err != nil {
+		return append(missing, lookalikes), nil
+	}
+	tc.Lookalikes, err = LoadLookalikes(lookalikes)
+	return missing, err
+}
+
+// localHosts loads a hosts file which must exist as a peer called name,
+// which is never fetched.
+func localHosts(file, name string) (*I2PJump, error) {
+	if _, err := os.Stat(file); err != nil {
+		return nil, err
+	}
+	return NewI2PJump(file, "", name, "")
+}
+
+// LoadTrustChecker returns a checker of the hosts file at hostsFile against
+// saved hosts files of peers, each named after its file, without a router.
+func LoadTrustChecker(hostsFile string, peerFiles []string) (*TrustChecker, error) {
+	me, err := localHosts(hostsFile, SavedPeerName(hostsFile))
+	if err != nil {
+		return nil, err
+	}
+	tc := &TrustChecker{Me: me}
+	for _, file := range peerFiles {
+		peer, err := localHosts(file, SavedPeerName(file))
+		if err != nil {
+			return nil, err
+		}
+		tc.Peers = append(tc.Peers, peer)
+	}
+	return tc, nil
+}
+
+// WriteSite writes the trust chart to dir as a static site laid out like the
+// server's: index.html is the page served at /trust, trustrecord/NAME.html
+// the page of each hostname, and trust.json and trust.csv the report.
+func (tc *TrustChecker) WriteSite(dir string) error {
+	if err := os.MkdirAll(filepath.Join(dir, "trustrecord"), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte(tc.Chart()), 0644); err != nil {
+		return err
+	}
+	for _, host := range tc.Hostnames() {
+		page := filepath.Join(dir, "trustrecord", host+".html")
+		if err := ioutil.WriteFile(page, []byte(tc.ChartSinglePage(host)), 0644); err != nil {
+			return err
+		}
+	}
+	report := tc.Report()
+	data, err := report.JSON()
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "trust.json"), data, 0644); err != nil {
+		return err
+	}
+	if data, err = report.CSV(); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "trust.csv"), data, 0644)
+}
@@ -1,19 +1,28 @@
 package jump
 
 import (
+	"context"
+	"crypto"
+	"crypto/subtle"
+	"fmt"
 	"html/template"
 	"io/ioutil"
 	"log"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/didip/tollbooth"
-	"github.com/eyedeekay/sam3"
-	"github.com/eyedeekay/sam3/helper"
+	"github.com/didip/tollbooth/limiter"
 	"github.com/eyedeekay/sam3/i2pkeys"
 	"github.com/justinas/nosurf"
+	"i2pgit.org/idk/jump-transparency/lib/naming"
 )
 
 var network_template string = `<html>
@@ -52,6 +61,10 @@ var network_template string = `<html>
   networks of jump operators to provide eachother with redundancy. Jump-Transparency servers also
   accept a single "Announce" daily from other services that want to publicize themselves.
   </div></br>
+  <form action="/search" method="get">
+    <label for="search">Search the hostnames registered here, in any script:</label>
+    <input type="text" id="search" name="q">
+  </form>
 
   <div>
     <h2>Trust Chart</h2>
@@ -64,20 +77,20 @@ var network_template string = `<html>
     destination.
     </div>
     <ul>
-      <li><a href="http://{{ .I2PAddr.Base32 }}/trust"><b>Visit the Trust Chart:</b></a></li>
+      <li><a href="http://{{ .Base32 }}/trust"><b>Visit the Trust Chart:</b></a></li>
     </ul>
   </div>
 
   <div>
     <h2>Subscription URL's</h2>
     <ul>
-      <li><b>Hosts File Subscription:</b> http://{{ .I2PAddr.Base32 }}/hosts.txt
+      <li><b>Hosts File Subscription:</b> http://{{ .Base32 }}/hosts.txt
         <ul>
           <li>This hosts.txt file contains only hosts which were registered at this
           service.</li>
         </ul>
       </li>
-      <li><b>Peer Hosts Files Subscription:</b> http://{{ .I2PAddr.Base32 }}/peer-hosts.txt
+      <li><b>Peer Hosts Files Subscription:</b> http://{{ .Base32 }}/peer-hosts.txt
         <ul>
           <li>This hosts.txt file contains the combined hosts files of many peers,
           All the addresses within are from other jump services listed below.</li>
@@ -88,7 +101,7 @@ var network_template string = `<html>
     <div>
     </div>
     <div>
-      {{range $index, $element := .Peers}} {{$index}} {{$element.Name}} <a href="{{$element.MyURL}}">{{$element.MyURL}}</a> <a href="peer-{{$element.Name}}-hosts.txt"> Mirror hosts.txt </a> </br>  {{else}} This server is not configured to mirror any peer addresses. {{end}}
+      {{range $index, $element := .Peers}} {{$index}} {{$element.Name}} <a href="{{$element.MyURL}}">{{$element.MyURL}}</a> <a href="peer-{{$element.Name}}-hosts.txt"> Mirror hosts.txt </a> {{len $element.ToMap}} hosts{{with $element.RejectedLines}}, {{.}} lines rejected{{end}} </br>  {{else}} This server is not configured to mirror any peer addresses. {{end}}
     </div>
     <h3>Announces</h3>
     <div>
@@ -96,14 +109,22 @@ var network_template string = `<html>
       Announces expiere after 24 hours and must be renewed daily. This makes them an alternative
       way of announcing your site's up-time. It is up to the discretion of the announcer to decide
       what type of address to advertise. It may be a b32, a hostname, or an addresshelper link.
-      To announce a site, send a <code>POST<code> request to http://{{ .I2PAddr.Base32 }}/announce
+      To announce a site, send a <code>POST<code> request to http://{{ .Base32 }}/announce
       with the body: <pre><code>
       host_name=$NAME_OF_YOUR_SITE
       host_host=$PROTOCOL_SCHEME$ADDRESS_OF_YOUR_CHOICE
       </code></pre>
+      Announces may optionally be signed by the announced destination's key, which marks them
+      as verified for 24 hours and prevents anyone else from replacing them meanwhile. To sign an
+      announce, add the current Unix time and a Base64 signature over
+      <code>{{ .Base32 }}:$NAME_OF_YOUR_SITE:$UNIX_TIME</code> to the body. The time must be
+      within ten minutes of ours and later than that of your last signed announce: <pre><code>
+      host_time=$UNIX_TIME
+      host_sig=$BASE64_SIGNATURE
+      </code></pre>
     </div>
     <div>
-    {{range $index, $element := .Pals}} <span>{{$element}}</span> <a href="{{$index}}">{{$index}}</a> {{else}} No one has announced a peer address yet. {{end}}
+    {{range $index, $element := .Pals}} <span>{{$element}}</span> <a href="{{$index}}">{{$index}}</a> {{if index $.Verified $index}}<b>(verified)</b>{{end}} {{else}} No one has announced a peer address yet. {{end}}
     </div>
   </div>
 `
@@ -117,7 +138,12 @@ var server_template string = `
     of making it easy to host a jump service, so if you have a problem with it, go host your
     own.</div></br>
     <div>There is a firm limit of one and only one hostname request per client per day.</div></br>
+    <div>Subdomains of a hostname registered here, like <code>sub.example.i2p</code>, need the
+    permission of the owner of <code>example.i2p</code>. Paste a line in the extended hosts.txt
+    format, signed with <code>action=addsubdomain</code> and an <code>oldsig</code> from the key of
+    <code>example.i2p</code>, as the Authentication String.</div></br>
     <form action="/hostadd" method="post">
+      <input type="hidden" name="csrf_token" value="{{ .CSRFToken }}">
       <label for="hostname">Preferred Hostname:</label>
       <input type="text" id="hostname" name="host_name"></br>
       <label for="destination">Authentication String:</label>
@@ -135,312 +161,404 @@ var ops_template string = `
 
 var default_template string = network_template + server_template + ops_template
 
+// WebServer is the web interface of a jump service. It is safe for
+// concurrent use: the state which changes while it is serving is guarded by
+// its mutex, and the hosts files swap in new snapshots when they change.
 type WebServer struct {
-	Me        *I2PJump
-	Queue     *I2PJump
-	Peers     []*I2PJump
-	Pals      map[string]string
+	Me    *I2PJump
+	Queue *I2PJump
+	// History records every change made to Me.
+	History *History
+	// Transparency is the public log of who made each change to Me, and
+	// why.
+	Transparency *TransparencyLog
+	// Expiry tracks when each name was last renewed or seen alive.
+	Expiry *Expiry
+	// Tickets are the receipts for registrations waiting for approval.
+	Tickets *Tickets
+	// SU3 signs the hosts feeds published as SU3 files, if it is set.
+	SU3       *SU3Signer
 	Templates map[string]string
-	limited   map[string]time.Time
 	KeysPath  string
 	Homepage  string
-	samaddr   string
-	rc        bool
 	I2PAddr   *i2pkeys.I2PAddr
+	Transport Transport
+	Scheduler *Scheduler
+	addr      net.Addr
+
+	writing sync.Mutex
+	stop    context.CancelFunc
+	stopped chan struct{}
+	mutex   sync.RWMutex
+	peers   []*I2PJump
+	pals    map[string]string
+	// verified holds the signed time of the latest signed announce of each
+	// announced jump service.
+	verified map[string]time.Time
+	limited  map[string]time.Time
+	// lookalikes are the names from peer feeds which look like another
+	// known host, by name.
+	lookalikes map[string][]Lookalike
+	hostsFile  string
+	// registrationInterval is how long a client has to wait between
+	// hostname registrations.
+	registrationInterval time.Duration
+	admin                AdminConfig
+	limits               FeedLimits
+	expiry               ExpiryConfig
+	// announce are the jump services we announce ourselves to.
+	announce []string
 }
 
+// Peers returns the peers currently being mirrored.
+func (ws *WebServer) Peers() []*I2PJump {
+	ws.mutex.RLock()
+	defer ws.mutex.RUnlock()
+	return append([]*I2PJump(nil), ws.peers...)
+}
+
+// Pals returns the announced jump services, keyed by their address.
+func (ws *WebServer) Pals() map[string]string {
+	ws.mutex.RLock()
+	defer ws.mutex.RUnlock()
+	pals := make(map[string]string, len(ws.pals))
+	for k, v := range ws.pals {
+		pals[k] = v
+	}
+	return pals
+}
+
+// Verified returns the addresses of the announced jump services which made a
+// signed announce within the last verifiedLifetime.
+func (ws *WebServer) Verified() map[string]bool {
+	ws.mutex.RLock()
+	defer ws.mutex.RUnlock()
+	verified := make(map[string]bool, len(ws.verified))
+	for k := range ws.verified {
+		if ws.isVerified(k) {
+			verified[k] = true
+		}
+	}
+	return verified
+}
+
+// isVerified reports whether the jump service at base32 made a signed
+// announce within the last verifiedLifetime. The caller holds the lock.
+func (ws *WebServer) isVerified(base32 string) bool {
+	signed, ok := ws.verified[base32]
+	return ok && time.Since(signed) < verifiedLifetime
+}
+
+// Configure applies the settings from cfg which the web interface enforces
+// itself: the registration interval, the admin credentials, the limits on
+// peer hosts files and the jump services to announce ourselves to.
+func (ws *WebServer) Configure(cfg *Config) {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	ws.registrationInterval = cfg.RateLimits.RegistrationInterval
+	ws.admin = cfg.Admin
+	ws.limits = cfg.Fetch.Limits
+	ws.expiry = cfg.Expiry
+	ws.announce = cfg.Announce
+	for _, peer := range ws.peers {
+		peer.SetLimits(ws.limits)
+	}
+}
+
+// Base32 returns the address the service is reachable at, its Base32 address
+// when it is served over I2P.
 func (ws *WebServer) Base32() string {
-	return ws.I2PAddr.Base32()
+	if ws.I2PAddr != nil {
+		return ws.I2PAddr.Base32()
+	}
+	return ws.addr.String()
 }
 
 func (ws *WebServer) AgglomeratedHostsFile() []byte {
 	var returnable []byte
 	var unreturnable []byte
-	for _, v := range ws.Peers {
+	for _, v := range ws.Peers() {
 		unreturnable = append(unreturnable, v.HostsFile()...)
 		returnable = unreturnable //[]byte(strings.Replace(string(unreturnable), "\n", "", -1))
 	}
 	return returnable
 }
 
+// Trust returns a checker of our hosts file against the peers' as they are
+// now.
+func (ws *WebServer) Trust() *TrustChecker {
+	return &TrustChecker{Me: ws.Me, Peers: ws.Peers(), History: ws.History, Lookalikes: ws.Lookalikes()}
+}
+
 func (ws *WebServer) TrustCheck(hostname string) (agrees map[string]int, votes map[string]string, host string) {
-	myval, ok := ws.Me.ToMap()[hostname]
-	host = hostname
-	agrees = make(map[string]int)
-	votes = make(map[string]string)
-	if ok {
-		myaddr, err := i2pkeys.NewI2PAddrFromString(myval)
-		for _, peer := range ws.Peers {
-			val, ok := peer.ToMap()[hostname]
-			if ok {
-				if err == nil {
-					valaddr, err := i2pkeys.NewI2PAddrFromString(val)
-					if err == nil {
-						if valaddr.Base32() == myaddr.Base32() {
-							agrees[peer.Name] = 1
-						} else {
-							agrees[peer.Name] = 0
-						}
-						votes[peer.Name] = valaddr.String()
-					}
-				}
-			}
-		}
-		if err == nil {
-			if len(agrees) == 0 || len(votes) == 0 {
-				agrees[ws.Me.Name] = -2
-				votes[ws.Me.Name] = myaddr.String()
-			}
-		}
-	} else {
-		for _, peer := range ws.Peers {
-			val, ok := peer.ToMap()[hostname]
-			if ok {
-				valaddr, err := i2pkeys.NewI2PAddrFromString(val)
-				if err == nil {
-					agrees[peer.Name] = -1
-					votes[peer.Name] = valaddr.String()
-				}
-			}
-		}
-	}
-	return
+	return ws.Trust().Check(hostname)
+}
+
+// ParentAuthorization checks a subdomain in a peer's hosts file for the
+// authorization of its parent domain, as TrustChecker.ParentAuthorization.
+func (ws *WebServer) ParentAuthorization(peer *I2PJump, hostname string) error {
+	return ws.Trust().ParentAuthorization(peer, hostname)
+}
+
+// Disagreement explains why peer has another destination for hostname than
+// we do, as TrustChecker.Disagreement.
+func (ws *WebServer) Disagreement(peer *I2PJump, hostname string) string {
+	return ws.Trust().Disagreement(peer, hostname)
 }
 
 func (ws *WebServer) TrustCheckElement(agrees map[string]int, votes map[string]string, hostname string) string {
-	var r string
-	if len(agrees) > 0 && len(votes) > 0 {
-		r += `<div class="server_` + hostname + `">`
-		r += `  <h1 class="server_` + hostname + `"> Hostname ` + hostname + `</h2>`
-		for peerindex, agree := range agrees {
-			r += `<div class="server_` + peerindex + `">`
-			r += `  <h2 class="server_` + peerindex + `"> Server ` + peerindex + `</h2>`
-
-			if agree == 1 {
-				r += `  <h4 class="server_` + peerindex + `">`
-				r += `    Agrees with us about the base32 destination`
-				r += `  </h4>`
-			} else if agree == 0 {
-				r += `  <h4 class="server_` + peerindex + `">`
-				r += `    Disagrees with us about the base32 destination`
-				r += `  </h4>`
-			} else if agree == -1 {
-				r += `  <h4 class="server_` + peerindex + `">`
-				r += `    Has a record  of this host, but we do not.`
-				r += `  </h4>`
-			} else if agree == -2 {
-				r += `  <h4 class="server_` + peerindex + `">`
-				r += `    Only we have a record of this host.`
-				r += `  </h4>`
-			}
-			r += `  <div class="server_` + peerindex + `">`
-			r += `    Sees the base64 address as: ` + votes[peerindex]
-			r += `  </div>`
-			r += `</div>`
-		}
-		r += `</div>`
-	}
-	return r
+	return ws.Trust().Element(agrees, votes, hostname)
 }
 
 func (ws *WebServer) TrustChart() string {
-	err := ioutil.WriteFile("all-known-hosts.txt", []byte(ws.AgglomeratedHostsFile()), 0644)
-	if err != nil {
-		return "Error rendering page, please contact the admin"
-	}
-	virtjump, err := NewI2PJump("all-known-hosts.txt", ws.samaddr, ws.I2PAddr.Base32(), "http://"+ws.I2PAddr.Base32()+"/peer-hosts.txt")
-	if err != nil {
-		return "Error rendering page, please contact the admin"
-	}
-	if virtjump != nil {
-		var ret string
-		ret += `<html>
-<head>
-</head>
-<body>
-  <style>
-  body {
-    font-family: monospace;
-    font-size: large;
-  }
-  b    {
-    color: black;
-  }
-  p    {
-    color: darkgrey;
-  }
-  input, textarea {
-    position: sticky;
-    left: 20%;
-    width: 70%;
-  }
-  </style>`
-		for host := range virtjump.ToMap() {
-			log.Println("Checking host:", host)
-			ret += ws.TrustCheckElement(ws.TrustCheck(host))
-		}
-		ret += `</body>
-</html>`
-		return ret
-	}
-	return "Trustchart closed"
+	return ws.Trust().Chart()
 }
 
 func (ws *WebServer) TrustChartSinglePage(hostname string) string {
-	err := ioutil.WriteFile("all-known-hosts.txt", []byte(ws.AgglomeratedHostsFile()), 0644)
-	if err != nil {
-		return "Error rendering page, please contact the admin"
+	return ws.Trust().ChartSinglePage(hostname)
+}
+
+// serveTrustReport serves the trust report as JSON or CSV.
+func (ws *WebServer) serveTrustReport(rw http.ResponseWriter, format string) {
+	var data []byte
+	var err error
+	report := ws.Trust().Report()
+	if format == "csv" {
+		rw.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		data, err = report.CSV()
+	} else {
+		rw.Header().Set("Content-Type", "application/json")
+		data, err = report.JSON()
 	}
-	virtjump, err := NewI2PJump("all-known-hosts.txt", ws.samaddr, ws.I2PAddr.Base32(), "http://"+ws.I2PAddr.Base32()+"/peer-hosts.txt")
 	if err != nil {
-		return "Error rendering page, please contact the admin"
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	if virtjump != nil {
-		var ret string
-		ret += `<html>
-<head>
-</head>
-<body>
-  <style>
-  body {
-    font-family: monospace;
-    font-size: large;
-  }
-  b    {
-    color: black;
-  }
-  p    {
-    color: darkgrey;
-  }
-  input, textarea {
-    position: sticky;
-    left: 20%;
-    width: 70%;
-  }
-  </style>`
-		ret += ws.TrustCheckElement(ws.TrustCheck(hostname))
-		ret += `</body>
-</html>`
-		return ret
-	}
-	return "Trustchart closed"
+	rw.Write(data)
 }
 
-func (ws WebServer) CheckLoop() error {
-	time.Sleep(time.Minute * 5)
-	log.Println("Initiating re-check cycles")
-	for {
-		ws.Recheck(3600)
+// jump redirects to the site a jump link names with an address helper. The
+// name is taken from the "a" query parameter, and may be written in Unicode.
+func (ws *WebServer) jump(rw http.ResponseWriter, rq *http.Request) {
+	hostname := naming.Normalize(rq.URL.Query().Get("a"))
+	if hostname != "" && !strings.HasSuffix(hostname, naming.Suffix) {
+		hostname += naming.Suffix
 	}
-	return nil
+	entry, ok := ws.Me.ToMap()[hostname]
+	if !ok {
+		http.NotFound(rw, rq)
+		return
+	}
+	http.Redirect(rw, rq, "http://"+hostname+"/?i2paddresshelper="+entry, http.StatusMovedPermanently)
+}
+
+func (ws *WebServer) ValidateHostAnnounce(hosthost string) error {
+	_, err := ws.LookupHostAnnounce(hosthost)
+	return err
 }
-func (ws WebServer) Recheck(delay int) error {
-	if ws.rc {
-		return nil
+
+// LookupHostAnnounce resolves the host of an announce through the transport.
+// A scheme and path, if present, are stripped before the lookup.
+func (ws *WebServer) LookupHostAnnounce(hosthost string) (net.Addr, error) {
+	if u, err := url.Parse(hosthost); err == nil && u.Host != "" {
+		hosthost = u.Host
 	}
-	ws.rc = true
-	for _, peer := range ws.Peers {
-		e := peer.Fetch()
-		if e != nil {
-			log.Printf("Error fetching peer hosts.txt: %s %s", peer.Name, e.Error())
-			//					return nil, e
-		}
-		ws.Peers = append(ws.Peers, peer)
-		time.Sleep(time.Second * time.Duration(delay))
+	log.Printf("looking up: %s", hosthost)
+	hostname, err := ws.Transport.Lookup(hosthost)
+	if err != nil {
+		return nil, err
 	}
-	ws.rc = false
-	return nil
+	log.Println("validated host", hostname)
+	return hostname, nil
+}
+
+// announceWindow is how far the signed time of an announce may be from ours.
+const announceWindow = time.Minute * 10
+
+// verifiedLifetime is how long a signed announce keeps its jump service
+// verified, after which it has to sign a new one.
+const verifiedLifetime = time.Hour * 24
+
+// AnnounceMessage returns the bytes an announcer signs to prove that the
+// announced destination belongs to them. It names the jump service the
+// announce is for, by its Base32 address, so that it cannot be replayed to
+// another one.
+func AnnounceMessage(service, hostname, timestamp string) []byte {
+	return []byte(service + ":" + hostname + ":" + timestamp)
 }
 
-func (ws WebServer) ValidateHostAnnounce(hosthost string) error {
-	session, err := sam.I2PStreamSession("eph", ws.samaddr, "sam-"+"validator-client")
+// ValidateSignedHostAnnounce checks that an announce to us was signed by the
+// key of the announced destination within the announce window, and returns
+// the time it was signed.
+func (ws *WebServer) ValidateSignedHostAnnounce(hostname, hosthost, timestamp, signature string) (time.Time, error) {
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
 	if err != nil {
-		return err
+		return time.Time{}, fmt.Errorf("invalid announce timestamp %q", timestamp)
 	}
-	log.Printf("looking up: %s", hosthost)
-	hostname, err := session.Lookup(hosthost)
+	signed := time.Unix(secs, 0)
+	age := time.Since(signed)
+	if age > announceWindow || age < -announceWindow {
+		return time.Time{}, fmt.Errorf("announce timestamp outside of the accepted window: %s", age)
+	}
+	addr, err := ws.LookupHostAnnounce(hosthost)
 	if err != nil {
-		return err
+		return time.Time{}, err
 	}
-	log.Println("validated host", hostname)
-	session.Close()
-	return nil
+	dest, ok := addr.(i2pkeys.I2PAddr)
+	if !ok {
+		return time.Time{}, fmt.Errorf("%s did not resolve to an I2P destination", hosthost)
+	}
+	return signed, VerifyDestinationSignature(dest, AnnounceMessage(ws.Base32(), hostname, timestamp), signature)
 }
 
-func (ws WebServer) ServeHTTP(rw http.ResponseWriter, rq *http.Request) {
+// authorized reports whether rq carries the admin credentials, answering it
+// with a challenge if it does not. Without configured credentials no request
+// is authorized.
+func (ws *WebServer) authorized(rw http.ResponseWriter, rq *http.Request) bool {
+	ws.mutex.RLock()
+	admin := ws.admin
+	ws.mutex.RUnlock()
+	if admin.Username == "" {
+		http.Error(rw, "Administration is disabled until admin credentials are configured", http.StatusForbidden)
+		return false
+	}
+	user, pass, ok := rq.BasicAuth()
+	if ok && subtle.ConstantTimeCompare([]byte(user), []byte(admin.Username)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(pass), []byte(admin.Password)) == 1 {
+		return true
+	}
+	rw.Header().Set("WWW-Authenticate", `Basic realm="jump-transparency"`)
+	http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+	return false
+}
+
+func (ws *WebServer) ServeHTTP(rw http.ResponseWriter, rq *http.Request) {
 	switch rq.URL.Path {
 	case "/recheck":
-		go ws.Recheck(10)
+		if !ws.authorized(rw, rq) {
+			return
+		}
+		ws.Scheduler.FetchAll()
 		rw.Write([]byte("Forcing recheck of all peers"))
 	case "/trust":
 		rw.Write([]byte(ws.TrustChart()))
+	case "/trust.json":
+		ws.serveTrustReport(rw, "json")
+	case "/trust.csv":
+		ws.serveTrustReport(rw, "csv")
 	case "/hosts.txt":
-		rw.Write(ws.Me.HostsFile())
+		rw.Write(ws.PublishedHostsFile())
+	case "/addresses.csv":
+		rw.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		rw.Write(ws.Me.Hosts().AddressesCSV())
+	case "/privatehosts.txt":
+		rw.Write(ws.Me.Hosts().PrivateHostsFile())
+	case "/hostsdb.blockfile":
+		blockfile, err := NewHostsDB(ws.Me.Hosts(), "http://"+ws.Base32()+"/hosts.txt").Bytes()
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/octet-stream")
+		rw.Write(blockfile)
+	case "/hosts.su3":
+		if ws.SU3 == nil {
+			http.NotFound(rw, rq)
+			return
+		}
+		su3, err := ws.SU3.Sign(ws.PublishedHostsFile())
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/x-i2p-su3")
+		rw.Write(su3)
+	case "/su3-signer.crt":
+		if ws.SU3 == nil {
+			http.NotFound(rw, rq)
+			return
+		}
+		rw.Header().Set("Content-Type", "application/x-pem-file")
+		rw.Write(ws.SU3.Certificate)
+	case "/grace.txt":
+		rw.Write(ws.GraceFile())
+	case "/transparency":
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, entry := range ws.Transparency.Entries() {
+			fmt.Fprintln(rw, entry)
+		}
+	case "/api/v1/register":
+		ws.serveRegisterAPI(rw, rq)
+	case "/search":
+		ws.serveSearch(rw, rq)
 	case "/peer-hosts.txt":
 		rw.Write(ws.AgglomeratedHostsFile())
 	case "/announce":
 		hostname := rq.FormValue("host_name")
 		base32 := rq.FormValue("host_host")
-		if ws.ValidateHostAnnounce(base32) == nil {
-			ws.Pals[base32] = hostname
+		signature := rq.FormValue("host_sig")
+		if signature != "" {
+			signed, err := ws.ValidateSignedHostAnnounce(hostname, base32, rq.FormValue("host_time"), signature)
+			if err != nil {
+				log.Printf("rejected signed announce: %s %s", base32, err)
+				return
+			}
+			ws.mutex.Lock()
+			// Each signed announce has to be newer than the last, so that
+			// one cannot be sent again within the announce window.
+			if signed.After(ws.verified[base32]) {
+				ws.pals[base32] = hostname
+				ws.verified[base32] = signed
+			} else {
+				log.Printf("rejected replayed announce: %s", base32)
+			}
+			ws.mutex.Unlock()
+		} else if ws.Verified()[base32] {
+			log.Printf("ignored unsigned announce for verified host: %s", base32)
+		} else if ws.ValidateHostAnnounce(base32) == nil {
+			ws.mutex.Lock()
+			// A signed announce may have arrived during the lookup.
+			if !ws.isVerified(base32) {
+				ws.pals[base32] = hostname
+			}
+			ws.mutex.Unlock()
 		}
 	default:
-		if strings.HasPrefix(rq.URL.Path, "/peer-") {
+		if rq.URL.Path == "/admin" || strings.HasPrefix(rq.URL.Path, "/admin/") {
+			ws.serveAdmin(rw, rq)
+		} else if strings.HasPrefix(rq.URL.Path, "/peer-") {
 			if strings.HasSuffix(rq.URL.Path, "-hosts.txt") {
 				str := strings.TrimRight(strings.TrimLeft(rq.URL.Path, "/peer-"), "-hosts.txt")
-				for _, v := range ws.Peers {
+				for _, v := range ws.Peers() {
 					if v.Name == str {
 						rw.Write(v.HostsFile())
 					}
 				}
 			}
-		} else if strings.HasPrefix(rq.URL.Path, "/jump.cgi") {
-			addrpair := strings.SplitN(rq.URL.Path, `?a=`, 2)
-			if len(addrpair) == 2 {
-				domain := strings.SplitN(addrpair[1], `.i2p`, 2)[0]
-				if entry, ok := ws.Me.HostsTxt.ToMap()[domain]; ok {
-					http.Redirect(rw, rq, "http://"+domain+"/?i2padddresshelper"+entry, 301)
-				}
-			}
-		} else if strings.HasPrefix(rq.URL.Path, "/cgi-bin/jump.cgi") {
-			addrpair := strings.SplitN(rq.URL.Path, `?a=`, 2)
-			if len(addrpair) == 2 {
-				domain := strings.SplitN(addrpair[1], `.i2p`, 2)[0]
-				if entry, ok := ws.Me.HostsTxt.ToMap()[domain]; ok {
-					http.Redirect(rw, rq, "http://"+domain+"/?i2padddresshelper"+entry, 301)
-				}
-			}
-		} else if strings.HasPrefix(rq.URL.Path, "/jump") {
-			addrpair := strings.SplitN(rq.URL.Path, `?a=`, 2)
-			if len(addrpair) == 2 {
-				domain := strings.SplitN(addrpair[1], `.i2p`, 2)[0]
-				if entry, ok := ws.Me.HostsTxt.ToMap()[domain]; ok {
-					http.Redirect(rw, rq, "http://"+domain+"/?i2padddresshelper"+entry, 301)
-				}
+		} else if strings.HasPrefix(rq.URL.Path, "/jump.cgi") || strings.HasPrefix(rq.URL.Path, "/cgi-bin/jump.cgi") || strings.HasPrefix(rq.URL.Path, "/jump") {
+			ws.jump(rw, rq)
+		} else if strings.HasPrefix(rq.URL.Path, "/status/") {
+			ws.serveStatus(rw, rq)
+		} else if strings.HasPrefix(rq.URL.Path, "/history/") {
+			rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			for _, event := range ws.History.Of(naming.Normalize(strings.TrimPrefix(rq.URL.Path, "/history/"))) {
+				fmt.Fprintln(rw, event)
 			}
 		} else if strings.HasPrefix(rq.URL.Path, "/trustrecord") {
 			addrpair := strings.SplitN(rq.URL.Path, `/`, 3)
-			log.Println(addrpair[len(addrpair)-1], len(addrpair))
-			//			if len(addrpair) == 3 {
-			//				domain := strings.SplitN(addrpair[1], `.i2p`, 2)[0]
 			rw.Write([]byte(ws.TrustChartSinglePage(addrpair[len(addrpair)-1])))
-			//			}
 		} else if strings.HasPrefix(rq.URL.Path, "/hostadd") {
-			if rate, ok := ws.limited[rq.RemoteAddr]; ok {
-				t := time.Now()
-				elapsed := t.Sub(rate)
-				if elapsed < time.Hour*12 {
-					return
+			registration, err := ws.Register(rq.FormValue("host_name"), rq.FormValue("host_destination"), rq.FormValue("host_description"), rq.RemoteAddr)
+			if err != nil {
+				status := http.StatusBadRequest
+				if refused, ok := err.(*RegistrationError); ok {
+					status = refused.Status
 				}
+				log.Printf("refused registration: %s", err)
+				http.Error(rw, err.Error(), status)
+				return
 			}
-			hostname := rq.FormValue("host_name")
-			destination := rq.FormValue("host_destination")
-			description := rq.FormValue("host_description")
-			added := ws.Queue.Append(hostname, destination, description)
-			if added {
-				ws.limited[rq.RemoteAddr] = time.Now()
-			}
-			log.Printf("client attempted to register: %s %s %s", hostname, destination, description)
+			fmt.Fprintln(rw, registration)
 		} else {
 			rw.Header().Add("Content-Type", "text/html")
 			tmp := strings.Split(rq.URL.Path, "/")
@@ -458,7 +576,10 @@ func (ws WebServer) ServeHTTP(rw http.ResponseWriter, rq *http.Request) {
 			if err != nil {
 				log.Printf("Template generation error, %s", err)
 			}
-			err = tmpl.Execute(rw, ws)
+			err = tmpl.Execute(rw, struct {
+				*WebServer
+				CSRFToken string
+			}{ws, nosurf.Token(rq)})
 			if err != nil {
 				log.Printf("Template execution error, %s", err)
 			}
@@ -466,58 +587,282 @@ func (ws WebServer) ServeHTTP(rw http.ResponseWriter, rq *http.Request) {
 	}
 }
 
-func NewWebServer(name, samaddr, keyspath, hostsfile string, peerslist []string, addr *i2pkeys.I2PAddr) (*WebServer, error) {
+func NewWebServer(name, hostsfile string, peers []PeerConfig, addr net.Addr, transport Transport) (*WebServer, error) {
 	var ws WebServer
 	var e error
-	ws.I2PAddr = addr
+	ws.addr = addr
+	if i2paddr, ok := addr.(i2pkeys.I2PAddr); ok {
+		ws.I2PAddr = &i2paddr
+	}
+	ws.Transport = transport
 	log.Println(ws.Base32())
-	ws.Me, e = NewI2PJump(hostsfile, samaddr, name, "")
-	ws.Queue, e = NewI2PJump(name+"-queue.txt", samaddr, name+"-queue", "")
+	ws.Me, e = NewI2PJump(hostsfile, "", name, "")
+	if e != nil {
+		return nil, e
+	}
+	ws.Queue, e = NewI2PJump(name+"-queue.txt", "", name+"-queue", "")
 	ws.Templates = make(map[string]string)
-	ws.Pals = make(map[string]string)
+	ws.pals = make(map[string]string)
+	ws.verified = make(map[string]time.Time)
 	ws.limited = make(map[string]time.Time)
+	ws.lookalikes = make(map[string][]Lookalike)
+	ws.hostsFile = hostsfile
 	ws.Templates["en"] = default_template
-	ws.samaddr = samaddr //"127.0.0.1:7656"
+	ws.registrationInterval = DefaultConfig().RateLimits.RegistrationInterval
+	ws.limits = DefaultConfig().Fetch.Limits
 	if e != nil {
 		return nil, e
 	}
+	if ws.History, e = LoadHistory(name + "-history.txt"); e != nil {
+		return nil, e
+	}
+	if ws.Transparency, e = LoadTransparencyLog(name + "-transparency.txt"); e != nil {
+		return nil, e
+	}
+	if e = ws.Transparency.Verify(); e != nil {
+		log.Printf("%s-transparency.txt: %s", name, e)
+	}
+	if ws.Expiry, e = LoadExpiry(name + "-expiry.txt"); e != nil {
+		return nil, e
+	}
+	if ws.Tickets, e = LoadTickets(name + "-tickets.txt"); e != nil {
+		return nil, e
+	}
+	ws.expiry = DefaultExpiryConfig()
+	if e = ws.loadAnnounces(); e != nil {
+		return nil, e
+	}
+	if ws.lookalikes, e = LoadLookalikes(ws.lookalikesFile()); e != nil {
+		return nil, e
+	}
+
+	ws.Scheduler = NewScheduler(DefaultConfig().Fetch.Workers)
+	for _, p := range peers {
+		if p.Disabled {
+			continue
+		}
+		peer, e := ws.newPeer(p)
+		if e != nil {
+			return nil, e
+		}
+		ws.peers = append(ws.peers, peer)
+		ws.Scheduler.Set(peer, p.Schedule)
+	}
+	return &ws, nil
+}
+
+// Start begins fetching the peers in the background.
+func (ws *WebServer) Start() {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	if ws.stop != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ws.stop = cancel
+	ws.stopped = make(chan struct{})
+	var running sync.WaitGroup
+	running.Add(3)
+	go func() {
+		ws.Scheduler.Run(ctx)
+		running.Done()
+	}()
+	go func() {
+		ws.runExpiry(ctx)
+		running.Done()
+	}()
+	go func() {
+		ws.runAnnounce(ctx)
+		running.Done()
+	}()
+	go func() {
+		running.Wait()
+		close(ws.stopped)
+	}()
+}
+
+// Stop cancels any fetches in progress, waits for them to give up or for ctx
+// to be done, and then flushes the queue and the announces to disk.
+func (ws *WebServer) Stop(ctx context.Context) error {
+	ws.mutex.Lock()
+	stop, stopped := ws.stop, ws.stopped
+	ws.mutex.Unlock()
+	var err error
+	if stop != nil {
+		stop()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	if ferr := ws.Flush(); ferr != nil {
+		return ferr
+	}
+	return err
+}
+
+func (ws *WebServer) announcesFile() string {
+	return ws.Me.Name + "-announces.txt"
+}
+
+// Flush writes the registration queue and the announced services to disk,
+// where NewWebServer picks them up again.
+func (ws *WebServer) Flush() error {
+	ws.writing.Lock()
+	defer ws.writing.Unlock()
+	if err := ioutil.WriteFile(ws.Me.Name+"-queue.txt", ws.Queue.Hosts().LocalFile(), 0644); err != nil {
+		return err
+	}
+	ws.mutex.RLock()
+	var announces []byte
+	for base32, hostname := range ws.pals {
+		line := base32 + " unverified " + url.QueryEscape(hostname)
+		if ws.isVerified(base32) {
+			line = base32 + " verified " + url.QueryEscape(hostname) + " " + strconv.FormatInt(ws.verified[base32].Unix(), 10)
+		}
+		announces = append(announces, []byte(line+"\n")...)
+	}
+	ws.mutex.RUnlock()
+	return ioutil.WriteFile(ws.announcesFile(), announces, 0644)
+}
 
-	for i, v := range peerslist {
-		V := strings.SplitN(v, "=", 2)
-		if len(V) == 2 {
-			peer, e := NewI2PJump(V[0]+".txt", samaddr, V[0], V[1])
-			if e != nil {
-				return nil, e
+// loadAnnounces reads back the announced services saved by Flush. A verified
+// announce saved without the time it was signed is loaded as unverified.
+func (ws *WebServer) loadAnnounces() error {
+	lines, err := ReadHostsFile(ws.announcesFile())
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 3 && len(fields) != 4 {
+			continue
+		}
+		hostname, err := url.QueryUnescape(fields[2])
+		if err != nil {
+			continue
+		}
+		ws.pals[fields[0]] = hostname
+		if fields[1] == "verified" && len(fields) == 4 {
+			if secs, err := strconv.ParseInt(fields[3], 10, 64); err == nil {
+				ws.verified[fields[0]] = time.Unix(secs, 0)
 			}
-			secs := (i * 3)
-			log.Println("Sleeping", secs, "seconds")
-			go func() {
-				time.Sleep(time.Second * time.Duration(secs))
-				e = peer.Fetch()
-				if e != nil {
-					log.Printf("Error fetching peer hosts.txt: %s %s", peer.Name, e.Error())
-					//					return nil, e
-				}
-				ws.Peers = append(ws.Peers, peer)
-			}()
 		}
+	}
+	return nil
+}
 
+func (ws *WebServer) newPeer(p PeerConfig) (*I2PJump, error) {
+	peer, e := NewI2PJump(p.Name+".txt", "", p.Name, p.URL)
+	if e != nil {
+		return nil, e
 	}
-	go ws.CheckLoop()
-	return &ws, nil
+	peer.Transport = ws.Transport
+	peer.SetLimits(ws.limits)
+	if e := pinPeer(peer, p); e != nil {
+		return nil, e
+	}
+	peer.OnUpdate = ws.checkFeed(peer.Name)
+	return peer, nil
+}
+
+// pinPeer loads the SU3 signer certificates configured for a peer and
+// pins it to its destination.
+func pinPeer(peer *I2PJump, p PeerConfig) error {
+	signers, e := LoadSU3Certificates(p.SU3Signers...)
+	if e != nil {
+		return fmt.Errorf("peer %s: %s", p.Name, e)
+	}
+	peer.SetSigners(signers)
+	peer.SetPinned(p.Destination)
+	return nil
+}
+
+// UpdatePeers replaces the list of peers with peers. Peers which are
+// unchanged keep the hosts already fetched from them and only take on their
+// new schedule, new ones are fetched as soon as the scheduler gets to them.
+// If any peer cannot be set up, the peers are left as they were.
+func (ws *WebServer) UpdatePeers(peers []PeerConfig) error {
+	ws.mutex.Lock()
+	defer ws.mutex.Unlock()
+	current := make(map[string]*I2PJump)
+	for _, peer := range ws.peers {
+		current[peer.Name] = peer
+	}
+	type update struct {
+		peer    *I2PJump
+		config  PeerConfig
+		signers map[string]crypto.PublicKey
+	}
+	var updates []update
+	for _, p := range peers {
+		if p.Disabled {
+			continue
+		}
+		peer, ok := current[p.Name]
+		if !ok || peer.MyURL.String() != p.URL {
+			var e error
+			if peer, e = ws.newPeer(p); e != nil {
+				return e
+			}
+		}
+		signers, e := LoadSU3Certificates(p.SU3Signers...)
+		if e != nil {
+			return fmt.Errorf("peer %s: %s", p.Name, e)
+		}
+		updates = append(updates, update{peer, p, signers})
+	}
+	var updated []*I2PJump
+	for _, u := range updates {
+		if current[u.config.Name] == u.peer {
+			u.peer.SetSigners(u.signers)
+			u.peer.SetPinned(u.config.Destination)
+		} else {
+			log.Println("Adding peer", u.peer.Name, u.peer.MyURL)
+		}
+		delete(current, u.config.Name)
+		ws.Scheduler.Set(u.peer, u.config.Schedule)
+		updated = append(updated, u.peer)
+	}
+	for name := range current {
+		log.Println("Removing peer", name)
+		ws.Scheduler.Remove(name)
+	}
+	ws.peers = updated
+	return nil
 }
 
 type I2PServer struct {
-	*sam3.StreamListener
+	net.Listener
 	*WebServer
-	Name      string
-	SAMAddr   string
-	KeysPath  string
-	HostsFile string
+	LocalListener net.Listener
+	Name          string
+	SAMAddr       string
+	KeysPath      string
+	HostsFile     string
+	limiter       *limiter.Limiter
+	lifecycle     sync.Mutex
+	servers       []*http.Server
+	errs          chan error
 }
 
-func (is *I2PServer) Serve() error {
-	limiter := tollbooth.NewLimiter(1, nil)
+// ListenLocal binds a local TCP address which the web interface is served on
+// in addition to the server's own listener.
+func (is *I2PServer) ListenLocal(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	is.LocalListener = l
+	log.Println("Serving locally on", l.Addr())
+	return nil
+}
+
+// Handler returns the web interface wrapped in rate limiting and CSRF
+// protection.
+func (is *I2PServer) Handler() http.Handler {
+	limiter := is.limiter
 	//1,
 	limiter.SetOnLimitReached(func(w http.ResponseWriter, r *http.Request) {
 		log.Println("LIMITER", r.URL.Path)
@@ -531,12 +876,90 @@ func (is *I2PServer) Serve() error {
 	})
 	configuredHandler := nosurf.New(tollbooth.LimitHandler(limiter, is.WebServer))
 	configuredHandler.ExemptPath("/announce")
-	return http.Serve(is.StreamListener, configuredHandler)
+	configuredHandler.ExemptPath("/api/v1/register")
+	return configuredHandler
+}
+
+// Start serves the web interface in the background on the server's
+// listener, and on the local listener if one was bound, and starts fetching
+// the peers.
+func (is *I2PServer) Start() error {
+	is.lifecycle.Lock()
+	defer is.lifecycle.Unlock()
+	if is.servers != nil {
+		return fmt.Errorf("%s is already started", is.Name)
+	}
+	listeners := []net.Listener{is.Listener}
+	if is.LocalListener != nil {
+		listeners = append(listeners, is.LocalListener)
+	}
+	handler := is.Handler()
+	is.errs = make(chan error, len(listeners))
+	for _, l := range listeners {
+		srv := &http.Server{Handler: handler}
+		is.servers = append(is.servers, srv)
+		go func(l net.Listener) {
+			err := srv.Serve(l)
+			if err == http.ErrServerClosed {
+				err = nil
+			}
+			is.errs <- err
+		}(l)
+	}
+	is.WebServer.Start()
+	return nil
+}
+
+// Serve starts the server and blocks until one of its listeners fails or it
+// is shut down.
+func (is *I2PServer) Serve() error {
+	if err := is.Start(); err != nil {
+		return err
+	}
+	return <-is.errs
 }
 
+// Shutdown stops the server: it stops accepting connections and waits for the
+// requests in progress to finish, stops fetching, flushes the registration
+// queue and announces to disk and closes the transport's connections. If ctx
+// is done first, whatever is still running is cut off.
+func (is *I2PServer) Shutdown(ctx context.Context) error {
+	is.lifecycle.Lock()
+	servers := is.servers
+	is.lifecycle.Unlock()
+	var errs []string
+	keep := func(err error) {
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if servers == nil {
+		keep(is.Listener.Close())
+		if is.LocalListener != nil {
+			keep(is.LocalListener.Close())
+		}
+	}
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			keep(err)
+			srv.Close()
+		}
+	}
+	keep(is.WebServer.Stop(ctx))
+	keep(is.Transport.Close())
+	if errs != nil {
+		return fmt.Errorf("shutting down %s: %s", is.Name, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// NewI2PServer creates a jump service which is served over I2P through the
+// SAM bridge at samaddr.
 func NewI2PServer(name, samaddr, keyspath, hostsfile string, peerslist []string) (*I2PServer, error) {
-	var is I2PServer
-	var e error
+	return newI2PServer(name, samaddr, keyspath, hostsfile, ParsePeers(peerslist))
+}
+
+func newI2PServer(name, samaddr, keyspath, hostsfile string, peers []PeerConfig) (*I2PServer, error) {
 	if name == "" {
 		name = "TODORANDOMNAME"
 	}
@@ -546,12 +969,27 @@ func NewI2PServer(name, samaddr, keyspath, hostsfile string, peerslist []string)
 	if keyspath == "" {
 		keyspath = "jump"
 	}
+	is, e := NewI2PServerFromTransport(name, hostsfile, peers, NewSAMTransport(name, samaddr, keyspath))
+	if e != nil {
+		return nil, e
+	}
+	is.SAMAddr = samaddr
+	is.KeysPath = keyspath
+	return is, nil
+}
+
+// NewI2PServerFromTransport creates a jump service which listens and fetches
+// its peers over transport.
+func NewI2PServerFromTransport(name, hostsfile string, peers []PeerConfig, transport Transport) (*I2PServer, error) {
+	var is I2PServer
+	var e error
+	if name == "" {
+		name = "TODORANDOMNAME"
+	}
 	if hostsfile == "" {
 		hostsfile = "hosts.txt"
 	}
 	is.Name = name
-	is.SAMAddr = samaddr
-	is.KeysPath = keyspath
 	is.HostsFile = hostsfile
 	if _, err := os.Stat(is.HostsFile); !os.IsNotExist(err) {
 		if _, err := os.Stat(is.HostsFile + ".orig"); os.IsNotExist(err) {
@@ -565,14 +1003,65 @@ func NewI2PServer(name, samaddr, keyspath, hostsfile string, peerslist []string)
 			}
 		}
 	}
-	is.StreamListener, e = sam.I2PListener(is.Name, is.SAMAddr, is.KeysPath)
+	is.Listener, e = transport.Listen()
 	if e != nil {
 		return nil, e
 	}
-	addr := is.StreamListener.Addr().(i2pkeys.I2PAddr)
-	is.WebServer, e = NewWebServer(name, samaddr, keyspath, hostsfile, peerslist, &addr)
+	is.WebServer, e = NewWebServer(name, hostsfile, peers, is.Listener.Addr(), transport)
 	if e != nil {
 		return nil, e
 	}
+	is.limiter = tollbooth.NewLimiter(DefaultConfig().RateLimits.RequestsPerSecond, nil)
 	return &is, e
 }
+
+// NewI2PServerFromConfig creates a jump service as described by cfg, over
+// plain TCP if it is configured to be local only and over SAM otherwise.
+func NewI2PServerFromConfig(cfg *Config) (*I2PServer, error) {
+	var is *I2PServer
+	var e error
+	if cfg.LocalOnly {
+		is, e = NewI2PServerFromTransport(cfg.Name, cfg.HostsFile, cfg.ResolvedPeers(), NewTCPTransport(cfg.Local, cfg.Hosts))
+	} else {
+		is, e = newI2PServer(cfg.Name, cfg.SAMAddr, cfg.KeysPath, cfg.HostsFile, cfg.ResolvedPeers())
+		if e == nil && cfg.Local != "" {
+			e = is.ListenLocal(cfg.Local)
+		}
+	}
+	if e != nil {
+		return nil, e
+	}
+	if cfg.SU3.Signer != "" {
+		key, cert := cfg.SU3.Key, cfg.SU3.Certificate
+		if key == "" {
+			key = cfg.Name + "-su3.key"
+		}
+		if cert == "" {
+			cert = cfg.Name + "-su3.crt"
+		}
+		if is.SU3, e = LoadSU3Signer(cfg.SU3.Signer, key, cert); e != nil {
+			return nil, e
+		}
+	}
+	is.applyConfig(cfg)
+	return is, nil
+}
+
+func (is *I2PServer) applyConfig(cfg *Config) {
+	is.limiter.SetMax(cfg.RateLimits.RequestsPerSecond)
+	is.limiter.SetBurst(int(math.Max(1, cfg.RateLimits.RequestsPerSecond)))
+	is.Configure(cfg)
+	is.Scheduler.SetWorkers(cfg.Fetch.Workers)
+}
+
+// Reload applies the parts of cfg which can change while the server is
+// running: the peers and their schedules, the rate limits, the admin
+// credentials and the jump services we announce ourselves to. The listeners
+// and the destination are left alone.
+func (is *I2PServer) Reload(cfg *Config) error {
+	if cfg.Name != is.Name || cfg.HostsFile != is.HostsFile || (is.SAMAddr != "" && (cfg.SAMAddr != is.SAMAddr || cfg.KeysPath != is.KeysPath)) {
+		log.Println("Changes to the name, SAM address, keys or hosts file take effect after a restart")
+	}
+	is.applyConfig(cfg)
+	return is.UpdatePeers(cfg.ResolvedPeers())
+}
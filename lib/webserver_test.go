@@ -0,0 +1,550 @@
+package jump
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eyedeekay/sam3/i2pkeys"
+)
+
+// writeHosts writes a hosts.txt in the test's temporary directory.
+func writeHosts(t *testing.T, name string, hosts map[string]i2pkeys.I2PAddr) string {
+	var body string
+	for host, dest := range hosts {
+		body += host + "=" + dest.Base64() + "\n"
+	}
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newJump(t *testing.T, name string, hosts map[string]i2pkeys.I2PAddr) *I2PJump {
+	j, err := NewI2PJump(writeHosts(t, name+".txt", hosts), "", name, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+func TestTrustCheck(t *testing.T) {
+	same, other, theirs, ours := newDest(t), newDest(t), newDest(t), newDest(t)
+	ws := &WebServer{
+		Me: newJump(t, "me", map[string]i2pkeys.I2PAddr{
+			"same.i2p":     same,
+			"disputed.i2p": same,
+			"ours.i2p":     ours,
+		}),
+		peers: []*I2PJump{
+			newJump(t, "one", map[string]i2pkeys.I2PAddr{
+				"same.i2p":     same,
+				"disputed.i2p": other,
+				"theirs.i2p":   theirs,
+			}),
+		},
+	}
+	cases := []struct {
+		host  string
+		voter string
+		agree int
+		vote  i2pkeys.I2PAddr
+	}{
+		{"same.i2p", "one", 1, same},
+		{"disputed.i2p", "one", 0, other},
+		{"theirs.i2p", "one", -1, theirs},
+		{"ours.i2p", "me", -2, ours},
+	}
+	for _, c := range cases {
+		agrees, votes, host := ws.TrustCheck(c.host)
+		if host != c.host {
+			t.Errorf("%s: TrustCheck returned host %s", c.host, host)
+		}
+		if len(agrees) != 1 {
+			t.Errorf("%s: expected one vote, got %v", c.host, agrees)
+		}
+		if agree, ok := agrees[c.voter]; !ok || agree != c.agree {
+			t.Errorf("%s: expected %s to vote %d, got %v", c.host, c.voter, c.agree, agrees)
+		}
+		if votes[c.voter] != c.vote.Base64() {
+			t.Errorf("%s: %s voted for the wrong destination", c.host, c.voter)
+		}
+	}
+	if agrees, _, _ := ws.TrustCheck("unknown.i2p"); len(agrees) != 0 {
+		t.Errorf("expected no votes on an unknown host, got %v", agrees)
+	}
+}
+
+// newServer starts a jump service on the bridge which mirrors peers and
+// returns it along with its Base32 address.
+func newServer(t *testing.T, hosts map[string]i2pkeys.I2PAddr, peers []string) (*I2PServer, string) {
+	needAccept(t)
+	dir := t.TempDir()
+	is, err := NewI2PServer(uniqueName("jump"), bridge.Addr(), filepath.Join(dir, "keys"), writeHosts(t, "hosts.txt", hosts), peers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start(t, is)
+	return is, is.Base32()
+}
+
+// start serves is until the test finishes.
+func start(t *testing.T, is *I2PServer) {
+	if err := is.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := is.Shutdown(context.Background()); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func get(t *testing.T, c *http.Client, u string) string {
+	resp, err := c.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: %s", u, resp.Status)
+	}
+	return string(body)
+}
+
+func post(t *testing.T, c *http.Client, u string, form url.Values) {
+	resp, err := c.PostForm(u, form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST %s: %s", u, resp.Status)
+	}
+}
+
+func TestServeTrustChart(t *testing.T) {
+	same := newDest(t)
+	peer := uniqueName("trustpeer")
+	servePeer(t, peer, "same.i2p="+same.Base64()+"\n")
+	is, b32 := newServer(t, map[string]i2pkeys.I2PAddr{"same.i2p": same}, []string{peer + "=http://" + peer + ".i2p/hosts.txt"})
+	waitFetched(t, is.Scheduler, peer)
+	c := newClient(t)
+	if hosts := get(t, c, "http://"+b32+"/peer-hosts.txt"); hosts != "same.i2p="+same.Base64()+"\n" {
+		t.Errorf("unexpected peer-hosts.txt: %q", hosts)
+	}
+	chart := get(t, c, "http://"+b32+"/trust")
+	if !strings.Contains(chart, "Hostname same.i2p") || !strings.Contains(chart, "Agrees with us") {
+		t.Errorf("trust chart does not show agreement about same.i2p: %s", chart)
+	}
+}
+
+var csrfField = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+func TestServeRegistration(t *testing.T) {
+	is, b32 := newServer(t, nil, nil)
+	c := newClient(t)
+	page := get(t, c, "http://"+b32+"/")
+	match := csrfField.FindStringSubmatch(page)
+	if match == nil {
+		t.Fatal("registration form has no CSRF token")
+	}
+	dest := newDest(t)
+	post(t, c, "http://"+b32+"/hostadd", url.Values{
+		"csrf_token":       {html.UnescapeString(match[1])},
+		"host_name":        {"registered.i2p"},
+		"host_destination": {dest.Base64()},
+		"host_description": {"a test registration"},
+	})
+	if got := is.Queue.ToMap()["registered.i2p"]; got != dest.Base64() {
+		t.Fatalf("registration was not queued, queue holds %q", got)
+	}
+	if strings.Contains(string(is.Me.HostsFile()), "registered.i2p") {
+		t.Error("queued registration was published before approval")
+	}
+}
+
+func TestServeRegistrationRefused(t *testing.T) {
+	dest := newDest(t)
+	is, err := NewI2PServerFromTransport(uniqueName("refusing"), writeHosts(t, "hosts.txt", map[string]i2pkeys.I2PAddr{"taken.i2p": dest}), nil, NewTCPTransport("127.0.0.1:0", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	register := func(name, destination, client string) *httptest.ResponseRecorder {
+		rq := httptest.NewRequest("POST", "/hostadd", strings.NewReader(url.Values{
+			"host_name":        {name},
+			"host_destination": {destination},
+		}.Encode()))
+		rq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rq.RemoteAddr = client
+		rw := httptest.NewRecorder()
+		is.WebServer.ServeHTTP(rw, rq)
+		return rw
+	}
+	for i, c := range []struct {
+		name, destination string
+		status            int
+		message           string
+	}{
+		{"proxy.i2p", dest.Base64(), http.StatusBadRequest, "reserved"},
+		{"no-suffix", dest.Base64(), http.StatusBadRequest, "must end in .i2p"},
+		{"-hyphen.i2p", dest.Base64(), http.StatusBadRequest, "hyphen"},
+		{"baddest.i2p", "not a destination", http.StatusBadRequest, "not a valid destination"},
+		{"Taken.i2p", dest.Base64(), http.StatusConflict, "already registered"},
+		{"tаken.i2p", dest.Base64(), http.StatusConflict, "could be mistaken for taken.i2p"},
+		{"xn--n3h.i2p", dest.Base64(), http.StatusBadRequest, "letters, digits and hyphens"},
+	} {
+		rw := register(c.name, c.destination, fmt.Sprintf("10.0.1.%d:1234", i))
+		if rw.Code != c.status || !strings.Contains(rw.Body.String(), c.message) {
+			t.Errorf("registering %q: %d %q, want %d mentioning %q", c.name, rw.Code, rw.Body.String(), c.status, c.message)
+		}
+	}
+	if len(is.Queue.ToMap()) != 0 {
+		t.Errorf("refused registrations were queued: %v", is.Queue.ToMap())
+	}
+	if rw := register("Fresh.i2p", dest.Base64(), "10.0.2.1:1234"); rw.Code != http.StatusOK {
+		t.Fatalf("valid registration refused: %d %q", rw.Code, rw.Body.String())
+	}
+	if _, ok := is.Queue.ToMap()["fresh.i2p"]; !ok {
+		t.Error("registration was not queued under its normalized name")
+	}
+	if rw := register("again.i2p", dest.Base64(), "10.0.2.1:1234"); rw.Code != http.StatusTooManyRequests {
+		t.Errorf("second registration from one client: %d, want %d", rw.Code, http.StatusTooManyRequests)
+	}
+	if rw := register("Bücher.i2p", dest.Base64(), "10.0.2.2:1234"); rw.Code != http.StatusOK || !strings.Contains(rw.Body.String(), "bücher.i2p (xn--bcher-kva.i2p)") {
+		t.Errorf("Unicode registration: %d %q", rw.Code, rw.Body.String())
+	}
+	if _, ok := is.Queue.ToMap()["xn--bcher-kva.i2p"]; !ok {
+		t.Error("Unicode registration was not queued in its Punycode form")
+	}
+}
+
+func TestJump(t *testing.T) {
+	dest := newDest(t)
+	is, err := NewI2PServerFromTransport(uniqueName("jumping"), writeHosts(t, "hosts.txt", map[string]i2pkeys.I2PAddr{"xn--bcher-kva.i2p": dest}), nil, NewTCPTransport("127.0.0.1:0", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range []string{"/jump?a=xn--bcher-kva.i2p", "/cgi-bin/jump.cgi?a=B%C3%BCcher.i2p", "/jump?a=b%C3%BCcher"} {
+		rw := httptest.NewRecorder()
+		is.WebServer.ServeHTTP(rw, httptest.NewRequest("GET", target, nil))
+		want := "http://xn--bcher-kva.i2p/?i2paddresshelper=" + dest.Base64()
+		if rw.Code != http.StatusMovedPermanently || rw.Header().Get("Location") != want {
+			t.Errorf("%s: %d to %q, want a redirect to %q", target, rw.Code, rw.Header().Get("Location"), want)
+		}
+	}
+	rw := httptest.NewRecorder()
+	is.WebServer.ServeHTTP(rw, httptest.NewRequest("GET", "/jump?a=unknown.i2p", nil))
+	if rw.Code != http.StatusNotFound {
+		t.Errorf("unknown host: %d", rw.Code)
+	}
+
+	for query, found := range map[string]bool{"B%C3%9CCH": true, "bcher-kva": true, "b%C3%BCcher.i2p": true, "zzz": false} {
+		rw := httptest.NewRecorder()
+		is.WebServer.ServeHTTP(rw, httptest.NewRequest("GET", "/search?q="+query, nil))
+		if strings.Contains(rw.Body.String(), "bücher.i2p (xn--bcher-kva.i2p)</a>") != found {
+			t.Errorf("searching for %s: %s", query, rw.Body.String())
+		}
+	}
+}
+
+func TestServeAnnounce(t *testing.T) {
+	is, b32 := newServer(t, nil, nil)
+	site := servePeer(t, uniqueName("announced"), "")
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	sig, err := bridge.Sign(site, AnnounceMessage(b32, "announced", now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	elsewhere, err := bridge.Sign(site, AnnounceMessage("another.b32.i2p", "announced", now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	old, err := bridge.Sign(site, AnnounceMessage(b32, "announced", stale))
+	if err != nil {
+		t.Fatal(err)
+	}
+	announce := "http://" + b32 + "/announce"
+	bad := "A" + sig[1:]
+	if sig[0] == 'A' {
+		bad = "B" + sig[1:]
+	}
+
+	for _, rejected := range []struct{ time, sig string }{{now, bad}, {now, elsewhere}, {stale, old}} {
+		post(t, newClient(t), announce, url.Values{
+			"host_name": {"announced"},
+			"host_host": {site.Base32()},
+			"host_time": {rejected.time},
+			"host_sig":  {rejected.sig},
+		})
+		if _, ok := is.Pals()[site.Base32()]; ok {
+			t.Fatalf("announce signed at %s as %s was accepted", rejected.time, rejected.sig)
+		}
+	}
+
+	post(t, newClient(t), announce, url.Values{
+		"host_name": {"announced"},
+		"host_host": {site.Base32()},
+		"host_time": {now},
+		"host_sig":  {sig},
+	})
+	if is.Pals()[site.Base32()] != "announced" || !is.Verified()[site.Base32()] {
+		t.Fatalf("signed announce was not recorded as verified: %v %v", is.Pals(), is.Verified())
+	}
+
+	post(t, newClient(t), announce, url.Values{
+		"host_name": {"impostor"},
+		"host_host": {site.Base32()},
+	})
+	if is.Pals()[site.Base32()] != "announced" {
+		t.Errorf("unsigned announce replaced a verified one: %v", is.Pals())
+	}
+
+	if page := get(t, newClient(t), "http://"+b32+"/"); !strings.Contains(page, "(verified)") {
+		t.Error("verified announce is not marked on the home page")
+	}
+
+	replayed, err := bridge.Sign(site, AnnounceMessage(b32, "replayed", now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	post(t, newClient(t), announce, url.Values{
+		"host_name": {"replayed"},
+		"host_host": {site.Base32()},
+		"host_time": {now},
+		"host_sig":  {replayed},
+	})
+	if is.Pals()[site.Base32()] != "announced" {
+		t.Errorf("announce signed no later than the last one was accepted: %v", is.Pals())
+	}
+
+	is.mutex.Lock()
+	is.verified[site.Base32()] = time.Now().Add(-verifiedLifetime)
+	is.mutex.Unlock()
+	if is.Verified()[site.Base32()] {
+		t.Fatal("signed announce stayed verified past its lifetime")
+	}
+	post(t, newClient(t), announce, url.Values{
+		"host_name": {"renamed"},
+		"host_host": {site.Base32()},
+	})
+	if is.Pals()[site.Base32()] != "renamed" {
+		t.Errorf("unsigned announce did not replace an expired verified one: %v", is.Pals())
+	}
+}
+
+// TestConcurrentServing exercises the web interface from many goroutines
+// while peers are fetched and replaced, and is meant to be run with -race.
+func TestConcurrentServing(t *testing.T) {
+	dest := newDest(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, rq *http.Request) {
+		rw.Write([]byte("busy.i2p=" + dest.Base64() + "\n"))
+	}))
+	defer srv.Close()
+	cfg := DefaultConfig()
+	cfg.Name = uniqueName("busy")
+	cfg.HostsFile = writeHosts(t, "hosts.txt", map[string]i2pkeys.I2PAddr{"busy.i2p": dest})
+	cfg.Local = "127.0.0.1:0"
+	cfg.LocalOnly = true
+	cfg.Fetch.Workers = 4
+	cfg.Fetch.Interval = time.Millisecond * 10
+	cfg.Fetch.Jitter = time.Millisecond
+	cfg.Peers = []PeerConfig{{Name: uniqueName("busy"), URL: srv.URL + "/hosts.txt"}}
+	is, err := NewI2PServerFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start(t, is)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; n < 20; n++ {
+				var rq *http.Request
+				switch n % 5 {
+				case 0:
+					rq = httptest.NewRequest("GET", "/trust", nil)
+				case 1:
+					rq = httptest.NewRequest("GET", "/peer-hosts.txt", nil)
+				case 2:
+					rq = httptest.NewRequest("POST", "/hostadd", strings.NewReader(url.Values{
+						"host_name":        {fmt.Sprintf("new%d-%d.i2p", i, n)},
+						"host_destination": {dest.Base64()},
+					}.Encode()))
+					rq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+					rq.RemoteAddr = fmt.Sprintf("10.0.0.%d:1234", n)
+				case 3:
+					rq = httptest.NewRequest("POST", "/announce", strings.NewReader(url.Values{
+						"host_name": {"pal"},
+						"host_host": {"127.0.0.1:80"},
+					}.Encode()))
+					rq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				default:
+					rq = httptest.NewRequest("GET", "/", nil)
+				}
+				is.WebServer.ServeHTTP(httptest.NewRecorder(), rq)
+			}
+		}(i)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for n := 0; n < 10; n++ {
+			cfg.Peers = append(cfg.Peers, PeerConfig{Name: uniqueName("busy"), URL: srv.URL + "/hosts.txt"})
+			if err := is.Reload(cfg); err != nil {
+				t.Error(err)
+			}
+			is.Scheduler.FetchAll()
+		}
+	}()
+	wg.Wait()
+	if len(is.Peers()) != 11 {
+		t.Errorf("expected 11 peers, have %d", len(is.Peers()))
+	}
+	if len(is.Queue.ToMap()) == 0 {
+		t.Error("no registrations were queued")
+	}
+	if len(is.Pals()) != 1 {
+		t.Errorf("expected one announced pal, have %v", is.Pals())
+	}
+}
+
+// slowLookup holds up lookups until it is released, to keep an announce in
+// flight.
+type slowLookup struct {
+	*TCPTransport
+	started chan struct{}
+	release chan struct{}
+}
+
+func (t *slowLookup) Lookup(host string) (net.Addr, error) {
+	t.started <- struct{}{}
+	<-t.release
+	return t.TCPTransport.Lookup(host)
+}
+
+func TestShutdown(t *testing.T) {
+	dest := newDest(t)
+	name := uniqueName("shutdown")
+	hosts := writeHosts(t, "hosts.txt", nil)
+	transport := &slowLookup{NewTCPTransport("127.0.0.1:0", nil), make(chan struct{}, 1), make(chan struct{})}
+	is, err := NewI2PServerFromTransport(name, hosts, nil, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := is.Start(); err != nil {
+		t.Fatal(err)
+	}
+	rq := httptest.NewRequest("POST", "/hostadd", strings.NewReader(url.Values{
+		"host_name":        {"queued.i2p"},
+		"host_destination": {dest.Base64()},
+	}.Encode()))
+	rq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	is.WebServer.ServeHTTP(httptest.NewRecorder(), rq)
+	signed := time.Now().Add(-time.Hour).Truncate(time.Second)
+	is.mutex.Lock()
+	is.pals["signed.b32.i2p"] = "a signed pal"
+	is.verified["signed.b32.i2p"] = signed
+	is.mutex.Unlock()
+
+	announced := make(chan error, 1)
+	go func() {
+		resp, err := http.PostForm("http://"+is.Base32()+"/announce", url.Values{
+			"host_name": {"a pal"},
+			"host_host": {"127.0.0.1:80"},
+		})
+		if err == nil {
+			resp.Body.Close()
+		}
+		announced <- err
+	}()
+	<-transport.started
+	done := make(chan error, 1)
+	go func() {
+		done <- is.Shutdown(context.Background())
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("shutdown did not wait for the request in flight: %v", err)
+	case <-time.After(time.Millisecond * 200):
+	}
+	close(transport.release)
+	if err := <-announced; err != nil {
+		t.Fatalf("request in flight during shutdown: %s", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("shutdown did not finish")
+	}
+	if _, err := net.Dial("tcp", is.Base32()); err == nil {
+		t.Error("still accepting connections after shutdown")
+	}
+
+	restarted, err := NewI2PServerFromTransport(name, hosts, nil, NewTCPTransport("127.0.0.1:0", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start(t, restarted)
+	if restarted.Queue.ToMap()["queued.i2p"] != dest.Base64() {
+		t.Error("registration queue was not flushed on shutdown")
+	}
+	if restarted.Pals()["127.0.0.1:80"] != "a pal" {
+		t.Errorf("announces were not flushed on shutdown: %v", restarted.Pals())
+	}
+	if !restarted.Verified()["signed.b32.i2p"] || !restarted.verified["signed.b32.i2p"].Equal(signed) {
+		t.Errorf("signed announce time was not flushed on shutdown: %v", restarted.verified)
+	}
+}
+
+func TestShutdownClosesSessions(t *testing.T) {
+	peer := uniqueName("peer")
+	servePeer(t, peer, "")
+	is, err := NewI2PServer(uniqueName("jump"), bridge.Addr(), filepath.Join(t.TempDir(), "keys"), writeHosts(t, "hosts.txt", nil), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := is.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := is.Transport.Dial(context.Background(), peer+".i2p"); err != nil {
+		t.Fatal(err)
+	}
+	if err := is.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		open := bridgeSessions(is.Name)
+		if len(open) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("sessions left open after shutdown: %v", open)
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+}
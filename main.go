@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"i2pgit.org/idk/jump-transparency/lib"
 )
@@ -16,18 +21,93 @@ var (
 	hostsfile = flag.String("hostsfile", "hosts.txt", "Where to store the hosts file")
 	peers     = flag.String("peers", "root=http://i2p-projekt.i2p/hosts.txt,identiguy=http://identiguy.i2p/hosts.txt,notbob=http://nytzrhrjjfsutowojvxi7hphesskpqqr65wpistz6wa7cpajhp7a.b32.i2p//hosts.txt,inr=http://inr.i2p/alive-hosts.txt,isitup=http://isitup.i2p/hosts.txt,reg=http://reg.i2p/hosts.txt", "Comma-separated list of the other I2P jump services in the form \"peerone=http://peerone.i2p/hosts.txt,peertwo=http://peerone.i2p/hosts.txt\"")
 	announce  = flag.String("announce", "", "Comma-separated list of other Jump-Transparency jump services to \"announce\" ourselves to for publicity purposes.")
+	local     = flag.String("local", "", "Local TCP address to also serve the web interface on, e.g. 127.0.0.1:7670")
+	localonly = flag.Bool("localonly", false, "Serve and fetch only over plain TCP on the -local address, without connecting to SAM")
+	config    = flag.String("config", "", "YAML configuration file to use instead of the other flags, reloaded on SIGHUP")
+	grace     = flag.Duration("grace", time.Second*30, "How long to wait for requests and fetches to finish when shutting down")
 )
 
+// flagConfig builds the configuration from the command-line flags.
+func flagConfig() *jump.Config {
+	cfg := jump.DefaultConfig()
+	cfg.Name = *name
+	cfg.SAMAddr = *samaddr
+	cfg.KeysPath = *keyspath
+	cfg.HostsFile = *hostsfile
+	cfg.Local = *local
+	cfg.LocalOnly = *localonly
+	cfg.Peers = jump.ParsePeers(strings.Split(*peers, ","))
+	if *announce != "" {
+		cfg.Announce = strings.Split(*announce, ",")
+	}
+	return cfg
+}
+
+// reloadOnHangup reloads the configuration file into j whenever the process
+// receives SIGHUP.
+func reloadOnHangup(j *jump.I2PServer, file string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		log.Println("Reloading configuration from", file)
+		cfg, e := jump.LoadConfig(file)
+		if e != nil {
+			log.Println("Configuration not reloaded:", e)
+			continue
+		}
+		if e := j.Reload(cfg); e != nil {
+			log.Println("Configuration not fully reloaded:", e)
+		}
+	}
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if cmd := lookupCommand(os.Args[1]); cmd != nil {
+			if e := cmd.run(os.Args[2:]); e != nil {
+				log.Fatal(e)
+			}
+			return
+		}
+	}
+	flag.Usage = usage
 	flag.Parse()
-	peerslist := strings.Split(*peers, ",")
-	j, e := jump.NewI2PServer(*name, *samaddr, *keyspath, *hostsfile, peerslist)
+	cfg := flagConfig()
+	if *config != "" {
+		var e error
+		cfg, e = jump.LoadConfig(*config)
+		if e != nil {
+			log.Fatal(e)
+		}
+	} else if e := cfg.Validate(); e != nil {
+		log.Fatal(e)
+	}
+	j, e := jump.NewI2PServerFromConfig(cfg)
 	if e != nil {
 		log.Fatal(e)
 	}
+	if *config != "" {
+		go reloadOnHangup(j, *config)
+	}
 	if *serve {
-		if e = j.Serve(); e != nil {
-			log.Fatal(e)
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		errs := make(chan error, 1)
+		go func() {
+			errs <- j.Serve()
+		}()
+		select {
+		case e = <-errs:
+		case sig := <-stop:
+			log.Println("Shutting down on", sig)
 		}
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), *grace)
+	defer cancel()
+	if e := j.Shutdown(ctx); e != nil {
+		log.Println(e)
+	}
+	if e != nil {
+		log.Fatal(e)
+	}
 }